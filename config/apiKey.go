@@ -11,15 +11,36 @@ type Config struct {
 	Alpha       string
 	Twele       string
 	TweleCandle string
+	AdminKey    string
+	// cash yield as a percent APY, CashAPYTiers is "balance:apy,..." and wins when set
+	CashAPY      string
+	CashAPYTiers string
+	// virtual funding, amounts in USD. Empty caps mean no limit.
+	StartingCapital string
+	MaxDeposit      string
+	MaxWithdrawal   string
+	// MaxTotalDeposits caps deposits less withdrawals since the last reset
+	MaxTotalDeposits string
+	// PriceHubMode "redis" shares one set of upstream price feeds between
+	// replicas, anything else connects every instance on its own
+	PriceHubMode string
 }
 
 func LoadConfig() *Config {
 	return &Config{
-		CoinGecko:   os.Getenv("CoinGecko"),
-		FMP:         os.Getenv("FMP"),
-		FinHub:      os.Getenv("FINHUB"),
-		Alpha:       os.Getenv("ALPHAVANTAGE"),
-		Twele:       os.Getenv("TWELE_DATA"),
-		TweleCandle: os.Getenv("TWELE_DATA_CANDLES"),
+		CoinGecko:        os.Getenv("CoinGecko"),
+		FMP:              os.Getenv("FMP"),
+		FinHub:           os.Getenv("FINHUB"),
+		Alpha:            os.Getenv("ALPHAVANTAGE"),
+		Twele:            os.Getenv("TWELE_DATA"),
+		TweleCandle:      os.Getenv("TWELE_DATA_CANDLES"),
+		AdminKey:         os.Getenv("ADMIN_KEY"),
+		CashAPY:          os.Getenv("CASH_APY"),
+		CashAPYTiers:     os.Getenv("CASH_APY_TIERS"),
+		StartingCapital:  os.Getenv("STARTING_CAPITAL"),
+		MaxDeposit:       os.Getenv("MAX_DEPOSIT"),
+		MaxWithdrawal:    os.Getenv("MAX_WITHDRAWAL"),
+		MaxTotalDeposits: os.Getenv("MAX_TOTAL_DEPOSITS"),
+		PriceHubMode:     os.Getenv("PRICE_HUB_MODE"),
 	}
 }
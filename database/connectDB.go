@@ -25,7 +25,8 @@ func ConnectToDB() {
 	}
 
 	connStr := os.Getenv("DATABASE_URL")
-	db, err := gorm.Open(postgres.Open(connStr), &gorm.Config{})
+	// TranslateError turns driver errors like unique violations into gorm's
+	db, err := gorm.Open(postgres.Open(connStr), &gorm.Config{TranslateError: true})
 
 	if err != nil {
 		log.Fatal("failed to connect to databse", err.Error())
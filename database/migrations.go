@@ -7,5 +7,5 @@ import (
 )
 
 func DbMigrations(db *gorm.DB) error {
-	return db.AutoMigrate(&models.UserModel{}, &models.Wallet{}, &models.Holding{}, &models.Transaction{}, &models.Watchlist{}, &models.Leaderboard{})
+	return db.AutoMigrate(&models.UserModel{}, &models.Wallet{}, &models.Holding{}, &models.Transaction{}, &models.Watchlist{}, &models.Leaderboard{}, &models.Follow{}, &models.RankSnapshot{}, &models.PortfolioSnapshot{}, &models.PositionSnapshot{}, &models.TaxLot{}, &models.LotDisposal{}, &models.CorporateAction{}, &models.InterestAccrual{}, &models.JournalEntry{}, &models.JournalLine{}, &models.CashBalance{}, &models.CandleBar{}, &models.HistoricalCandle{})
 }
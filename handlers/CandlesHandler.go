@@ -1,11 +1,14 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"jfernsio/stonksbackend/config"
 	"jfernsio/stonksbackend/utils"
 	"log"
+	"net/url"
 	"sort"
 	"strconv"
 	"strings"
@@ -17,20 +20,62 @@ import (
 )
 
 type Candle struct {
-	Time   string  `json:"time"` // yyyy-mm-dd or yyyy-mm-ddThh:mm:ss
-	Open   float64 `json:"open"`
-	High   float64 `json:"high"`
-	Low    float64 `json:"low"`
-	Close  float64 `json:"close"`
-	Volume float64 `json:"volume"`
+	Time string `json:"time"` // yyyy-mm-dd for daily and longer bars, RFC 3339 in UTC for intraday
+	// Timestamp is the bar's start in unix seconds
+	Timestamp int64   `json:"timestamp"`
+	Open      float64 `json:"open"`
+	High      float64 `json:"high"`
+	Low       float64 `json:"low"`
+	Close     float64 `json:"close"`
+	Volume    float64 `json:"volume"`
 }
 
 type Provider interface {
 	Name() string
+	// Intervals lists the bar sizes the provider serves, the first is the default
+	Intervals() []string
 	BuildURL(symbol, interval string, cfg *config.Config) string
 	ParseResponse(body []byte) ([]Candle, error)
 }
 
+// supportsInterval reports whether provider serves interval
+func supportsInterval(provider Provider, interval string) bool {
+	for _, supported := range provider.Intervals() {
+		if supported == interval {
+			return true
+		}
+	}
+	return false
+}
+
+// timedCandle builds a candle starting at t. Daily and longer bars are dated,
+// intraday bars carry their full time in UTC.
+func timedCandle(t time.Time, daily bool, open, high, low, close, volume float64) Candle {
+	layout := time.RFC3339
+	if daily {
+		layout = "2006-01-02"
+	}
+	return Candle{
+		Time:      t.UTC().Format(layout),
+		Timestamp: t.Unix(),
+		Open:      open,
+		High:      high,
+		Low:       low,
+		Close:     close,
+		Volume:    volume,
+	}
+}
+
+// parseProviderTime reads a provider's "2006-01-02" date or "2006-01-02 15:04:05"
+// time. Times are local to loc, the exchange's timezone, and dates have no zone.
+func parseProviderTime(s string, loc *time.Location) (t time.Time, daily bool, err error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.UTC); err == nil {
+		return t, true, nil
+	}
+	t, err = time.ParseInLocation("2006-01-02 15:04:05", s, loc)
+	return t.UTC(), false, err
+}
+
 var httpClient = fiberclient.New().
 	SetTimeout(12 * time.Second).
 	SetUserAgent("stonksbackend/2.0 (compatible; +https://github.com/jfernsio/stonksbackend)") // optional
@@ -50,6 +95,9 @@ type AlphaVantageProvider struct{}
 
 func (p AlphaVantageProvider) Name() string { return "alphavantage" }
 
+// Intervals is only daily, the free tier has no intraday series
+func (p AlphaVantageProvider) Intervals() []string { return []string{"1day"} }
+
 func (p AlphaVantageProvider) BuildURL(symbol, _ string, cfg *config.Config) string {
 	return fmt.Sprintf(
 		"https://www.alphavantage.co/query?function=TIME_SERIES_DAILY&symbol=%s&outputsize=compact&apikey=%s",
@@ -78,19 +126,19 @@ func (p AlphaVantageProvider) ParseResponse(body []byte) ([]Candle, error) {
 
 	candles := make([]Candle, 0, len(resp.TimeSeries))
 	for date, d := range resp.TimeSeries {
-		candles = append(candles, Candle{
-			Time:   date,
-			Open:   mustParseFloat(d.Open),
-			High:   mustParseFloat(d.High),
-			Low:    mustParseFloat(d.Low),
-			Close:  mustParseFloat(d.Close),
-			Volume: mustParseFloat(d.Volume),
-		})
+		t, err := time.ParseInLocation("2006-01-02", date, time.UTC)
+		if err != nil {
+			log.Printf("invalid alphavantage date %q, skipping", date)
+			continue
+		}
+		candles = append(candles, timedCandle(t, true,
+			mustParseFloat(d.Open), mustParseFloat(d.High), mustParseFloat(d.Low),
+			mustParseFloat(d.Close), mustParseFloat(d.Volume)))
 	}
 
 	// Alpha Vantage compact usually returns newest first → reverse to oldest first
 	sort.Slice(candles, func(i, j int) bool {
-		return candles[i].Time < candles[j].Time
+		return candles[i].Timestamp < candles[j].Timestamp
 	})
 
 	return candles, nil
@@ -100,6 +148,10 @@ type TwelveDataProvider struct{}
 
 func (p TwelveDataProvider) Name() string { return "twelvedata" }
 
+func (p TwelveDataProvider) Intervals() []string {
+	return []string{"1day", "1min", "5min", "15min", "30min", "45min", "1h", "2h", "4h", "1week", "1month"}
+}
+
 func (p TwelveDataProvider) BuildURL(symbol, interval string, cfg *config.Config) string {
 	if interval == "" {
 		interval = "1day"
@@ -110,8 +162,50 @@ func (p TwelveDataProvider) BuildURL(symbol, interval string, cfg *config.Config
 	)
 }
 
+// BuildRangeURL asks for the bars between from and to. Twelve Data reads the
+// bounds in the exchange's timezone, which isn't known up front, so they're
+// widened by a day to cover any offset. The extra bars are stored all the same.
+func (p TwelveDataProvider) BuildRangeURL(symbol, interval string, from, to time.Time, cfg *config.Config) string {
+	if interval == "" {
+		interval = "1day"
+	}
+	const layout = "2006-01-02 15:04:05"
+	from, to = from.Add(-24*time.Hour), to.Add(24*time.Hour)
+	return fmt.Sprintf(
+		"https://api.twelvedata.com/time_series?symbol=%s&interval=%s&start_date=%s&end_date=%s&outputsize=%d&apikey=%s",
+		symbol, interval, url.QueryEscape(from.UTC().Format(layout)), url.QueryEscape(to.UTC().Format(layout)), maxCandleRangeBars, cfg.TweleCandle,
+	)
+}
+
+// BuildCountURL asks for the n bars up to to. Like BuildRangeURL the bound is
+// widened by a day, so as many more bars are asked for as fit in a day.
+func (p TwelveDataProvider) BuildCountURL(symbol, interval string, to time.Time, n int, cfg *config.Config) string {
+	if interval == "" {
+		interval = "1day"
+	}
+	if to.IsZero() {
+		return fmt.Sprintf(
+			"https://api.twelvedata.com/time_series?symbol=%s&interval=%s&outputsize=%d&apikey=%s",
+			symbol, interval, min(n, maxCandleRangeBars), cfg.TweleCandle,
+		)
+	}
+	const layout = "2006-01-02 15:04:05"
+	if width, ok := candleWidths[interval]; ok && width < 24*time.Hour {
+		n += int(24 * time.Hour / width)
+	} else {
+		n++
+	}
+	return fmt.Sprintf(
+		"https://api.twelvedata.com/time_series?symbol=%s&interval=%s&end_date=%s&outputsize=%d&apikey=%s",
+		symbol, interval, url.QueryEscape(to.Add(24*time.Hour).UTC().Format(layout)), min(n, maxCandleRangeBars), cfg.TweleCandle,
+	)
+}
+
 func (p TwelveDataProvider) ParseResponse(body []byte) ([]Candle, error) {
 	var resp struct {
+		Meta struct {
+			ExchangeTimezone string `json:"exchange_timezone"`
+		} `json:"meta"`
 		Values []struct {
 			Datetime string `json:"datetime"`
 			Open     string `json:"open"`
@@ -130,85 +224,182 @@ func (p TwelveDataProvider) ParseResponse(body []byte) ([]Candle, error) {
 		return nil, fmt.Errorf("no values in response")
 	}
 
-	candles := make([]Candle, len(resp.Values))
-	for i, v := range resp.Values {
-		// Twelve Data usually returns newest first too
-		candles[i] = Candle{
-			Time:   strings.Split(v.Datetime, " ")[0], // keep only date if time is present
-			Open:   mustParseFloat(v.Open),
-			High:   mustParseFloat(v.High),
-			Low:    mustParseFloat(v.Low),
-			Close:  mustParseFloat(v.Close),
-			Volume: mustParseFloat(v.Volume),
+	// intraday datetimes are in the exchange's local time
+	loc := time.UTC
+	if tz := resp.Meta.ExchangeTimezone; tz != "" {
+		if exchange, err := time.LoadLocation(tz); err == nil {
+			loc = exchange
+		} else {
+			log.Printf("unknown exchange timezone %q, reading times as UTC", tz)
+		}
+	}
+
+	candles := make([]Candle, 0, len(resp.Values))
+	for _, v := range resp.Values {
+		t, daily, err := parseProviderTime(v.Datetime, loc)
+		if err != nil {
+			log.Printf("invalid twelvedata datetime %q, skipping", v.Datetime)
+			continue
 		}
+		candles = append(candles, timedCandle(t, daily,
+			mustParseFloat(v.Open), mustParseFloat(v.High), mustParseFloat(v.Low),
+			mustParseFloat(v.Close), mustParseFloat(v.Volume)))
 	}
 
-	// Make oldest → newest
+	// Twelve Data usually returns newest first too, make oldest → newest
 	sort.Slice(candles, func(i, j int) bool {
-		return candles[i].Time < candles[j].Time
+		return candles[i].Timestamp < candles[j].Timestamp
 	})
 
 	return candles, nil
 }
 
-func GetHistoryGeneric(provider Provider, cachePrefix string) fiber.Handler {
-	return func(c *fiber.Ctx) error {
-		symbol := strings.ToUpper(c.Params("symbol"))
-		if symbol == "" {
-			return c.Status(400).JSON(fiber.Map{"error": "symbol is required"})
-		}
+// ErrInvalidUpstream wraps provider responses that couldn't be parsed into candles
+var ErrInvalidUpstream = errors.New("invalid upstream response")
+
+// UpstreamError is returned by FetchCandles when the provider answers with a non 200 status
+type UpstreamError struct {
+	Status int
+	Body   string
+}
 
-		interval := c.Query("interval") // used only by twelvedata
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("upstream error: status %d", e.Status)
+}
 
-		cacheKey := fmt.Sprintf("%s:%s:%s", cachePrefix, symbol, interval)
+// fetchProviderCandles requests url from the provider and parses the candles
+func fetchProviderCandles(provider Provider, url string) ([]Candle, error) {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body()
 
-		ctx := c.Context()
+	if resp.StatusCode() != 200 {
+		return nil, &UpstreamError{Status: resp.StatusCode(), Body: string(resp.Body())}
+	}
 
-		// 1. Try cache
-		cached, err := config.Redis.Client.Get(ctx, cacheKey).Bytes()
-		if err == nil && len(cached) > 0 {
-			data, decErr := utils.GzipDecompress(cached)
-			if decErr == nil {
-				return c.JSON(fiber.Map{"data": json.RawMessage(data)})
+	candles, err := provider.ParseResponse(resp.Body())
+	if err != nil {
+		log.Printf("%s parse error: %v", provider.Name(), err)
+		return nil, fmt.Errorf("%w: %v", ErrInvalidUpstream, err)
+	}
+	return candles, nil
+}
+
+// FetchCandles returns candles for symbol from the provider, going through the
+// gzip compressed redis cache
+func FetchCandles(ctx context.Context, provider Provider, cachePrefix, symbol, interval string, cfg *config.Config) ([]Candle, error) {
+	cacheKey := fmt.Sprintf("%s:%s:%s", cachePrefix, symbol, interval)
+
+	// 1. Try cache
+	cached, err := config.Redis.Client.Get(ctx, cacheKey).Bytes()
+	if err == nil && len(cached) > 0 {
+		data, decErr := utils.GzipDecompress(cached)
+		if decErr == nil {
+			var candles []Candle
+			if err := json.Unmarshal(data, &candles); err == nil {
+				return candles, nil
 			}
+		} else {
 			log.Printf("decompress failed: %v", decErr)
 		}
+	}
 
-		// 2. Fetch
-		cfg := c.Locals("config").(*config.Config)
-		url := provider.BuildURL(symbol, interval, cfg)
+	// 2. Fetch, parse & normalize
+	candles, err := fetchProviderCandles(provider, provider.BuildURL(symbol, interval, cfg))
+	if err != nil {
+		return nil, err
+	}
 
-		resp, err := httpClient.Get(url)
-		if err != nil {
-			return c.Status(502).JSON(fiber.Map{"error": "upstream fetch failed"})
+	// 4. Serialize → compress → cache
+	plainJSON, _ := json.Marshal(candles) // almost never fails here
+
+	compressed, compErr := utils.GzipCompress(plainJSON)
+	if compErr == nil {
+		_ = config.Redis.Client.Set(ctx, cacheKey, compressed, 45*time.Minute).Err()
+		// 45 min is usually good balance — AlphaVantage has rate limits
+	}
+
+	return candles, nil
+}
+
+func GetHistoryGeneric(provider Provider, cachePrefix string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		symbol := strings.ToUpper(c.Params("symbol"))
+		if symbol == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "symbol is required"})
 		}
-		defer resp.Body()
 
-		if resp.StatusCode() != 200 {
-			return c.Status(502).JSON(fiber.Map{
-				"error":  "upstream error",
-				"status": resp.StatusCode(),
-				"body":   string(resp.Body()),
+		interval := c.Query("interval", provider.Intervals()[0])
+		interval, width, ok := candleInterval(interval)
+		if !ok || !supportsInterval(provider, interval) {
+			return c.Status(400).JSON(fiber.Map{
+				"error":     fmt.Sprintf("unsupported interval %q", interval),
+				"intervals": provider.Intervals(),
 			})
 		}
 
-		// 3. Parse & normalize
-		candles, err := provider.ParseResponse(resp.Body())
-		if err != nil {
-			log.Printf("%s parse error: %v", provider.Name(), err)
-			return c.Status(502).JSON(fiber.Map{"error": "invalid upstream response"})
+		// ?from= picks a range up to ?to= or now, otherwise the latest
+		// defaultCandleBars bars up to ?to= or now
+		var from, to time.Time
+		var err error
+		if s := c.Query("to"); s != "" {
+			if to, err = parseRangeBound(s); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "invalid to"})
+			}
+		}
+		if s := c.Query("from"); s != "" {
+			if from, err = parseRangeBound(s); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "invalid from"})
+			}
+			if to.IsZero() {
+				to = time.Now().UTC()
+			}
+			if !from.Before(to) {
+				return c.Status(400).JSON(fiber.Map{"error": "from must be before to"})
+			}
+			if to.Sub(from)/width > maxCandleRangeBars {
+				return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("range is limited to %d bars", maxCandleRangeBars)})
+			}
 		}
+		ranged := !from.IsZero()
+		latest := !ranged && to.IsZero()
 
-		// 4. Serialize → compress → cache
-		plainJSON, _ := json.Marshal(candles) // almost never fails here
+		cfg := c.Locals("config").(*config.Config)
+		// recent intraday bars come from live trades, earlier ones from the provider
+		if latest {
+			if candles, ok := liveCandles(c.Context(), provider, symbol, interval, defaultCandleBars, cfg); ok {
+				return c.JSON(fiber.Map{"data": candles, "source": "live"})
+			}
+		}
 
-		compressed, compErr := utils.GzipCompress(plainJSON)
-		if compErr == nil {
-			_ = config.Redis.Client.Set(ctx, cacheKey, compressed, 45*time.Minute).Err()
-			// 45 min is usually good balance — AlphaVantage has rate limits
+		var candles []Candle
+		if ranged {
+			candles, err = StoredCandles(c.Context(), provider, symbol, interval, from, to, cfg)
+		} else {
+			candles, err = LatestStoredCandles(c.Context(), provider, symbol, interval, to, defaultCandleBars, cfg)
+		}
+		var upstreamErr *UpstreamError
+		if err != nil && latest && !errors.As(err, &upstreamErr) && !errors.Is(err, ErrInvalidUpstream) {
+			// the store is unavailable, the cached provider response will do
+			log.Printf("Candle store failed for %s, fetching directly: %v", symbol, err)
+			candles, err = FetchCandles(c.Context(), provider, cachePrefix, symbol, interval, cfg)
+		}
+		if err != nil {
+			if errors.As(err, &upstreamErr) {
+				return c.Status(502).JSON(fiber.Map{
+					"error":  "upstream error",
+					"status": upstreamErr.Status,
+					"body":   upstreamErr.Body,
+				})
+			}
+			if errors.Is(err, ErrInvalidUpstream) {
+				return c.Status(502).JSON(fiber.Map{"error": "invalid upstream response"})
+			}
+			return c.Status(502).JSON(fiber.Map{"error": "upstream fetch failed"})
 		}
 
-		// 5. Return
 		return c.JSON(fiber.Map{"data": candles})
 	}
 }
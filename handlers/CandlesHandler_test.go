@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTimedCandle(t *testing.T) {
+	fmt.Println("Starting unit tests for CandlesHandler.go")
+	fmt.Println("Testing timedCandle function")
+
+	start := time.Date(2024, 3, 1, 14, 35, 0, 0, time.UTC)
+	intraday := timedCandle(start, false, 1, 2, 0.5, 1.5, 10)
+	if intraday.Time != "2024-03-01T14:35:00Z" || intraday.Timestamp != start.Unix() {
+		t.Fatalf("Expected 2024-03-01T14:35:00Z at %d, got %s at %d", start.Unix(), intraday.Time, intraday.Timestamp)
+	}
+	if daily := timedCandle(start, true, 1, 2, 0.5, 1.5, 10); daily.Time != "2024-03-01" {
+		t.Fatalf("Expected 2024-03-01, got %s", daily.Time)
+	}
+}
+
+func TestTwelveDataParseResponse(t *testing.T) {
+	fmt.Println("Testing TwelveDataProvider ParseResponse function")
+
+	body := []byte(`{
+		"meta": {"symbol": "AAPL", "interval": "1h", "exchange_timezone": "America/New_York"},
+		"values": [
+			{"datetime": "2024-03-01 10:30:00", "open": "2", "high": "3", "low": "1", "close": "2.5", "volume": "100"},
+			{"datetime": "2024-03-01 09:30:00", "open": "1", "high": "2", "low": "1", "close": "2", "volume": "50"}
+		]
+	}`)
+	candles, err := TwelveDataProvider{}.ParseResponse(body)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(candles) != 2 {
+		t.Fatalf("Expected 2 candles, got %d", len(candles))
+	}
+	// 9:30 in New York in March is 14:30 UTC, and bars are oldest first
+	if candles[0].Time != "2024-03-01T14:30:00Z" || candles[1].Time != "2024-03-01T15:30:00Z" {
+		t.Fatalf("Expected 14:30Z then 15:30Z, got %s then %s", candles[0].Time, candles[1].Time)
+	}
+
+	daily, err := TwelveDataProvider{}.ParseResponse([]byte(`{"meta": {"exchange_timezone": "America/New_York"},
+		"values": [{"datetime": "2024-03-01", "open": "1", "high": "1", "low": "1", "close": "1", "volume": "1"}]}`))
+	if err != nil || daily[0].Time != "2024-03-01" {
+		t.Fatalf("Expected the date 2024-03-01 kept, got %v (%v)", daily, err)
+	}
+}
+
+func TestSupportsInterval(t *testing.T) {
+	fmt.Println("Testing supportsInterval function")
+
+	if !supportsInterval(TwelveDataProvider{}, "5min") {
+		t.Fatalf("Expected twelvedata to support 5min, got unsupported")
+	}
+	if supportsInterval(AlphaVantageProvider{}, "5min") {
+		t.Fatalf("Expected alphavantage to only support 1day, got 5min supported")
+	}
+	for _, interval := range (TwelveDataProvider{}).Intervals() {
+		if _, _, ok := candleInterval(interval); !ok {
+			t.Fatalf("Expected the store to accept %s, got rejected", interval)
+		}
+	}
+}
@@ -0,0 +1,381 @@
+package handlers
+
+import (
+	"context"
+	"jfernsio/stonksbackend/config"
+	"jfernsio/stonksbackend/database"
+	"jfernsio/stonksbackend/models"
+	"jfernsio/stonksbackend/utils"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/shopspring/decimal"
+)
+
+// closeSeries is a symbol's daily closes in date order
+type closeSeries struct {
+	Dates  []string
+	Closes []float64
+}
+
+// at returns the last close on or before date, forward filling weekends and holidays
+func (s closeSeries) at(date string) (float64, bool) {
+	i := sort.SearchStrings(s.Dates, date)
+	if i < len(s.Dates) && s.Dates[i] == date {
+		return s.Closes[i], true
+	}
+	if i == 0 {
+		return 0, false
+	}
+	return s.Closes[i-1], true
+}
+
+// equityCurve is a wallet's reconstructed daily value
+type equityCurve struct {
+	Dates  []string
+	Values []float64
+	Cash   []float64
+	// Flows are external cash flows into the wallet on each date
+	Flows []float64
+}
+
+// candleSymbol maps a holding symbol to the symbol twelve data expects
+func candleSymbol(symbol string, holdingType models.HoldingType) string {
+	if holdingType == models.CRYPTO || holdingType == models.FIAT {
+		return symbol + "/USD"
+	}
+	return symbol
+}
+
+// fetchCloses loads daily closes for a candle provider symbol from the candle
+// store, which only asks twelve data for what it hasn't kept, so a portfolio
+// of many symbols doesn't spend a request on each of them every time
+func fetchCloses(ctx context.Context, symbol string, cfg *config.Config) (closeSeries, error) {
+	candles, err := LatestStoredCandles(ctx, TwelveDataProvider{}, symbol, "1day", time.Time{}, defaultCandleBars, cfg)
+	if err != nil {
+		return closeSeries{}, err
+	}
+	series := closeSeries{
+		Dates:  make([]string, len(candles)),
+		Closes: make([]float64, len(candles)),
+	}
+	for i, candle := range candles {
+		series.Dates[i] = candle.Time
+		series.Closes[i] = candle.Close
+	}
+	return series, nil
+}
+
+// txDate returns the New York calendar date of a transaction
+func txDate(tx models.Transaction) string {
+	return tx.CreatedAt.In(utils.NewYork()).Format("2006-01-02")
+}
+
+// cashDelta is how much a transaction changed the wallet's cash balance
+func cashDelta(tx models.Transaction) decimal.Decimal {
+	switch tx.Type {
+	case models.Buy, models.Drip, models.Withdrawal, models.FxBuy:
+		return tx.TotalAmount.Neg()
+	case models.Sell, models.Dividend, models.Interest, models.Deposit, models.Reset, models.FxSell:
+		return tx.TotalAmount
+	}
+	return decimal.Zero
+}
+
+// positionDelta is how much a transaction changed the quantity held of its symbol
+func positionDelta(tx models.Transaction) decimal.Decimal {
+	switch tx.Type {
+	case models.Buy, models.Split, models.Drip, models.FxBuy:
+		return tx.Quantity
+	case models.Sell, models.FxSell:
+		return tx.Quantity.Neg()
+	}
+	return decimal.Zero
+}
+
+// externalFlow is the cash a transaction moved into the wallet from outside.
+// A reset counts as funding the new account with its starting capital.
+func externalFlow(tx models.Transaction) decimal.Decimal {
+	switch tx.Type {
+	case models.Deposit, models.Reset:
+		return tx.TotalAmount
+	case models.Withdrawal:
+		return tx.TotalAmount.Neg()
+	}
+	return decimal.Zero
+}
+
+// walletTransactions returns every transaction of the wallet since its last
+// reset, oldest first
+func walletTransactions(walletID uint) ([]models.Transaction, error) {
+	var transactions []models.Transaction
+	err := database.Database.Db.
+		Where("wallet_id = ? AND archived_at IS NULL", walletID).
+		Order("created_at, id").
+		Find(&transactions).Error
+	return transactions, err
+}
+
+// holdingTypes guesses each traded symbol's asset class from current holdings,
+// falling back to stock for symbols that were sold out. Foreign cash is priced
+// as a currency pair and base cash isn't priced at all.
+func holdingTypes(walletID uint, transactions []models.Transaction) (map[string]models.HoldingType, error) {
+	var holdings []models.Holding
+	if err := database.Database.Db.Where("wallet_id = ?", walletID).Find(&holdings).Error; err != nil {
+		return nil, err
+	}
+	types := make(map[string]models.HoldingType)
+	for _, h := range holdings {
+		types[h.Symbol] = h.Type
+	}
+	for _, tx := range transactions {
+		if tx.Type == models.FxBuy || tx.Type == models.FxSell {
+			types[tx.Symbol] = models.FIAT
+		}
+	}
+	for _, tx := range transactions {
+		if _, ok := types[tx.Symbol]; !ok && tx.Symbol != "" && tx.Symbol != cashSymbol {
+			types[tx.Symbol] = models.STOCK
+		}
+	}
+	return types, nil
+}
+
+// symbolsOfType lists the symbols of one asset class
+func symbolsOfType(types map[string]models.HoldingType, holdingType models.HoldingType) []string {
+	symbols := []string{}
+	for symbol, t := range types {
+		if t == holdingType {
+			symbols = append(symbols, symbol)
+		}
+	}
+	return symbols
+}
+
+// buildEquityCurve replays the wallet's transactions over calendar and values
+// the positions held at the end of each day at that day's close. Cash is
+// walked backwards from the current balance so the starting capital doesn't
+// need to be known.
+func buildEquityCurve(ctx context.Context, cfg *config.Config, wallet models.Wallet, transactions []models.Transaction, calendar []string) (equityCurve, error) {
+	types, err := holdingTypes(wallet.ID, transactions)
+	if err != nil {
+		return equityCurve{}, err
+	}
+
+	closes := make(map[string]closeSeries, len(types))
+	for symbol, holdingType := range types {
+		series, err := fetchCloses(ctx, candleSymbol(symbol, holdingType), cfg)
+		if err != nil {
+			// fall back to trade prices for symbols without candles
+			continue
+		}
+		closes[symbol] = series
+	}
+
+	// the closes are split adjusted, so quantities are replayed in today's
+	// shares and the split transactions themselves are skipped
+	var splitActions []models.CorporateAction
+	if err := database.Database.Db.
+		Where("type = ? AND symbol IN ? AND ex_date <= ?", models.SplitAction, symbolsOfType(types, models.STOCK), time.Now()).
+		Find(&splitActions).Error; err != nil {
+		return equityCurve{}, err
+	}
+	splits := make(map[string][]models.CorporateAction)
+	for _, split := range splitActions {
+		splits[split.Symbol] = append(splits[split.Symbol], split)
+	}
+
+	totalCashDelta := decimal.Zero
+	for _, tx := range transactions {
+		totalCashDelta = totalCashDelta.Add(cashDelta(tx))
+	}
+
+	curve := equityCurve{
+		Dates:  calendar,
+		Values: make([]float64, len(calendar)),
+		Cash:   make([]float64, len(calendar)),
+		Flows:  make([]float64, len(calendar)),
+	}
+
+	quantities := make(map[string]decimal.Decimal)
+	lastTradePrice := make(map[string]float64)
+	appliedCashDelta := decimal.Zero
+	next := 0
+	for i, date := range calendar {
+		flow := decimal.Zero
+		for next < len(transactions) && txDate(transactions[next]) <= date {
+			tx := transactions[next]
+			appliedCashDelta = appliedCashDelta.Add(cashDelta(tx))
+			flow = flow.Add(externalFlow(tx))
+			if tx.Symbol != "" && tx.Type != models.Split {
+				factor := splitFactor(splits[tx.Symbol], tx.CreatedAt)
+				quantities[tx.Symbol] = quantities[tx.Symbol].Add(positionDelta(tx).Mul(factor))
+				if !tx.PricePerUnit.IsZero() {
+					lastTradePrice[tx.Symbol] = tx.PricePerUnit.Div(factor).InexactFloat64()
+				}
+			}
+			next++
+		}
+
+		cash := wallet.Balance.Sub(totalCashDelta.Sub(appliedCashDelta))
+		value := cash.InexactFloat64()
+		for symbol, qty := range quantities {
+			if qty.IsZero() {
+				continue
+			}
+			price, ok := closes[symbol].at(date)
+			if !ok {
+				price = lastTradePrice[symbol]
+			}
+			value += qty.InexactFloat64() * price
+		}
+
+		curve.Values[i] = value
+		curve.Cash[i] = cash.InexactFloat64()
+		curve.Flows[i] = flow.InexactFloat64()
+	}
+
+	return curve, nil
+}
+
+// analyticsCalendar returns the benchmark's trading days between since and now
+func analyticsCalendar(benchmark closeSeries, since string) (dates []string, closes []float64) {
+	for i, date := range benchmark.Dates {
+		if date >= since {
+			dates = append(dates, date)
+			closes = append(closes, benchmark.Closes[i])
+		}
+	}
+	return dates, closes
+}
+
+func round4(f float64) float64 {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0
+	}
+	return math.Round(f*10000) / 10000
+}
+
+// GetPortfolioAnalytics returns risk and performance metrics for the caller's
+// portfolio over ?range= (default 1Y) against ?benchmark= (default SPY).
+// Ratios are fractions, e.g. 0.12 is 12%.
+func GetPortfolioAnalytics(c *fiber.Ctx) error {
+	cfg := c.Locals("config").(*config.Config)
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	rng := strings.ToUpper(c.Query("range", "1Y"))
+	since, ok := utils.RangeStart(rng, time.Now())
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid range"})
+	}
+	benchmarkSymbol := strings.ToUpper(c.Query("benchmark", "SPY"))
+	riskFree, err := strconv.ParseFloat(c.Query("risk_free", "0"), 64)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid risk_free rate"})
+	}
+
+	var wallet models.Wallet
+	if err := database.Database.Db.Where("user_id = ?", userID).First(&wallet).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Wallet not found"})
+	}
+
+	transactions, err := walletTransactions(wallet.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch transactions"})
+	}
+	if len(transactions) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "No trades yet"})
+	}
+
+	ctx := c.Context()
+	benchmark, err := fetchCloses(ctx, benchmarkCandleSymbol(benchmarkSymbol), cfg)
+	if err != nil {
+		return c.Status(502).JSON(fiber.Map{"error": "Failed to fetch benchmark prices"})
+	}
+
+	// start from whichever is later, the range or the first trade
+	start := since.In(utils.NewYork()).Format("2006-01-02")
+	if first := txDate(transactions[0]); first > start {
+		start = first
+	}
+	calendar, benchmarkCloses := analyticsCalendar(benchmark, start)
+	if len(calendar) < 2 {
+		return c.Status(400).JSON(fiber.Map{"error": "Not enough history in range"})
+	}
+
+	curve, err := buildEquityCurve(ctx, cfg, wallet, transactions, calendar)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to build equity curve"})
+	}
+
+	returns := utils.DailyReturns(curve.Values, curve.Flows)
+	benchmarkReturns := utils.DailyReturns(benchmarkCloses, nil)
+
+	// money weighted: the opening value and every deposit go in, the closing value comes out
+	last := len(calendar) - 1
+	startDate, _ := time.Parse("2006-01-02", calendar[0])
+	endDate, _ := time.Parse("2006-01-02", calendar[last])
+	flows := []utils.CashFlow{{Time: startDate, Amount: -curve.Values[0]}}
+	for i := 1; i <= last; i++ {
+		if curve.Flows[i] != 0 {
+			date, _ := time.Parse("2006-01-02", calendar[i])
+			flows = append(flows, utils.CashFlow{Time: date, Amount: -curve.Flows[i]})
+		}
+	}
+	flows = append(flows, utils.CashFlow{Time: endDate, Amount: curve.Values[last]})
+	mwr, mwrOK := utils.XIRR(flows)
+
+	// trade outcomes in range for streaks
+	var results []float64
+	for _, tx := range transactions {
+		if tx.Type == models.Sell && txDate(tx) >= start {
+			results = append(results, tx.RealizedPnL.InexactFloat64())
+		}
+	}
+	longestWin, longestLoss, currentStreak := utils.Streaks(results)
+
+	bestDay, worstDay := 0.0, 0.0
+	for _, r := range returns {
+		bestDay = math.Max(bestDay, r)
+		worstDay = math.Min(worstDay, r)
+	}
+
+	var moneyWeighted interface{}
+	if mwrOK {
+		moneyWeighted = round4(mwr)
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data": fiber.Map{
+			"range":                 rng,
+			"benchmark":             benchmarkSymbol,
+			"start_date":            calendar[0],
+			"end_date":              calendar[last],
+			"trading_days":          len(calendar),
+			"start_value":           round4(curve.Values[0]),
+			"end_value":             round4(curve.Values[last]),
+			"time_weighted_return":  round4(utils.TimeWeightedReturn(returns)),
+			"money_weighted_return": moneyWeighted,
+			"benchmark_return":      round4(utils.TimeWeightedReturn(benchmarkReturns)),
+			"volatility":            round4(utils.AnnualizedVolatility(returns)),
+			"sharpe_ratio":          round4(utils.SharpeRatio(returns, riskFree)),
+			"sortino_ratio":         round4(utils.SortinoRatio(returns, riskFree)),
+			"max_drawdown":          round4(utils.MaxDrawdown(returns)),
+			"beta":                  round4(utils.Beta(returns, benchmarkReturns)),
+			"best_day":              round4(bestDay),
+			"worst_day":             round4(worstDay),
+			"trades":                len(results),
+			"longest_win_streak":    longestWin,
+			"longest_loss_streak":   longestLoss,
+			"current_streak":        currentStreak,
+		},
+	})
+}
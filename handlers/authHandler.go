@@ -12,7 +12,6 @@ import (
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/redis/go-redis/v9"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
@@ -50,11 +49,26 @@ func UserSignup(c *fiber.Ctx) error {
 		}
 		return fiber.NewError(fiber.StatusInternalServerError, "Failed to create user")
 	}
+	startingCapital := fundingLimits(c).StartingCapital
 	wallet := models.Wallet{
-		UserID: user.ID,
+		UserID:  user.ID,
+		Balance: startingCapital,
 	}
 
-	if err := database.Database.Db.Create(&wallet).Error; err != nil {
+	//create wallet and book its starting cash in the ledger
+	err = database.Database.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&wallet).Error; err != nil {
+			return err
+		}
+		//gorm can leave a zero balance out of the insert and the column default
+		//would grant 100000, so the starting capital is written explicitly
+		if err := tx.Model(&wallet).Update("balance", startingCapital).Error; err != nil {
+			return err
+		}
+		wallet.Balance = startingCapital
+		return postOpeningBalance(tx, wallet, models.JournalSignup, "signup grant")
+	})
+	if err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "Failed to create wallet")
 	}
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
@@ -102,26 +116,20 @@ func UserLogin(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusInternalServerError, "Token generation failed")
 	}
 
-	// Initialize user in leaderboard with default balance (100000)
 	ctx := c.Context()
-	defaultBalance := "100000"
-
-	// Add user to sorted set with their balance as score
-	if err := config.Redis.Client.ZAdd(ctx, "leaderboard:all_time", redis.Z{
-		Score:  100000,
-		Member: user.ID,
-	}).Err(); err != nil {
-		log.Printf("Failed to add user to leaderboard: %v", err)
-	}
 
 	// Store username in hash for quick lookup
 	if err := config.Redis.Client.HSet(ctx, "leaderboard:usernames", fmt.Sprint(user.ID), user.UserName).Err(); err != nil {
 		log.Printf("Failed to store username in leaderboard: %v", err)
 	}
 
-	// Store balance separately for easy access
-	if err := config.Redis.Client.HSet(ctx, "leaderboard:balances", fmt.Sprint(user.ID), defaultBalance).Err(); err != nil {
-		log.Printf("Failed to store balance in leaderboard: %v", err)
+	// Put the user on the leaderboard with what their portfolio is actually
+	// worth, since starting capital is configurable and accounts can be funded
+	var wallet models.Wallet
+	if err := database.Database.Db.Where("user_id = ?", user.ID).First(&wallet).Error; err == nil {
+		go RefreshUserScore(user.ID, wallet, c.Locals("config").(*config.Config).FinHub)
+	} else {
+		log.Printf("Failed to load wallet for user %d: %v", user.ID, err)
 	}
 
 	c.Cookie(&fiber.Cookie{
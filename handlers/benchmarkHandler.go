@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"jfernsio/stonksbackend/config"
+	"jfernsio/stonksbackend/database"
+	"jfernsio/stonksbackend/models"
+	"jfernsio/stonksbackend/utils"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// benchmarks priced against USD instead of as a stock ticker
+var cryptoBenchmarks = map[string]bool{"BTC": true, "ETH": true, "SOL": true}
+
+type BenchmarkPoint struct {
+	Date            string  `json:"date"`
+	PortfolioValue  float64 `json:"portfolio_value"`
+	BenchmarkValue  float64 `json:"benchmark_value"`
+	PortfolioReturn float64 `json:"portfolio_return"`
+	BenchmarkReturn float64 `json:"benchmark_return"`
+}
+
+// benchmarkTrade is cash moved into (positive) or out of (negative) the
+// benchmark on the i-th calendar day
+type benchmarkTrade struct {
+	Day    int
+	Amount float64
+}
+
+// benchmarkCandleSymbol maps a benchmark such as SPY or BTC to a candle symbol
+func benchmarkCandleSymbol(symbol string) string {
+	if cryptoBenchmarks[symbol] {
+		return candleSymbol(symbol, models.CRYPTO)
+	}
+	return symbol
+}
+
+// replayBenchmark values a hypothetical portfolio that sent every trade's cash
+// into the benchmark instead. Sells can't raise more than the benchmark units
+// held are worth, so a stock picker's gains don't leak into the index series.
+// flows are external deposits (positive) or withdrawals per calendar day.
+func replayBenchmark(closes []float64, cash, units float64, trades []benchmarkTrade, flows []float64) []float64 {
+	values := make([]float64, len(closes))
+	next := 0
+	for i, price := range closes {
+		if flows != nil {
+			cash += flows[i]
+		}
+		for next < len(trades) && trades[next].Day <= i {
+			amount := trades[next].Amount
+			if amount > 0 {
+				units += amount / price
+				cash -= amount
+			} else if price > 0 {
+				proceeds := math.Min(-amount, units*price)
+				units -= proceeds / price
+				cash += proceeds
+			}
+			next++
+		}
+		values[i] = cash + units*price
+	}
+	return values
+}
+
+// cumulativeReturns chains daily returns into a running return from the first day
+func cumulativeReturns(values, flows []float64) []float64 {
+	cumulative := make([]float64, len(values))
+	growth := 1.0
+	for i, r := range utils.DailyReturns(values, flows) {
+		growth *= 1 + r
+		cumulative[i+1] = growth - 1
+	}
+	return cumulative
+}
+
+// GetBenchmarkComparison compares the caller's portfolio with what it would be
+// worth had every buy and sell gone into ?symbol= (default SPY) instead, over
+// ?range= (default 1Y)
+func GetBenchmarkComparison(c *fiber.Ctx) error {
+	cfg := c.Locals("config").(*config.Config)
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	rng := strings.ToUpper(c.Query("range", "1Y"))
+	since, ok := utils.RangeStart(rng, time.Now())
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid range"})
+	}
+	symbol := strings.ToUpper(c.Query("symbol", "SPY"))
+
+	var wallet models.Wallet
+	if err := database.Database.Db.Where("user_id = ?", userID).First(&wallet).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Wallet not found"})
+	}
+
+	transactions, err := walletTransactions(wallet.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch transactions"})
+	}
+	if len(transactions) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "No trades yet"})
+	}
+
+	ctx := c.Context()
+	benchmark, err := fetchCloses(ctx, benchmarkCandleSymbol(symbol), cfg)
+	if err != nil {
+		return c.Status(502).JSON(fiber.Map{"error": "Failed to fetch benchmark prices"})
+	}
+
+	start := since.In(utils.NewYork()).Format("2006-01-02")
+	if first := txDate(transactions[0]); first > start {
+		start = first
+	}
+	calendar, closes := analyticsCalendar(benchmark, start)
+	if len(calendar) < 2 {
+		return c.Status(400).JSON(fiber.Map{"error": "Not enough history in range"})
+	}
+
+	curve, err := buildEquityCurve(ctx, cfg, wallet, transactions, calendar)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to build equity curve"})
+	}
+
+	// trades up to the first day are already part of the starting portfolio,
+	// whose holdings are swapped for benchmark units at that day's close
+	var trades []benchmarkTrade
+	day := 0
+	for _, tx := range transactions {
+		date := txDate(tx)
+		if date <= calendar[0] {
+			continue
+		}
+		for day < len(calendar)-1 && calendar[day] < date {
+			day++
+		}
+		if calendar[day] < date {
+			// after the last close, not in the series yet
+			break
+		}
+		// currency conversions move cash between currencies, they aren't
+		// investments the benchmark would mirror
+		switch tx.Type {
+		case models.Buy, models.Drip:
+			trades = append(trades, benchmarkTrade{Day: day, Amount: tx.TotalAmount.InexactFloat64()})
+		case models.Sell:
+			trades = append(trades, benchmarkTrade{Day: day, Amount: -tx.TotalAmount.InexactFloat64()})
+		}
+	}
+
+	startUnits := (curve.Values[0] - curve.Cash[0]) / closes[0]
+	benchmarkValues := replayBenchmark(closes, curve.Cash[0], startUnits, trades, zeroFirst(curve.Flows))
+
+	portfolioReturns := cumulativeReturns(curve.Values, curve.Flows)
+	benchmarkReturns := cumulativeReturns(benchmarkValues, curve.Flows)
+
+	points := make([]BenchmarkPoint, len(calendar))
+	for i, date := range calendar {
+		points[i] = BenchmarkPoint{
+			Date:            date,
+			PortfolioValue:  round4(curve.Values[i]),
+			BenchmarkValue:  round4(benchmarkValues[i]),
+			PortfolioReturn: round4(portfolioReturns[i]),
+			BenchmarkReturn: round4(benchmarkReturns[i]),
+		}
+	}
+
+	last := len(calendar) - 1
+	return c.JSON(fiber.Map{
+		"status":    "success",
+		"range":     rng,
+		"benchmark": symbol,
+		"data":      points,
+		"summary": fiber.Map{
+			"portfolio_return": round4(portfolioReturns[last]),
+			"benchmark_return": round4(benchmarkReturns[last]),
+			"outperformance":   round4(portfolioReturns[last] - benchmarkReturns[last]),
+			"portfolio_value":  round4(curve.Values[last]),
+			"benchmark_value":  round4(benchmarkValues[last]),
+		},
+	})
+}
+
+// zeroFirst copies flows without the first day, which is already part of the starting value
+func zeroFirst(flows []float64) []float64 {
+	out := make([]float64, len(flows))
+	copy(out, flows)
+	if len(out) > 0 {
+		out[0] = 0
+	}
+	return out
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestReplayBenchmark(t *testing.T) {
+	fmt.Println("Starting unit tests for benchmarkHandler.go")
+	fmt.Println("Testing replayBenchmark function")
+
+	closes := []float64{100, 110, 121, 110}
+	trades := []benchmarkTrade{
+		// buy 500 worth on day 1
+		{Day: 1, Amount: 500},
+		// try to sell 1000 worth on day 3, only 500 worth of units are held
+		{Day: 3, Amount: -1000},
+	}
+
+	values := replayBenchmark(closes, 1000, 0, trades, nil)
+	want := []float64{1000, 1000, 1050, 1000}
+	for i := range want {
+		if math.Abs(values[i]-want[i]) > 1e-6 {
+			t.Fatalf("Expected value on day %d to be %v, got %v", i, want[i], values[i])
+		}
+	}
+}
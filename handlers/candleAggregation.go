@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"context"
+	"jfernsio/stonksbackend/config"
+	"jfernsio/stonksbackend/database"
+	"jfernsio/stonksbackend/models"
+	"jfernsio/stonksbackend/utils"
+	"log"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// at most this many aggregated bars are served, as many as the provider would give
+const aggregatedCandleLimit = defaultCandleBars
+
+// candleAggregator builds intraday bars from the price hub, nil until
+// StartCandleAggregator
+var candleAggregator *utils.Aggregator
+
+// StartCandleAggregator builds 1min, 5min and 1h bars from every tick the hub
+// streams and persists them as they close. Close the aggregator on shutdown
+// to keep the bars in progress.
+func StartCandleAggregator(hub *utils.Hub) *utils.Aggregator {
+	candleAggregator = utils.NewAggregator(hub, saveCandleBars)
+	go candleAggregator.Run()
+	return candleAggregator
+}
+
+// saveCandleBars upserts closed bars. When a bar is written twice, by two
+// replicas or across a restart, the copy built from more trades wins.
+func saveCandleBars(bars []utils.Bar) {
+	rows := make([]models.CandleBar, len(bars))
+	for i, bar := range bars {
+		rows[i] = models.CandleBar{
+			Symbol:   bar.Symbol,
+			Interval: bar.Interval,
+			Start:    time.UnixMilli(bar.Start).UTC(),
+			Open:     bar.Open,
+			High:     bar.High,
+			Low:      bar.Low,
+			Close:    bar.Close,
+			Volume:   bar.Volume,
+			Trades:   bar.Trades,
+		}
+	}
+
+	err := database.Database.Db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "symbol"}, {Name: "bar_interval"}, {Name: "start"}},
+		DoUpdates: clause.AssignmentColumns([]string{"open", "high", "low", "close", "volume", "trades"}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Expr{SQL: "excluded.trades > candle_bars.trades"},
+		}},
+	}).CreateInBatches(&rows, 500).Error
+	if err != nil {
+		log.Printf("Failed to save %d candle bars: %v", len(rows), err)
+	}
+}
+
+// aggregatedCandles returns the latest back to back run of bars built from
+// live trades, oldest first, with the bar in progress last. ok is false when
+// the interval isn't aggregated or the bars have fallen behind.
+func aggregatedCandles(symbol, interval string, now time.Time) ([]Candle, bool) {
+	width, aggregated := utils.CandleIntervals[interval]
+	if !aggregated || candleAggregator == nil {
+		return nil, false
+	}
+
+	var bars []models.CandleBar
+	if err := database.Database.Db.
+		Where("symbol = ? AND bar_interval = ? AND start >= ?", symbol, interval, now.Add(-aggregatedCandleLimit*width)).
+		Order("start DESC").
+		Limit(aggregatedCandleLimit).
+		Find(&bars).Error; err != nil {
+		log.Printf("Failed to load candle bars for %s: %v", symbol, err)
+		return nil, false
+	}
+
+	candles := make([]Candle, 0, len(bars)+1)
+	for i := len(bars) - 1; i >= 0; i-- {
+		b := bars[i]
+		candles = append(candles, timedCandle(b.Start, false, b.Open, b.High, b.Low, b.Close, b.Volume))
+	}
+	latest := time.Time{}
+	if len(bars) > 0 {
+		latest = bars[0].Start
+	}
+	if current, ok := candleAggregator.Current(symbol, interval); ok {
+		start := time.UnixMilli(current.Start)
+		if start.After(latest) {
+			candles = append(candles, timedCandle(start, false, current.Open, current.High, current.Low, current.Close, current.Volume))
+		}
+	}
+	return liveTail(candles, width, now)
+}
+
+// liveTail trims candles to the latest back to back run, at most
+// aggregatedCandleLimit long. A stock's run starts at the open or at the
+// last minute without trades, what's before it comes from the provider. ok
+// is false when the run is stale.
+func liveTail(candles []Candle, width time.Duration, now time.Time) ([]Candle, bool) {
+	if len(candles) == 0 || time.Unix(candles[len(candles)-1].Timestamp, 0).Before(now.Add(-2*width)) {
+		return nil, false
+	}
+	start := len(candles) - 1
+	for start > 0 && candles[start].Timestamp-candles[start-1].Timestamp == int64(width/time.Second) {
+		start--
+	}
+	tail := candles[start:]
+	if len(tail) > aggregatedCandleLimit {
+		tail = tail[len(tail)-aggregatedCandleLimit:]
+	}
+	return tail, true
+}
+
+// liveCandles serves the latest n bars with the live run last and the
+// provider's stored history before it. ok is false when there is no live
+// run or the history can't be read.
+func liveCandles(ctx context.Context, provider Provider, symbol, interval string, n int, cfg *config.Config) ([]Candle, bool) {
+	tail, ok := aggregatedCandles(symbol, interval, time.Now().UTC())
+	if !ok {
+		return nil, false
+	}
+	if len(tail) >= n {
+		return tail[len(tail)-n:], true
+	}
+
+	// history stops short of the run so the two don't overlap
+	before := time.Unix(tail[0].Timestamp, 0).UTC().Add(-time.Second)
+	history, err := LatestStoredCandles(ctx, provider, symbol, interval, before, n-len(tail), cfg)
+	if err != nil {
+		log.Printf("Failed to load %s history before live candles for %s: %v", interval, symbol, err)
+		return nil, false
+	}
+	return mergeCandles(history, tail, n), true
+}
+
+// mergeCandles appends tail to history and keeps the latest n
+func mergeCandles(history, tail []Candle, n int) []Candle {
+	candles := make([]Candle, 0, len(history)+len(tail))
+	candles = append(candles, history...)
+	candles = append(candles, tail...)
+	if len(candles) > n {
+		candles = candles[len(candles)-n:]
+	}
+	return candles
+}
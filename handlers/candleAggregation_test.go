@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAggregatedCandlesInterval(t *testing.T) {
+	fmt.Println("Starting unit tests for candleAggregation.go")
+	fmt.Println("Testing aggregatedCandles function")
+
+	// daily candles are never aggregated, they always come from the provider
+	if _, ok := aggregatedCandles("AAPL", "1day", time.Now()); ok {
+		t.Fatalf("Expected 1day to fall back to the provider, got aggregated")
+	}
+}
+
+// minuteBars returns n one minute bars from start
+func minuteBars(start time.Time, n int) []Candle {
+	candles := make([]Candle, n)
+	for i := range candles {
+		candles[i] = timedCandle(start.Add(time.Duration(i)*time.Minute), false, 1, 1, 1, 1, 1)
+	}
+	return candles
+}
+
+func TestLiveTail(t *testing.T) {
+	fmt.Println("Testing liveTail function")
+
+	// a whole US session is 390 bars, the previous one ended overnight
+	open := time.Date(2024, 3, 1, 14, 30, 0, 0, time.UTC)
+	yesterday := minuteBars(open.AddDate(0, 0, -1).Add(4*time.Hour), 110)
+	session := minuteBars(open, 390)
+	now := open.Add(389*time.Minute + 30*time.Second)
+
+	tail, ok := liveTail(append(yesterday, session...), time.Minute, now)
+	if !ok {
+		t.Fatalf("Expected a stock session to take the live path, got a fallback")
+	}
+	if len(tail) != 390 || tail[0].Timestamp != open.Unix() {
+		t.Fatalf("Expected the 390 bars since the open, got %d from %s", len(tail), tail[0].Time)
+	}
+
+	// a minute without trades starts the run again
+	holed := append(append([]Candle{}, session[:200]...), session[201:]...)
+	if tail, _ := liveTail(holed, time.Minute, now); len(tail) != 189 {
+		t.Fatalf("Expected the 189 bars after the hole, got %d", len(tail))
+	}
+
+	if _, ok := liveTail(session, time.Minute, now.Add(time.Hour)); ok {
+		t.Fatalf("Expected bars an hour behind to fall back to the provider, got live")
+	}
+}
+
+func TestMergeCandles(t *testing.T) {
+	fmt.Println("Testing mergeCandles function")
+
+	open := time.Date(2024, 3, 1, 14, 30, 0, 0, time.UTC)
+	history := minuteBars(open.AddDate(0, 0, -1), 390)
+	session := minuteBars(open, 390)
+
+	candles := mergeCandles(history, session, defaultCandleBars)
+	if len(candles) != defaultCandleBars {
+		t.Fatalf("Expected %d bars, got %d", defaultCandleBars, len(candles))
+	}
+	if candles[109].Timestamp != history[389].Timestamp || candles[110].Timestamp != open.Unix() {
+		t.Fatalf("Expected 110 bars of history before the session, got %s then %s", candles[109].Time, candles[110].Time)
+	}
+}
@@ -0,0 +1,442 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"jfernsio/stonksbackend/config"
+	"jfernsio/stonksbackend/database"
+	"jfernsio/stonksbackend/models"
+	"log"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RangeProvider is a Provider that can fetch a time range, which lets the
+// store backfill only what it's missing. Providers without it are fetched
+// whole whenever the store is behind.
+type RangeProvider interface {
+	Provider
+	BuildRangeURL(symbol, interval string, from, to time.Time, cfg *config.Config) string
+}
+
+// CountProvider is a Provider that can fetch the n bars up to a time, which
+// lets the store fill a window counted in bars rather than calendar time
+type CountProvider interface {
+	Provider
+	// BuildCountURL asks for the latest n bars when to is zero
+	BuildCountURL(symbol, interval string, to time.Time, n int, cfg *config.Config) string
+}
+
+// candleProviders finds the provider of a stored series for the backfill job
+var candleProviders = map[string]Provider{
+	TwelveDataProvider{}.Name():   TwelveDataProvider{},
+	AlphaVantageProvider{}.Name(): AlphaVantageProvider{},
+}
+
+// candleWidths are the intervals the store accepts
+var candleWidths = map[string]time.Duration{
+	"1min":   time.Minute,
+	"5min":   5 * time.Minute,
+	"15min":  15 * time.Minute,
+	"30min":  30 * time.Minute,
+	"45min":  45 * time.Minute,
+	"1h":     time.Hour,
+	"2h":     2 * time.Hour,
+	"4h":     4 * time.Hour,
+	"1day":   24 * time.Hour,
+	"1week":  7 * 24 * time.Hour,
+	"1month": 30 * 24 * time.Hour,
+}
+
+const (
+	defaultCandleInterval = "1day"
+	// a request without from gets this many bars
+	defaultCandleBars = 500
+	// one range request may return at most this many bars
+	maxCandleRangeBars = 5000
+	// the latest bar is refetched at most this often while it may be in progress
+	maxCandleStaleness = time.Hour
+	// how long a provider is trusted to have nothing before the earliest stored bar
+	candleFloorTTL = 30 * 24 * time.Hour
+)
+
+// candleInterval defaults and validates an interval
+func candleInterval(interval string) (string, time.Duration, bool) {
+	if interval == "" {
+		interval = defaultCandleInterval
+	}
+	width, ok := candleWidths[interval]
+	return interval, width, ok
+}
+
+// parseCandleTime reads the time of a candle cached before it had a
+// timestamp, dates or date times, as UTC
+func parseCandleTime(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid candle time %q", s)
+}
+
+// parseRangeBound reads ?from= and ?to=, a date or an RFC 3339 time
+func parseRangeBound(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC(), nil
+	}
+	return time.ParseInLocation("2006-01-02", s, time.UTC)
+}
+
+// candleRange is a span of bars to fetch
+type candleRange struct {
+	From, To time.Time
+}
+
+// candleCoverage is what the store holds of one series. First can be earlier
+// than the first bar when the provider had nothing before it.
+type candleCoverage struct {
+	First, Last, LastFetched time.Time
+}
+
+func candleFloorKey(provider, symbol, interval string) string {
+	return fmt.Sprintf("candle_floor:%s:%s:%s", provider, symbol, interval)
+}
+
+func storedCoverage(ctx context.Context, provider, symbol, interval string) (candleCoverage, error) {
+	var coverage candleCoverage
+	var span struct {
+		First *time.Time
+		Last  *time.Time
+	}
+	series := database.Database.Db.Model(&models.HistoricalCandle{}).
+		Where("provider = ? AND symbol = ? AND bar_interval = ?", provider, symbol, interval)
+	if err := series.Session(&gorm.Session{}).Select("MIN(time) AS first, MAX(time) AS last").Scan(&span).Error; err != nil {
+		return coverage, err
+	}
+	if span.Last == nil {
+		return coverage, nil
+	}
+	coverage.First, coverage.Last = span.First.UTC(), span.Last.UTC()
+
+	var latest models.HistoricalCandle
+	if err := series.Session(&gorm.Session{}).Where("time = ?", *span.Last).First(&latest).Error; err != nil {
+		return coverage, err
+	}
+	coverage.LastFetched = latest.FetchedAt
+
+	if floor, ok := candleFloor(ctx, provider, symbol, interval); ok && floor.Before(coverage.First) {
+		coverage.First = floor
+	}
+	return coverage, nil
+}
+
+// candleFloor is when the provider was last found to have nothing earlier
+func candleFloor(ctx context.Context, provider, symbol, interval string) (time.Time, bool) {
+	floor, err := config.Redis.Client.Get(ctx, candleFloorKey(provider, symbol, interval)).Int64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(floor, 0).UTC(), true
+}
+
+// missingRanges returns the parts of [from, to] the store lacks. The latest
+// stored bar is fetched again while it may have been in progress, at most
+// every maxCandleStaleness. Gaps inside the stored span aren't looked for,
+// markets close and leave gaps of their own.
+func missingRanges(from, to time.Time, have candleCoverage, width time.Duration, now time.Time) []candleRange {
+	if have.Last.IsZero() {
+		return []candleRange{{from, to}}
+	}
+
+	var missing []candleRange
+	if from.Before(have.First) {
+		missing = append(missing, candleRange{from, have.First})
+	}
+	closesAt := have.Last.Add(width)
+	final := !have.LastFetched.Before(closesAt)
+	newer := !to.Before(closesAt)
+	if to.After(have.Last) && (!final || newer) && now.Sub(have.LastFetched) >= min(width, maxCandleStaleness) {
+		missing = append(missing, candleRange{have.Last, to})
+	}
+	return missing
+}
+
+// saveHistoricalCandles upserts candles, the latest copy of a bar wins
+func saveHistoricalCandles(provider, symbol, interval string, candles []Candle, now time.Time) (int, error) {
+	byTime := make(map[time.Time]models.HistoricalCandle, len(candles))
+	for _, c := range candles {
+		t := time.Unix(c.Timestamp, 0).UTC()
+		if c.Timestamp == 0 {
+			var err error
+			if t, err = parseCandleTime(c.Time); err != nil {
+				log.Printf("Skipping %s candle for %s: %v", provider, symbol, err)
+				continue
+			}
+		}
+		byTime[t] = models.HistoricalCandle{
+			Provider:  provider,
+			Symbol:    symbol,
+			Interval:  interval,
+			Time:      t,
+			Open:      c.Open,
+			High:      c.High,
+			Low:       c.Low,
+			Close:     c.Close,
+			Volume:    c.Volume,
+			FetchedAt: now,
+		}
+	}
+	if len(byTime) == 0 {
+		return 0, nil
+	}
+	// postgres refuses to upsert the same row twice in one statement
+	rows := make([]models.HistoricalCandle, 0, len(byTime))
+	for _, row := range byTime {
+		rows = append(rows, row)
+	}
+
+	err := database.Database.Db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "provider"}, {Name: "symbol"}, {Name: "bar_interval"}, {Name: "time"}},
+		DoUpdates: clause.AssignmentColumns([]string{"open", "high", "low", "close", "volume", "fetched_at"}),
+	}).CreateInBatches(&rows, 500).Error
+	return len(rows), err
+}
+
+// BackfillCandles fetches the parts of [from, to] the store is missing and
+// returns how many bars were saved
+func BackfillCandles(ctx context.Context, provider Provider, symbol, interval string, from, to time.Time, cfg *config.Config) (int, error) {
+	interval, width, ok := candleInterval(interval)
+	if !ok {
+		return 0, fmt.Errorf("unsupported interval %q", interval)
+	}
+	coverage, err := storedCoverage(ctx, provider.Name(), symbol, interval)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UTC()
+	ranged, isRanged := provider.(RangeProvider)
+	saved := 0
+	for _, r := range missingRanges(from, to, coverage, width, now) {
+		stored := !coverage.Last.IsZero()
+		leading := stored && r.To.Equal(coverage.First)
+		trailing := stored && r.From.Equal(coverage.Last)
+
+		url := provider.BuildURL(symbol, interval, cfg)
+		if isRanged {
+			url = ranged.BuildRangeURL(symbol, interval, r.From, r.To, cfg)
+		}
+		candles, err := fetchProviderCandles(provider, url)
+		// nothing before the first bar just means the series starts there
+		if err != nil && !(leading && errors.Is(err, ErrInvalidUpstream)) {
+			return saved, err
+		}
+		n, err := saveHistoricalCandles(provider.Name(), symbol, interval, candles, now)
+		saved += n
+		if err != nil {
+			return saved, err
+		}
+		// a full response may have been cut short, the floor is only known when
+		// the bars reach back to r.From or the provider had no more to give
+		complete := !isRanged || len(candles) < maxCandleRangeBars || candlesReach(candles, r.From)
+		if !trailing && complete {
+			config.Redis.Client.Set(ctx, candleFloorKey(provider.Name(), symbol, interval), r.From.Unix(), candleFloorTTL)
+		}
+		// the provider gave everything it has in one go
+		if !isRanged {
+			break
+		}
+	}
+	return saved, nil
+}
+
+// candlesReach reports whether the earliest of candles is at or before t
+func candlesReach(candles []Candle, t time.Time) bool {
+	for _, c := range candles {
+		start := time.Unix(c.Timestamp, 0)
+		if c.Timestamp == 0 {
+			var err error
+			if start, err = parseCandleTime(c.Time); err != nil {
+				continue
+			}
+		}
+		if !start.After(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// storedCandles reads [from, to] from the store, oldest first
+func storedCandles(provider, symbol, interval string, from, to time.Time) ([]Candle, error) {
+	var rows []models.HistoricalCandle
+	if err := database.Database.Db.
+		Where("provider = ? AND symbol = ? AND bar_interval = ? AND time BETWEEN ? AND ?", provider, symbol, interval, from, to).
+		Order("time").
+		Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	return historicalCandles(interval, rows), nil
+}
+
+// latestStoredCandles reads the n bars up to to from the store, oldest first
+func latestStoredCandles(provider, symbol, interval string, to time.Time, n int) ([]Candle, error) {
+	var rows []models.HistoricalCandle
+	if err := database.Database.Db.
+		Where("provider = ? AND symbol = ? AND bar_interval = ? AND time <= ?", provider, symbol, interval, to).
+		Order("time DESC").
+		Limit(n).
+		Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	slices.Reverse(rows)
+	return historicalCandles(interval, rows), nil
+}
+
+func historicalCandles(interval string, rows []models.HistoricalCandle) []Candle {
+	_, width, _ := candleInterval(interval)
+	daily := width >= 24*time.Hour
+	candles := make([]Candle, len(rows))
+	for i, r := range rows {
+		candles[i] = timedCandle(r.Time, daily, r.Open, r.High, r.Low, r.Close, r.Volume)
+	}
+	return candles
+}
+
+// StoredCandles backfills what's missing of [from, to] and serves it from the
+// store. A failed backfill still serves what's stored when there is any.
+func StoredCandles(ctx context.Context, provider Provider, symbol, interval string, from, to time.Time, cfg *config.Config) ([]Candle, error) {
+	interval, _, ok := candleInterval(interval)
+	if !ok {
+		return nil, fmt.Errorf("unsupported interval %q", interval)
+	}
+
+	_, backfillErr := BackfillCandles(ctx, provider, symbol, interval, from, to, cfg)
+	candles, err := storedCandles(provider.Name(), symbol, interval, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if backfillErr != nil {
+		if len(candles) == 0 {
+			return nil, backfillErr
+		}
+		log.Printf("Serving stored %s candles for %s after backfill failed: %v", interval, symbol, backfillErr)
+	}
+	return candles, nil
+}
+
+// LatestStoredCandles serves the n bars up to to, or the latest n when to is
+// zero. Markets close, so the window is counted in bars rather than time. The
+// store is topped up by bar count when it holds fewer than n bars the
+// provider may have, or when its latest bar may have moved on.
+func LatestStoredCandles(ctx context.Context, provider Provider, symbol, interval string, to time.Time, n int, cfg *config.Config) ([]Candle, error) {
+	interval, width, ok := candleInterval(interval)
+	if !ok {
+		return nil, fmt.Errorf("unsupported interval %q", interval)
+	}
+	now := time.Now().UTC()
+	end := to
+	if end.IsZero() {
+		end = now
+	}
+
+	candles, err := latestStoredCandles(provider.Name(), symbol, interval, end, n)
+	if err != nil {
+		return nil, err
+	}
+	coverage, err := storedCoverage(ctx, provider.Name(), symbol, interval)
+	if err != nil {
+		return nil, err
+	}
+
+	short := len(candles) < n
+	if floor, ok := candleFloor(ctx, provider.Name(), symbol, interval); ok && short {
+		first := end
+		if len(candles) > 0 {
+			first = time.Unix(candles[0].Timestamp, 0)
+		}
+		short = floor.After(first)
+	}
+	stale := !coverage.Last.IsZero() && !end.Before(coverage.Last) &&
+		len(missingRanges(end, end, coverage, width, now)) > 0
+	if !short && !stale {
+		return candles, nil
+	}
+
+	url := provider.BuildURL(symbol, interval, cfg)
+	if counted, ok := provider.(CountProvider); ok {
+		url = counted.BuildCountURL(symbol, interval, to, n, cfg)
+	}
+	fetched, fetchErr := fetchProviderCandles(provider, url)
+	if fetchErr == nil {
+		_, fetchErr = saveHistoricalCandles(provider.Name(), symbol, interval, fetched, now)
+	}
+	if fetchErr != nil {
+		if len(candles) == 0 {
+			return nil, fetchErr
+		}
+		log.Printf("Serving stored %s candles for %s after refresh failed: %v", interval, symbol, fetchErr)
+		return candles, nil
+	}
+
+	if candles, err = latestStoredCandles(provider.Name(), symbol, interval, end, n); err != nil {
+		return nil, err
+	}
+	// the provider gave all it has, don't ask again for what's before
+	if len(candles) < n {
+		floor := end
+		if len(candles) > 0 {
+			floor = time.Unix(candles[0].Timestamp, 0)
+		}
+		config.Redis.Client.Set(ctx, candleFloorKey(provider.Name(), symbol, interval), floor.Unix(), candleFloorTTL)
+	}
+	return candles, nil
+}
+
+// StartCandleBackfillJob keeps every stored series up to date, so charts
+// people have looked at before load without a provider call
+func StartCandleBackfillJob(cfg *config.Config, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		<-ticker.C
+		if !runsJobs() {
+			continue
+		}
+
+		var series []struct {
+			Provider    string
+			Symbol      string
+			BarInterval string
+		}
+		if err := database.Database.Db.Model(&models.HistoricalCandle{}).
+			Distinct("provider", "symbol", "bar_interval").
+			Scan(&series).Error; err != nil {
+			log.Printf("Failed to list candle series: %v", err)
+			continue
+		}
+
+		saved := 0
+		for _, s := range series {
+			provider, ok := candleProviders[s.Provider]
+			if !ok {
+				continue
+			}
+			now := time.Now().UTC()
+			n, err := BackfillCandles(context.Background(), provider, s.Symbol, s.BarInterval, now, now, cfg)
+			if err != nil {
+				log.Printf("Failed to backfill %s %s candles: %v", s.Symbol, s.BarInterval, err)
+			}
+			saved += n
+		}
+		if saved > 0 {
+			log.Printf("Backfilled %d candles across %d series", saved, len(series))
+		}
+	}
+}
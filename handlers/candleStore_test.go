@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"fmt"
+	"jfernsio/stonksbackend/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCandleTime(t *testing.T) {
+	fmt.Println("Starting unit tests for candleStore.go")
+	fmt.Println("Testing parseCandleTime function")
+
+	cases := map[string]time.Time{
+		"2024-03-01":           time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		"2024-03-01 14:35:00":  time.Date(2024, 3, 1, 14, 35, 0, 0, time.UTC),
+		"2024-03-01T14:35:00":  time.Date(2024, 3, 1, 14, 35, 0, 0, time.UTC),
+		"2024-03-01T14:35:00Z": time.Date(2024, 3, 1, 14, 35, 0, 0, time.UTC),
+	}
+	for in, want := range cases {
+		got, err := parseCandleTime(in)
+		if err != nil || !got.Equal(want) {
+			t.Fatalf("Expected %s for %q, got %s (%v)", want, in, got, err)
+		}
+	}
+	if _, err := parseCandleTime("yesterday"); err == nil {
+		t.Fatalf("Expected an error for yesterday, got none")
+	}
+}
+
+func TestMissingRanges(t *testing.T) {
+	fmt.Println("Testing missingRanges function")
+
+	day := 24 * time.Hour
+	jan := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+
+	// nothing stored fetches everything
+	if got := missingRanges(jan(1), jan(31), candleCoverage{}, day, jan(31)); len(got) != 1 || !got[0].From.Equal(jan(1)) {
+		t.Fatalf("Expected the whole range, got %v", got)
+	}
+
+	// the 20th was fetched after it closed, only earlier history is missing
+	have := candleCoverage{First: jan(10), Last: jan(20), LastFetched: jan(21).Add(time.Hour)}
+	got := missingRanges(jan(1), jan(20).Add(12*time.Hour), have, day, jan(21).Add(2*time.Hour))
+	if len(got) != 1 || !got[0].From.Equal(jan(1)) || !got[0].To.Equal(jan(10)) {
+		t.Fatalf("Expected only Jan 1-10, got %v", got)
+	}
+
+	// a newer bar may exist, the latest stored one is fetched again
+	got = missingRanges(jan(15), jan(25), have, day, jan(25))
+	if len(got) != 1 || !got[0].From.Equal(jan(20)) || !got[0].To.Equal(jan(25)) {
+		t.Fatalf("Expected Jan 20-25, got %v", got)
+	}
+
+	// but not again within maxCandleStaleness
+	have.LastFetched = jan(25).Add(-time.Minute)
+	if got := missingRanges(jan(15), jan(25), have, day, jan(25)); len(got) != 0 {
+		t.Fatalf("Expected nothing to fetch, got %v", got)
+	}
+}
+
+func TestTwelveDataBuildRangeURL(t *testing.T) {
+	fmt.Println("Testing TwelveDataProvider BuildRangeURL function")
+
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 2, 1, 9, 30, 0, 0, time.UTC)
+	url := TwelveDataProvider{}.BuildRangeURL("AAPL", "1h", from, to, &config.Config{TweleCandle: "key"})
+	// bounds are widened by a day for the exchange's offset
+	for _, part := range []string{"interval=1h", "start_date=2023-12-31+00%3A00%3A00", "end_date=2024-02-02+09%3A30%3A00"} {
+		if !strings.Contains(url, part) {
+			t.Fatalf("Expected %s in %s, got it missing", part, url)
+		}
+	}
+	var _ RangeProvider = TwelveDataProvider{}
+}
+
+func TestTwelveDataBuildCountURL(t *testing.T) {
+	fmt.Println("Testing TwelveDataProvider BuildCountURL function")
+
+	cfg := &config.Config{TweleCandle: "key"}
+	latest := TwelveDataProvider{}.BuildCountURL("AAPL", "1min", time.Time{}, 500, cfg)
+	if !strings.Contains(latest, "outputsize=500") || strings.Contains(latest, "end_date") {
+		t.Fatalf("Expected the latest 500 bars, got %s", latest)
+	}
+
+	to := time.Date(2024, 2, 1, 9, 30, 0, 0, time.UTC)
+	// a day of 1h bars more to make up for the widened end
+	url := TwelveDataProvider{}.BuildCountURL("AAPL", "1h", to, 500, cfg)
+	for _, part := range []string{"end_date=2024-02-02+09%3A30%3A00", "outputsize=524"} {
+		if !strings.Contains(url, part) {
+			t.Fatalf("Expected %s in %s, got it missing", part, url)
+		}
+	}
+	if url := (TwelveDataProvider{}).BuildCountURL("AAPL", "1min", to, 5000, cfg); !strings.Contains(url, "outputsize=5000") {
+		t.Fatalf("Expected outputsize capped at 5000, got %s", url)
+	}
+	var _ CountProvider = TwelveDataProvider{}
+}
+
+func TestCandlesReach(t *testing.T) {
+	fmt.Println("Testing candlesReach function")
+
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	truncated := []Candle{
+		timedCandle(from.Add(2*time.Hour), false, 1, 1, 1, 1, 1),
+		timedCandle(from.Add(time.Hour), false, 1, 1, 1, 1, 1),
+	}
+	if candlesReach(truncated, from) {
+		t.Fatalf("Expected bars from 01:00 not to reach midnight, got reached")
+	}
+	// bars cached before they had a timestamp are read from their time
+	reaching := append(truncated, Candle{Time: "2023-12-31"})
+	if !candlesReach(reaching, from) {
+		t.Fatalf("Expected a bar on 2023-12-31 to reach midnight, got not reached")
+	}
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"jfernsio/stonksbackend/config"
+	"log"
+	"time"
+)
+
+type CompanyProfile struct {
+	Symbol    string  `json:"ticker"`
+	Name      string  `json:"name"`
+	Industry  string  `json:"finnhubIndustry"`
+	Exchange  string  `json:"exchange"`
+	MarketCap float64 `json:"marketCapitalization"` // millions of USD
+	// Currency the stock is listed and quoted in
+	Currency string `json:"currency"`
+}
+
+// ErrNoProfile is finnhub not knowing a symbol
+var ErrNoProfile = errors.New("no company profile")
+
+// GetCompanyProfile returns the finnhub company profile for a stock symbol.
+// Profiles barely change so they're cached for a day.
+func GetCompanyProfile(ctx context.Context, symbol string, fihubApi string) (CompanyProfile, error) {
+	cacheKey := "company_profile:" + symbol
+
+	var profile CompanyProfile
+	if cached, err := config.Redis.Client.Get(ctx, cacheKey).Bytes(); err == nil {
+		if err := json.Unmarshal(cached, &profile); err == nil {
+			return profile, nil
+		}
+	}
+
+	url := fmt.Sprintf("https://finnhub.io/api/v1/stock/profile2?symbol=%s&token=%s", symbol, fihubApi)
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return profile, err
+	}
+	if resp.StatusCode() != 200 {
+		return profile, fmt.Errorf("finnhub profile status %d", resp.StatusCode())
+	}
+	if err := json.Unmarshal(resp.Body(), &profile); err != nil {
+		return profile, err
+	}
+	// finnhub answers unknown symbols with an empty object
+	if profile.Symbol == "" {
+		return profile, fmt.Errorf("%w for %s", ErrNoProfile, symbol)
+	}
+
+	if data, err := json.Marshal(profile); err == nil {
+		if err := config.Redis.Client.Set(ctx, cacheKey, data, 24*time.Hour).Err(); err != nil {
+			log.Printf("Failed to cache profile for %s: %v", symbol, err)
+		}
+	}
+	return profile, nil
+}
+
+// MarketCapBucket classifies a market cap given in millions of USD
+func MarketCapBucket(marketCap float64) string {
+	switch {
+	case marketCap <= 0:
+		return "Unknown"
+	case marketCap >= 200_000:
+		return "Mega Cap"
+	case marketCap >= 10_000:
+		return "Large Cap"
+	case marketCap >= 2_000:
+		return "Mid Cap"
+	case marketCap >= 300:
+		return "Small Cap"
+	}
+	return "Micro Cap"
+}
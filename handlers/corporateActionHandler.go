@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"fmt"
+	"jfernsio/stonksbackend/config"
+	"jfernsio/stonksbackend/database"
+	"jfernsio/stonksbackend/models"
+	"jfernsio/stonksbackend/utils"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm/clause"
+)
+
+type CorporateActionInput struct {
+	Symbol     string          `json:"symbol"`
+	Type       string          `json:"type"`
+	ExDate     string          `json:"ex_date"`
+	PayDate    string          `json:"pay_date"`
+	FromFactor decimal.Decimal `json:"from_factor"`
+	ToFactor   decimal.Decimal `json:"to_factor"`
+	Amount     decimal.Decimal `json:"amount"`
+}
+
+// toAction validates an uploaded action. Dates are YYYY-MM-DD in New York time.
+func (in CorporateActionInput) toAction() (models.CorporateAction, error) {
+	loc := utils.NewYork()
+	action := models.CorporateAction{
+		Symbol: strings.ToUpper(strings.TrimSpace(in.Symbol)),
+		Type:   models.CorporateActionType(strings.ToUpper(strings.TrimSpace(in.Type))),
+		Source: corporateActionManual,
+	}
+	if action.Symbol == "" {
+		return action, fmt.Errorf("symbol is required")
+	}
+
+	exDate, err := time.ParseInLocation("2006-01-02", in.ExDate, loc)
+	if err != nil {
+		return action, fmt.Errorf("invalid ex_date for %s", action.Symbol)
+	}
+	action.ExDate = exDate
+
+	switch action.Type {
+	case models.SplitAction:
+		if !in.FromFactor.IsPositive() || !in.ToFactor.IsPositive() {
+			return action, fmt.Errorf("split for %s needs positive from_factor and to_factor", action.Symbol)
+		}
+		action.FromFactor = in.FromFactor
+		action.ToFactor = in.ToFactor
+	case models.DividendAction:
+		if !in.Amount.IsPositive() {
+			return action, fmt.Errorf("dividend for %s needs a positive amount", action.Symbol)
+		}
+		action.Amount = in.Amount
+		if in.PayDate != "" {
+			payDate, err := time.ParseInLocation("2006-01-02", in.PayDate, loc)
+			if err != nil || payDate.Before(exDate) {
+				return action, fmt.Errorf("invalid pay_date for %s", action.Symbol)
+			}
+			action.PayDate = &payDate
+		}
+	default:
+		return action, fmt.Errorf("type must be SPLIT or DIVIDEND")
+	}
+	return action, nil
+}
+
+// UploadCorporateActions adds or corrects pending actions. Actions that were
+// already applied can't be changed.
+func UploadCorporateActions(c *fiber.Ctx) error {
+	type request struct {
+		Actions []CorporateActionInput `json:"actions"`
+	}
+
+	var body request
+	if err := c.BodyParser(&body); err != nil || len(body.Actions) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Expected a non empty actions list"})
+	}
+
+	actions := make([]models.CorporateAction, 0, len(body.Actions))
+	for _, in := range body.Actions {
+		action, err := in.toAction()
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		actions = append(actions, action)
+	}
+
+	err := database.Database.Db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "symbol"}, {Name: "type"}, {Name: "ex_date"}},
+		DoUpdates: clause.AssignmentColumns([]string{"pay_date", "from_factor", "to_factor", "amount", "source", "updated_at"}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Expr{SQL: "corporate_actions.processed_at IS NULL"},
+		}},
+	}).Create(&actions).Error
+	if err != nil {
+		log.Printf("Failed to save corporate actions: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to save corporate actions"})
+	}
+
+	return c.Status(201).JSON(fiber.Map{
+		"status": "success",
+		"data":   actions,
+	})
+}
+
+// ListCorporateActions lists actions for admins, ?status=pending|processed
+func ListCorporateActions(c *fiber.Ctx) error {
+	query := database.Database.Db.Model(&models.CorporateAction{})
+	switch strings.ToLower(c.Query("status")) {
+	case "pending":
+		query = query.Where("processed_at IS NULL")
+	case "processed":
+		query = query.Where("processed_at IS NOT NULL")
+	case "":
+	default:
+		return c.Status(400).JSON(fiber.Map{"error": "Status must be pending or processed"})
+	}
+	if symbol := strings.ToUpper(strings.TrimSpace(c.Query("symbol"))); symbol != "" {
+		query = query.Where("symbol = ?", symbol)
+	}
+
+	var actions []models.CorporateAction
+	if err := query.Order("ex_date DESC, id DESC").Limit(500).Find(&actions).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch corporate actions"})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   actions,
+	})
+}
+
+// ProcessCorporateActionsHandler applies due actions now instead of waiting for the job
+func ProcessCorporateActionsHandler(c *fiber.Ctx) error {
+	cfg := c.Locals("config").(*config.Config)
+
+	count, err := ProcessCorporateActions(cfg.FinHub)
+	if err != nil {
+		log.Printf("Corporate actions run failed: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to process corporate actions"})
+	}
+
+	return c.JSON(fiber.Map{
+		"status":    "success",
+		"processed": count,
+	})
+}
+
+// GetCorporateActions lists recent and upcoming actions for the caller's holdings
+func GetCorporateActions(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	db := database.Database.Db
+	var wallet models.Wallet
+	if err := db.Where("user_id = ?", userID).First(&wallet).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Wallet not found"})
+	}
+
+	var actions []models.CorporateAction
+	err := db.Where("symbol IN (?) AND ex_date >= ?",
+		db.Model(&models.Holding{}).Select("symbol").Where("wallet_id = ?", wallet.ID),
+		time.Now().AddDate(0, 0, -90),
+	).Order("ex_date DESC").Find(&actions).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch corporate actions"})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   actions,
+	})
+}
+
+func GetDripSetting(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var user models.UserModel
+	if err := database.Database.Db.Select("id", "drip_enabled").First(&user, userID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"enabled": user.DripEnabled,
+	})
+}
+
+// UpdateDripSetting turns dividend reinvestment on or off with {"enabled": bool}
+func UpdateDripSetting(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	type request struct {
+		Enabled *bool `json:"enabled"`
+	}
+
+	var body request
+	if err := c.BodyParser(&body); err != nil || body.Enabled == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	if err := database.Database.Db.Model(&models.UserModel{}).
+		Where("id = ?", userID).
+		Update("drip_enabled", *body.Enabled).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update DRIP setting"})
+	}
+
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"enabled": *body.Enabled,
+	})
+}
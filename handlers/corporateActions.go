@@ -0,0 +1,470 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"jfernsio/stonksbackend/database"
+	"jfernsio/stonksbackend/models"
+	"jfernsio/stonksbackend/utils"
+	"log"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	corporateActionManual  = "MANUAL"
+	corporateActionFinnhub = "FINNHUB"
+)
+
+// splitRatio is how many new shares each old share becomes
+func splitRatio(action models.CorporateAction) (decimal.Decimal, bool) {
+	if !action.FromFactor.IsPositive() || !action.ToFactor.IsPositive() {
+		return decimal.Zero, false
+	}
+	return action.ToFactor.Div(action.FromFactor), true
+}
+
+// splitFactor is how many shares one share held at t has become through the
+// splits since
+func splitFactor(splits []models.CorporateAction, t time.Time) decimal.Decimal {
+	factor := decimal.NewFromInt(1)
+	for _, split := range splits {
+		if ratio, ok := splitRatio(split); ok && t.Before(split.ExDate) {
+			factor = factor.Mul(ratio)
+		}
+	}
+	return factor
+}
+
+// unwindQuantity undoes later transactions on a position to get the quantity
+// held before them
+func unwindQuantity(current decimal.Decimal, later []models.Transaction) decimal.Decimal {
+	qty := current
+	for _, tx := range later {
+		qty = qty.Sub(positionDelta(tx))
+	}
+	if qty.IsNegative() {
+		return decimal.Zero
+	}
+	return qty
+}
+
+// quantityHeldAt is how much of symbol the wallet held right before at. A
+// reset since then wiped whatever was held, the replay doesn't reach past it.
+func quantityHeldAt(tx *gorm.DB, walletID uint, symbol string, current decimal.Decimal, at time.Time) (decimal.Decimal, error) {
+	var wallet models.Wallet
+	if err := tx.Select("id", "reset_at").First(&wallet, walletID).Error; err != nil {
+		return decimal.Zero, err
+	}
+	if wallet.ResetAt != nil && wallet.ResetAt.After(at) {
+		return decimal.Zero, nil
+	}
+
+	var later []models.Transaction
+	if err := tx.Where("wallet_id = ? AND symbol = ? AND created_at >= ?", walletID, symbol, at).
+		Find(&later).Error; err != nil {
+		return decimal.Zero, err
+	}
+	return unwindQuantity(current, later), nil
+}
+
+// splitLot scales a lot by the split ratio, keeping its total cost
+func splitLot(lot *models.TaxLot, ratio decimal.Decimal) {
+	lot.Quantity = lot.Quantity.Mul(ratio).Round(8)
+	lot.Remaining = lot.Remaining.Mul(ratio).Round(8)
+	lot.CostPerUnit = lot.CostPerUnit.Div(ratio).Round(8)
+}
+
+// applySplit adjusts every holder of the stock for the shares they held on the
+// ex date. Shares bought after the ex date were already at the split price.
+func applySplit(tx *gorm.DB, action models.CorporateAction) ([]uint, error) {
+	ratio, ok := splitRatio(action)
+	if !ok {
+		return nil, fmt.Errorf("split %d has no valid ratio", action.ID)
+	}
+
+	var holdings []models.Holding
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("symbol = ? AND type = ?", action.Symbol, models.STOCK).
+		Find(&holdings).Error; err != nil {
+		return nil, err
+	}
+
+	var walletIDs []uint
+	for _, holding := range holdings {
+		held, err := quantityHeldAt(tx, holding.WalletID, holding.Symbol, holding.Quantity, action.ExDate)
+		if err != nil {
+			return nil, err
+		}
+		if !held.IsPositive() {
+			continue
+		}
+		added := held.Mul(ratio).Sub(held).Round(8)
+
+		totalCost := holding.Quantity.Mul(holding.AvgBuyPrice)
+		holding.Quantity = holding.Quantity.Add(added)
+		if holding.Quantity.IsPositive() {
+			holding.AvgBuyPrice = totalCost.Div(holding.Quantity).Round(8)
+		}
+		if err := tx.Save(&holding).Error; err != nil {
+			return nil, err
+		}
+
+		var lots []models.TaxLot
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("wallet_id = ? AND symbol = ? AND acquired_at < ?", holding.WalletID, holding.Symbol, action.ExDate).
+			Find(&lots).Error; err != nil {
+			return nil, err
+		}
+		for i := range lots {
+			splitLot(&lots[i], ratio)
+			if err := tx.Save(&lots[i]).Error; err != nil {
+				return nil, err
+			}
+		}
+
+		record := models.Transaction{
+			WalletID:     holding.WalletID,
+			Symbol:       holding.Symbol,
+			Type:         models.Split,
+			Quantity:     added,
+			PricePerUnit: decimal.Zero,
+			TotalAmount:  decimal.Zero,
+		}
+		if err := tx.Create(&record).Error; err != nil {
+			return nil, err
+		}
+		walletIDs = append(walletIDs, holding.WalletID)
+	}
+	return walletIDs, nil
+}
+
+// dividendHolders returns the wallets that may have held the stock on the ex
+// date, those holding it now and those that traded it since
+func dividendHolders(tx *gorm.DB, action models.CorporateAction) ([]uint, error) {
+	var holders, traders []uint
+	if err := tx.Model(&models.Holding{}).
+		Where("symbol = ? AND type = ?", action.Symbol, models.STOCK).
+		Distinct().Pluck("wallet_id", &holders).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&models.Transaction{}).
+		Where("symbol = ? AND created_at >= ?", action.Symbol, action.ExDate).
+		Distinct().Pluck("wallet_id", &traders).Error; err != nil {
+		return nil, err
+	}
+
+	seen := make(map[uint]bool, len(holders)+len(traders))
+	var walletIDs []uint
+	for _, id := range append(holders, traders...) {
+		if !seen[id] {
+			seen[id] = true
+			walletIDs = append(walletIDs, id)
+		}
+	}
+	return walletIDs, nil
+}
+
+// applyDividend pays the dividend on the shares each wallet held on the ex
+// date and reinvests it for users with DRIP turned on. price is the current
+// share price used for reinvesting, zero skips reinvestment.
+func applyDividend(tx *gorm.DB, action models.CorporateAction, price decimal.Decimal) ([]uint, error) {
+	if !action.Amount.IsPositive() {
+		return nil, fmt.Errorf("dividend %d has no amount", action.ID)
+	}
+
+	walletIDs, err := dividendHolders(tx, action)
+	if err != nil {
+		return nil, err
+	}
+
+	var paid []uint
+	for _, walletID := range walletIDs {
+		var wallet models.Wallet
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&wallet, walletID).Error; err != nil {
+			return nil, err
+		}
+
+		var holding models.Holding
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("wallet_id = ? AND symbol = ?", walletID, action.Symbol).
+			First(&holding).Error
+		hasHolding := err == nil
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+
+		held, err := quantityHeldAt(tx, walletID, action.Symbol, holding.Quantity, action.ExDate)
+		if err != nil {
+			return nil, err
+		}
+		payout := held.Mul(action.Amount).Round(2)
+		if !payout.IsPositive() {
+			continue
+		}
+
+		wallet.Balance = wallet.Balance.Add(payout)
+		dividend := models.Transaction{
+			WalletID:     walletID,
+			Symbol:       action.Symbol,
+			Type:         models.Dividend,
+			Quantity:     held,
+			PricePerUnit: action.Amount,
+			TotalAmount:  payout,
+		}
+		if err := tx.Create(&dividend).Error; err != nil {
+			return nil, err
+		}
+		if err := PostJournal(tx, walletID, models.JournalDividend, &dividend.ID, tradeMemo(dividend),
+			debit(models.CashAccount, payout),
+			credit(models.IncomeAccount, payout),
+		); err != nil {
+			return nil, err
+		}
+
+		// reinvest into the position if it's still open
+		var user models.UserModel
+		if err := tx.Select("id", "drip_enabled").First(&user, wallet.UserID).Error; err != nil {
+			return nil, err
+		}
+		if user.DripEnabled && hasHolding && price.IsPositive() {
+			if err := reinvestDividend(tx, &wallet, &holding, payout, price); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := tx.Save(&wallet).Error; err != nil {
+			return nil, err
+		}
+		paid = append(paid, walletID)
+	}
+	return paid, nil
+}
+
+// reinvestDividend buys fractional shares with a dividend payout, opening a
+// tax lot like a regular buy
+func reinvestDividend(tx *gorm.DB, wallet *models.Wallet, holding *models.Holding, payout, price decimal.Decimal) error {
+	shares := payout.Div(price).Truncate(8)
+	if !shares.IsPositive() {
+		return nil
+	}
+	cost := shares.Mul(price)
+
+	wallet.Balance = wallet.Balance.Sub(cost)
+
+	totalCost := holding.Quantity.Mul(holding.AvgBuyPrice).Add(cost)
+	holding.Quantity = holding.Quantity.Add(shares)
+	holding.AvgBuyPrice = totalCost.Div(holding.Quantity)
+	if err := tx.Save(holding).Error; err != nil {
+		return err
+	}
+
+	record := models.Transaction{
+		WalletID:     wallet.ID,
+		Symbol:       holding.Symbol,
+		Type:         models.Drip,
+		Quantity:     shares,
+		PricePerUnit: price,
+		TotalAmount:  cost,
+	}
+	if err := tx.Create(&record).Error; err != nil {
+		return err
+	}
+	if err := PostJournal(tx, wallet.ID, models.JournalTrade, &record.ID, tradeMemo(record),
+		debit(models.HoldingsAccount, cost),
+		credit(models.CashAccount, cost),
+	); err != nil {
+		return err
+	}
+
+	lot := models.TaxLot{
+		WalletID:      wallet.ID,
+		Symbol:        holding.Symbol,
+		Type:          holding.Type,
+		TransactionID: &record.ID,
+		Quantity:      shares,
+		Remaining:     shares,
+		CostPerUnit:   price,
+		AcquiredAt:    record.CreatedAt,
+	}
+	return tx.Create(&lot).Error
+}
+
+// processCorporateAction applies one action exactly once and returns the
+// wallets it touched
+func processCorporateAction(action models.CorporateAction, fihubApi string) ([]uint, error) {
+	price := decimal.Zero
+	if action.Type == models.DividendAction {
+		if p, err := StockMarketPrice(action.Symbol, fihubApi); err == nil {
+			price = p
+		} else {
+			log.Printf("No price for %s, dividends won't be reinvested: %v", action.Symbol, err)
+		}
+	}
+
+	var walletIDs []uint
+	err := database.Database.Db.Transaction(func(tx *gorm.DB) error {
+		// lock the action so concurrent runs can't apply it twice
+		var locked models.CorporateAction
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND processed_at IS NULL", action.ID).
+			First(&locked).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		var err error
+		switch locked.Type {
+		case models.SplitAction:
+			walletIDs, err = applySplit(tx, locked)
+		case models.DividendAction:
+			walletIDs, err = applyDividend(tx, locked, price)
+		default:
+			err = fmt.Errorf("unknown corporate action type %s", locked.Type)
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		return tx.Model(&locked).Update("processed_at", now).Error
+	})
+	return walletIDs, err
+}
+
+// ProcessCorporateActions applies every action that has come due, oldest first
+func ProcessCorporateActions(fihubApi string) (int, error) {
+	db := database.Database.Db
+	now := time.Now()
+
+	var actions []models.CorporateAction
+	if err := db.Where("processed_at IS NULL").
+		Where("(type = ? AND ex_date <= ?) OR (type = ? AND COALESCE(pay_date, ex_date) <= ?)",
+			models.SplitAction, now, models.DividendAction, now).
+		Order("ex_date, id").
+		Find(&actions).Error; err != nil {
+		return 0, err
+	}
+
+	touched := make(map[uint]bool)
+	count := 0
+	for _, action := range actions {
+		walletIDs, err := processCorporateAction(action, fihubApi)
+		if err != nil {
+			log.Printf("Failed to process %s %s on %s: %v", action.Type, action.Symbol, action.ExDate.Format("2006-01-02"), err)
+			continue
+		}
+		for _, id := range walletIDs {
+			touched[id] = true
+		}
+		count++
+	}
+
+	for walletID := range touched {
+		var wallet models.Wallet
+		if err := db.First(&wallet, walletID).Error; err != nil {
+			continue
+		}
+		RefreshUserScore(wallet.UserID, wallet, fihubApi)
+	}
+	return count, nil
+}
+
+type finnhubSplit struct {
+	Symbol     string  `json:"symbol"`
+	Date       string  `json:"date"`
+	FromFactor float64 `json:"fromFactor"`
+	ToFactor   float64 `json:"toFactor"`
+}
+
+// SyncSplits pulls recent and upcoming splits from finnhub for every stock
+// someone holds. Existing actions are left alone so admin corrections stick.
+func SyncSplits(fihubApi string) (int, error) {
+	db := database.Database.Db
+	loc := utils.NewYork()
+	now := time.Now().In(loc)
+	from := now.AddDate(0, 0, -30).Format("2006-01-02")
+	to := now.AddDate(0, 0, 90).Format("2006-01-02")
+
+	var symbols []string
+	if err := db.Model(&models.Holding{}).
+		Where("type = ?", models.STOCK).
+		Distinct().Pluck("symbol", &symbols).Error; err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, symbol := range symbols {
+		url := fmt.Sprintf("https://finnhub.io/api/v1/stock/split?symbol=%s&from=%s&to=%s&token=%s", symbol, from, to, fihubApi)
+		resp, err := httpClient.Get(url)
+		if err != nil {
+			log.Printf("Failed to fetch splits for %s: %v", symbol, err)
+			continue
+		}
+		if resp.StatusCode() != 200 {
+			log.Printf("Finnhub splits for %s returned status %d", symbol, resp.StatusCode())
+			continue
+		}
+
+		var splits []finnhubSplit
+		if err := json.Unmarshal(resp.Body(), &splits); err != nil {
+			log.Printf("Failed to parse splits for %s: %v", symbol, err)
+			continue
+		}
+
+		for _, s := range splits {
+			exDate, err := time.ParseInLocation("2006-01-02", s.Date, loc)
+			if err != nil || s.FromFactor <= 0 || s.ToFactor <= 0 {
+				continue
+			}
+			action := models.CorporateAction{
+				Symbol:     symbol,
+				Type:       models.SplitAction,
+				ExDate:     exDate,
+				FromFactor: decimal.NewFromFloat(s.FromFactor),
+				ToFactor:   decimal.NewFromFloat(s.ToFactor),
+				Source:     corporateActionFinnhub,
+			}
+			result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&action)
+			if result.Error != nil {
+				log.Printf("Failed to save split for %s: %v", symbol, result.Error)
+				continue
+			}
+			count += int(result.RowsAffected)
+		}
+	}
+	return count, nil
+}
+
+// StartCorporateActionsJob syncs splits and applies due actions every interval.
+// Ex dates are midnight in New York so a few hours is enough to apply them
+// before the open.
+func StartCorporateActionsJob(fihubApi string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if runsJobs() {
+			if synced, err := SyncSplits(fihubApi); err != nil {
+				log.Printf("Failed to sync splits: %v", err)
+			} else if synced > 0 {
+				log.Printf("Synced %d new splits", synced)
+			}
+
+			count, err := ProcessCorporateActions(fihubApi)
+			if err != nil {
+				log.Printf("Corporate actions job failed: %v", err)
+			} else if count > 0 {
+				log.Printf("Processed %d corporate actions", count)
+			}
+		}
+
+		<-ticker.C
+	}
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"fmt"
+	"jfernsio/stonksbackend/models"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestSplitRatio(t *testing.T) {
+	fmt.Println("Starting unit tests for corporateActions.go")
+	fmt.Println("Testing splitRatio function")
+
+	forward := models.CorporateAction{FromFactor: decimal.NewFromInt(1), ToFactor: decimal.NewFromInt(4)}
+	if ratio, ok := splitRatio(forward); !ok || !ratio.Equal(decimal.NewFromInt(4)) {
+		t.Fatalf("Expected 4, got %s", ratio)
+	}
+
+	reverse := models.CorporateAction{FromFactor: decimal.NewFromInt(10), ToFactor: decimal.NewFromInt(1)}
+	if ratio, ok := splitRatio(reverse); !ok || !ratio.Equal(decimal.RequireFromString("0.1")) {
+		t.Fatalf("Expected 0.1, got %s", ratio)
+	}
+
+	if _, ok := splitRatio(models.CorporateAction{ToFactor: decimal.NewFromInt(2)}); ok {
+		t.Fatalf("Expected a missing from factor to be invalid")
+	}
+}
+
+func TestUnwindQuantity(t *testing.T) {
+	fmt.Println("Testing unwindQuantity function")
+
+	later := []models.Transaction{
+		{Type: models.Buy, Quantity: decimal.NewFromInt(5)},
+		{Type: models.Sell, Quantity: decimal.NewFromInt(2)},
+		{Type: models.Dividend, Quantity: decimal.NewFromInt(100)},
+		{Type: models.Split, Quantity: decimal.NewFromInt(9)},
+	}
+	// 15 now = held + 5 - 2 + 9
+	got := unwindQuantity(decimal.NewFromInt(15), later)
+	if !got.Equal(decimal.NewFromInt(3)) {
+		t.Fatalf("Expected 3, got %s", got)
+	}
+
+	// everything was bought after the date
+	got = unwindQuantity(decimal.NewFromInt(5), later[:1])
+	if !got.IsZero() {
+		t.Fatalf("Expected 0, got %s", got)
+	}
+}
+
+func TestSplitLot(t *testing.T) {
+	fmt.Println("Testing splitLot function")
+
+	lot := models.TaxLot{
+		Quantity:    decimal.NewFromInt(10),
+		Remaining:   decimal.NewFromInt(6),
+		CostPerUnit: decimal.NewFromInt(200),
+	}
+	costBefore := lot.Remaining.Mul(lot.CostPerUnit)
+
+	splitLot(&lot, decimal.NewFromInt(4))
+	if !lot.Quantity.Equal(decimal.NewFromInt(40)) || !lot.Remaining.Equal(decimal.NewFromInt(24)) {
+		t.Fatalf("Expected 40 and 24 shares, got %s and %s", lot.Quantity, lot.Remaining)
+	}
+	if !lot.CostPerUnit.Equal(decimal.NewFromInt(50)) {
+		t.Fatalf("Expected cost 50, got %s", lot.CostPerUnit)
+	}
+	if costAfter := lot.Remaining.Mul(lot.CostPerUnit); !costAfter.Equal(costBefore) {
+		t.Fatalf("Expected total cost %s, got %s", costBefore, costAfter)
+	}
+}
+
+func TestSplitFactor(t *testing.T) {
+	fmt.Println("Testing splitFactor function")
+
+	first := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	second := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	splits := []models.CorporateAction{
+		{Type: models.SplitAction, ExDate: first, FromFactor: decimal.NewFromInt(1), ToFactor: decimal.NewFromInt(2)},
+		{Type: models.SplitAction, ExDate: second, FromFactor: decimal.NewFromInt(1), ToFactor: decimal.NewFromInt(3)},
+	}
+
+	cases := []struct {
+		at   time.Time
+		want int64
+	}{
+		{first.AddDate(0, 0, -1), 6},
+		{first, 3},
+		{second.AddDate(0, 0, 1), 1},
+	}
+	for _, tc := range cases {
+		if got := splitFactor(splits, tc.at); !got.Equal(decimal.NewFromInt(tc.want)) {
+			t.Fatalf("Expected factor %d at %s, got %s", tc.want, tc.at, got)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/shopspring/decimal"
+)
+
+// DefaultQuoteAsset is the quote of a crypto symbol given without one. Those
+// trades settle against wallet cash.
+const DefaultQuoteAsset = "USDT"
+
+// stablecoins are held like any other asset but valued at a dollar
+var stablecoins = map[string]bool{"USDT": true, "USDC": true, "FDUSD": true, "DAI": true, "TUSD": true}
+
+const exchangeInfoTTL = 6 * time.Hour
+
+var ErrUnknownPair = errors.New("pair is not listed on binance")
+
+var binanceClient = binance.NewClient("", "")
+
+// CryptoPair is a spot market listed on Binance, e.g. ETHBTC is ETH priced in BTC
+type CryptoPair struct {
+	Symbol string `json:"symbol"`
+	Base   string `json:"base"`
+	Quote  string `json:"quote"`
+}
+
+// exchange info is a few MB so the listed pairs are kept in memory
+var pairCache struct {
+	sync.Mutex
+	pairs     map[string]CryptoPair // keyed base/quote
+	fetchedAt time.Time
+}
+
+// parsePairSymbol splits "ETH-BTC" or "ETH/BTC" into base and quote. A bare
+// symbol has an empty quote.
+func parsePairSymbol(raw string) (base, quote string) {
+	raw = strings.ToUpper(strings.TrimSpace(raw))
+	if b, q, ok := strings.Cut(raw, "-"); ok {
+		return b, q
+	}
+	if b, q, ok := strings.Cut(raw, "/"); ok {
+		return b, q
+	}
+	return raw, ""
+}
+
+// CryptoPairs returns every pair currently trading on Binance spot
+func CryptoPairs(ctx context.Context) (map[string]CryptoPair, error) {
+	pairCache.Lock()
+	defer pairCache.Unlock()
+
+	if pairCache.pairs != nil && time.Since(pairCache.fetchedAt) < exchangeInfoTTL {
+		return pairCache.pairs, nil
+	}
+
+	info, err := binanceClient.NewExchangeInfoService().Do(ctx)
+	if err != nil {
+		if pairCache.pairs != nil {
+			// stale listings beat none
+			return pairCache.pairs, nil
+		}
+		return nil, err
+	}
+	pairs := make(map[string]CryptoPair, len(info.Symbols))
+	for _, s := range info.Symbols {
+		if s.Status != "TRADING" || !s.IsSpotTradingAllowed {
+			continue
+		}
+		pairs[s.BaseAsset+"/"+s.QuoteAsset] = CryptoPair{Symbol: s.Symbol, Base: s.BaseAsset, Quote: s.QuoteAsset}
+	}
+	pairCache.pairs = pairs
+	pairCache.fetchedAt = time.Now()
+	return pairs, nil
+}
+
+// FindPair returns the pair trading from against to in either direction.
+// inverted is true when it's listed as to/from.
+func FindPair(ctx context.Context, from, to string) (pair CryptoPair, inverted bool, err error) {
+	pairs, err := CryptoPairs(ctx)
+	if err != nil {
+		return CryptoPair{}, false, err
+	}
+	if p, ok := pairs[from+"/"+to]; ok {
+		return p, false, nil
+	}
+	if p, ok := pairs[to+"/"+from]; ok {
+		return p, true, nil
+	}
+	return CryptoPair{}, false, fmt.Errorf("%w: %s/%s", ErrUnknownPair, from, to)
+}
+
+// PairPrice is the last price of a pair in its quote asset
+func PairPrice(ctx context.Context, symbol string) (decimal.Decimal, error) {
+	prices, err := binanceClient.NewListPricesService().Symbol(symbol).Do(ctx)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if len(prices) == 0 {
+		return decimal.Zero, fmt.Errorf("no price for %s", symbol)
+	}
+	return decimal.NewFromString(prices[0].Price)
+}
+
+// SwapRate is how many units of to one unit of from buys
+func SwapRate(ctx context.Context, from, to string) (decimal.Decimal, CryptoPair, error) {
+	pair, inverted, err := FindPair(ctx, from, to)
+	if err != nil {
+		return decimal.Zero, pair, err
+	}
+	price, err := PairPrice(ctx, pair.Symbol)
+	if err != nil {
+		return decimal.Zero, pair, err
+	}
+	if !price.IsPositive() {
+		return decimal.Zero, pair, fmt.Errorf("bad price %s for %s", price, pair.Symbol)
+	}
+	if inverted {
+		return decimal.NewFromInt(1).Div(price), pair, nil
+	}
+	return price, pair, nil
+}
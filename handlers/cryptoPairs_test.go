@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParsePairSymbol(t *testing.T) {
+	fmt.Println("Starting unit tests for cryptoPairs.go")
+	fmt.Println("Testing parsePairSymbol function")
+
+	cases := map[string][2]string{
+		"eth-btc": {"ETH", "BTC"},
+		"ETH/BTC": {"ETH", "BTC"},
+		"btc":     {"BTC", ""},
+	}
+	for raw, want := range cases {
+		base, quote := parsePairSymbol(raw)
+		if base != want[0] || quote != want[1] {
+			t.Fatalf("Expected %s to be %s/%s, got %s/%s", raw, want[0], want[1], base, quote)
+		}
+	}
+}
+
+func TestFindPair(t *testing.T) {
+	fmt.Println("Testing FindPair function")
+
+	pairCache.Lock()
+	pairCache.pairs = map[string]CryptoPair{
+		"ETH/BTC": {Symbol: "ETHBTC", Base: "ETH", Quote: "BTC"},
+	}
+	pairCache.fetchedAt = time.Now()
+	pairCache.Unlock()
+	defer func() {
+		pairCache.Lock()
+		pairCache.pairs = nil
+		pairCache.Unlock()
+	}()
+
+	pair, inverted, err := FindPair(context.Background(), "ETH", "BTC")
+	if err != nil || inverted || pair.Symbol != "ETHBTC" {
+		t.Fatalf("Expected ETHBTC not inverted, got %s inverted=%v (%v)", pair.Symbol, inverted, err)
+	}
+	pair, inverted, err = FindPair(context.Background(), "BTC", "ETH")
+	if err != nil || !inverted || pair.Symbol != "ETHBTC" {
+		t.Fatalf("Expected ETHBTC inverted, got %s inverted=%v (%v)", pair.Symbol, inverted, err)
+	}
+	if _, _, err := FindPair(context.Background(), "ETH", "DOGE"); !errors.Is(err, ErrUnknownPair) {
+		t.Fatalf("Expected ErrUnknownPair, got %v", err)
+	}
+}
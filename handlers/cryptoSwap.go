@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"errors"
+	"jfernsio/stonksbackend/config"
+	"jfernsio/stonksbackend/database"
+	"jfernsio/stonksbackend/models"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+type swapResult struct {
+	Wallet models.Wallet
+	Sold   sellResult
+	Bought buyResult
+}
+
+// executeSwap trades fromQty of one crypto holding for toQty of another. It's
+// booked as a sale of from at fromPrice dollars and a purchase of to with the
+// proceeds, so the swap realizes P&L on from and opens a lot of to while the
+// cash balance ends where it started.
+func executeSwap(userID uint, from, to string, fromQty, toQty, fromPrice decimal.Decimal) (swapResult, error) {
+	var res swapResult
+	err := database.Database.Db.Transaction(func(tx *gorm.DB) error {
+		if err := lockWallet(tx, userID, &res.Wallet); err != nil {
+			return err
+		}
+
+		balance := res.Wallet.Balance
+		res.Sold.Wallet = res.Wallet
+		if err := sellWithin(tx, &res.Sold, from, fromQty, fromPrice, liquidationMethod, nil); err != nil {
+			return err
+		}
+
+		// the purchase costs exactly the proceeds, the rounded unit price is
+		// only for the record
+		res.Bought.Wallet = res.Sold.Wallet
+		proceeds := res.Sold.Trade.TotalAmount
+		toPrice := proceeds.Div(toQty)
+		if err := buyWithin(tx, &res.Bought, to, models.CRYPTO, toQty, toPrice, proceeds); err != nil {
+			return err
+		}
+		res.Wallet = res.Bought.Wallet
+		if !res.Wallet.Balance.Equal(balance) {
+			return &tradeError{Status: 500, Message: "Swap left the cash balance changed"}
+		}
+		return nil
+	})
+	return res, err
+}
+
+// swapPrices works out how much to a swap of fromQty from gets and what from
+// is worth in dollars. Assets without a USDT market are priced through to.
+func swapPrices(c *fiber.Ctx, from, to string, fromQty decimal.Decimal) (toQty, fromPrice decimal.Decimal, pair CryptoPair, err error) {
+	rate, pair, err := SwapRate(c.Context(), from, to)
+	if err != nil {
+		return decimal.Zero, decimal.Zero, pair, err
+	}
+	toQty = fromQty.Mul(rate).RoundDown(8)
+
+	fromPrice, err = MarketPrice(from)
+	if err != nil {
+		toPrice, toErr := MarketPrice(to)
+		if toErr != nil {
+			return decimal.Zero, decimal.Zero, pair, err
+		}
+		fromPrice = toPrice.Mul(rate)
+	}
+	return toQty, fromPrice, pair, nil
+}
+
+// respondSwap swaps fromQty of from into to and writes the result
+func respondSwap(c *fiber.Ctx, from, to string, fromQty decimal.Decimal) error {
+	cfg := c.Locals("config").(*config.Config)
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	if from == to {
+		return c.Status(400).JSON(fiber.Map{"error": "Cannot swap an asset for itself"})
+	}
+
+	toQty, fromPrice, pair, err := swapPrices(c, from, to, fromQty)
+	if errors.Is(err, ErrUnknownPair) {
+		return c.Status(400).JSON(fiber.Map{"error": "No market trades " + from + " against " + to})
+	}
+	if err != nil {
+		log.Printf("Failed to price swap %s to %s: %v", from, to, err)
+		return c.Status(503).JSON(fiber.Map{"error": "Market unavailable"})
+	}
+	if !toQty.IsPositive() {
+		return c.Status(400).JSON(fiber.Map{"error": "Quantity is too small to swap"})
+	}
+
+	res, err := executeSwap(userID, from, to, fromQty, toQty, fromPrice)
+	if err != nil {
+		return respondTradeError(c, err)
+	}
+
+	go RefreshUserScore(userID, res.Wallet, cfg.FinHub)
+	go publishFill(userID, res.Wallet, res.Sold.Trade, res.Bought.Trade)
+
+	return c.JSON(fiber.Map{
+		"status":        "success",
+		"pair":          pair,
+		"from":          from,
+		"to":            to,
+		"from_quantity": fromQty.StringFixed(8),
+		"to_quantity":   toQty.StringFixed(8),
+		"pnl":           res.Sold.Trade.RealizedPnL.StringFixed(2),
+		"transactions":  []models.Transaction{res.Sold.Trade, res.Bought.Trade},
+		"lot_id":        res.Bought.Lot.ID,
+	})
+}
+
+// buyWithQuote buys qty of base paying with a holding of quote
+func buyWithQuote(c *fiber.Ctx, base, quote string, qty decimal.Decimal) error {
+	rate, _, err := SwapRate(c.Context(), quote, base)
+	if errors.Is(err, ErrUnknownPair) {
+		return c.Status(400).JSON(fiber.Map{"error": "No market trades " + base + " against " + quote})
+	}
+	if err != nil {
+		log.Printf("Failed to price %s/%s: %v", base, quote, err)
+		return c.Status(503).JSON(fiber.Map{"error": "Market unavailable"})
+	}
+	return respondSwap(c, quote, base, qty.Div(rate).RoundUp(8))
+}
+
+// SwapHandler swaps one crypto holding for another with
+// {"from": "BTC", "to": "ETH", "quantity": "0.5"}, quantity being in from
+func SwapHandler(c *fiber.Ctx) error {
+	type request struct {
+		From     string          `json:"from"`
+		To       string          `json:"to"`
+		Quantity decimal.Decimal `json:"quantity"`
+	}
+
+	var body request
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	from := strings.ToUpper(strings.TrimSpace(body.From))
+	to := strings.ToUpper(strings.TrimSpace(body.To))
+	if from == "" || to == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "from and to are required"})
+	}
+	if !body.Quantity.IsPositive() {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid quantity"})
+	}
+
+	return respondSwap(c, from, to, body.Quantity)
+}
+
+// GetCryptoPairs lists the tradable pairs, optionally only those with ?asset= on either side
+func GetCryptoPairs(c *fiber.Ctx) error {
+	pairs, err := CryptoPairs(c.Context())
+	if err != nil {
+		log.Printf("Failed to get binance exchange info: %v", err)
+		return c.Status(503).JSON(fiber.Map{"error": "Market unavailable"})
+	}
+
+	asset := strings.ToUpper(c.Query("asset"))
+	list := make([]CryptoPair, 0)
+	for _, p := range pairs {
+		if asset == "" || p.Base == asset || p.Quote == asset {
+			list = append(list, p)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Symbol < list[j].Symbol })
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"count":  len(list),
+		"pairs":  list,
+	})
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"jfernsio/stonksbackend/config"
+	"jfernsio/stonksbackend/models"
+	"log"
+	"strconv"
+	"time"
+)
+
+// UserEventType is what happened in a user event
+type UserEventType string
+
+const (
+	// a trade or currency conversion executed
+	EventFill UserEventType = "fill"
+	// cash or portfolio value changed
+	EventBalance UserEventType = "balance"
+	// leaderboard position moved, the user's own trade or someone passing them
+	EventRank UserEventType = "rank"
+	// a price alert fired. There are no alerts to trigger yet, the type is
+	// reserved so clients can handle it once there are.
+	EventAlert UserEventType = "alert"
+)
+
+// UserEvent is pushed to every open event stream of a user
+type UserEvent struct {
+	Type UserEventType `json:"type"`
+	Data any           `json:"data"`
+	Time time.Time     `json:"time"`
+}
+
+// userEventsChannel is the redis pub/sub channel for a user's events. Going
+// through redis lets any server instance deliver events from any other.
+func userEventsChannel(userID uint) string {
+	return fmt.Sprintf("user_events:%d", userID)
+}
+
+// PublishUserEvent sends an event to the user's streams. Events are best
+// effort, a failure is logged rather than failing the action behind it.
+func PublishUserEvent(userID uint, eventType UserEventType, data any) {
+	payload, err := json.Marshal(UserEvent{Type: eventType, Data: data, Time: time.Now()})
+	if err != nil {
+		log.Printf("Failed to encode %s event for user %d: %v", eventType, userID, err)
+		return
+	}
+	if err := config.Redis.Client.Publish(context.Background(), userEventsChannel(userID), payload).Err(); err != nil {
+		log.Printf("Failed to publish %s event for user %d: %v", eventType, userID, err)
+	}
+}
+
+// maxDisplacedRankEvents bounds the rank events one score change fans out to
+// other users, someone entering high up would otherwise notify most of the board
+const maxDisplacedRankEvents = 100
+
+// displacedRange is the 0 based positions of the users that a move from
+// previous to rank pushed along and how many places they moved, positive
+// being down. Without a previous rank everyone below the new one moved down.
+func displacedRange(previous, rank int64, hadPrevious bool) (start, stop, moved int64) {
+	switch {
+	case !hadPrevious:
+		return rank + 1, rank + maxDisplacedRankEvents, 1
+	case rank < previous:
+		return rank + 1, min(previous, rank+maxDisplacedRankEvents), 1
+	case rank > previous:
+		return max(previous, rank-maxDisplacedRankEvents), rank - 1, -1
+	}
+	return 0, -1, 0
+}
+
+// publishDisplacedRanks sends a rank event to the users a leaderboard move
+// from previous to rank pushed along
+func publishDisplacedRanks(ctx context.Context, previous, rank int64, hadPrevious bool) {
+	start, stop, moved := displacedRange(previous, rank, hadPrevious)
+	if moved == 0 {
+		return
+	}
+	members, err := config.Redis.Client.ZRevRange(ctx, "leaderboard:all_time", start, stop).Result()
+	if err != nil {
+		log.Printf("Failed to read displaced leaderboard ranks: %v", err)
+		return
+	}
+	for i, member := range members {
+		id, err := strconv.ParseUint(member, 10, 64)
+		if err != nil {
+			continue
+		}
+		position := start + int64(i)
+		PublishUserEvent(uint(id), EventRank, map[string]any{
+			"rank":          position + 1,
+			"previous_rank": position + 1 - moved,
+		})
+	}
+}
+
+// publishFill tells the user's streams about executed trades and the cash left
+func publishFill(userID uint, wallet models.Wallet, trades ...models.Transaction) {
+	PublishUserEvent(userID, EventFill, map[string]any{
+		"transactions": trades,
+		"balance":      wallet.Balance.StringFixed(2),
+	})
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"jfernsio/stonksbackend/config"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// eventsHeartbeat keeps proxies from closing an idle stream and notices
+// clients that went away
+const eventsHeartbeat = 25 * time.Second
+
+// StreamUserEvents is a server-sent events stream of the caller's fills,
+// balance and rank changes and, once there are alerts, triggered alerts
+func StreamUserEvents(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := config.Redis.Client.Subscribe(ctx, userEventsChannel(userID))
+	// wait for the subscription so nothing published right after connecting is missed
+	if _, err := sub.Receive(ctx); err != nil {
+		cancel()
+		sub.Close()
+		log.Printf("Failed to subscribe to events for user %d: %v", userID, err)
+		return c.Status(503).JSON(fiber.Map{"error": "Events unavailable"})
+	}
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+		defer sub.Close()
+
+		heartbeat := time.NewTicker(eventsHeartbeat)
+		defer heartbeat.Stop()
+
+		fmt.Fprint(w, "retry: 3000\n: connected\n\n")
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		messages := sub.Channel()
+		for {
+			select {
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				var event UserEvent
+				if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, msg.Payload)
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": ping\n\n")
+			}
+			// a failed flush means the client disconnected
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+	return nil
+}
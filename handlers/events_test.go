@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestUserEventsChannel(t *testing.T) {
+	fmt.Println("Starting unit tests for events.go")
+	fmt.Println("Testing userEventsChannel function")
+
+	if channel := userEventsChannel(42); channel != "user_events:42" {
+		t.Fatalf("Expected user_events:42, got %s", channel)
+	}
+}
+
+func TestUserEventJSON(t *testing.T) {
+	fmt.Println("Testing UserEvent encoding")
+
+	event := UserEvent{Type: EventRank, Data: map[string]int{"rank": 3}, Time: time.Unix(0, 0).UTC()}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := `{"type":"rank","data":{"rank":3},"time":"1970-01-01T00:00:00Z"}`
+	if string(payload) != expected {
+		t.Fatalf("Expected %s, got %s", expected, payload)
+	}
+}
+
+func TestDisplacedRange(t *testing.T) {
+	fmt.Println("Testing displacedRange function")
+
+	cases := []struct {
+		name               string
+		previous, rank     int64
+		hadPrevious        bool
+		start, stop, moved int64
+	}{
+		// up from 6th to 3rd pushes 4th to 6th down
+		{"up", 5, 2, true, 3, 5, 1},
+		// down from 3rd to 6th lifts 3rd to 5th
+		{"down", 2, 5, true, 2, 4, -1},
+		{"unchanged", 4, 4, true, 0, -1, 0},
+		{"new", 0, 1, false, 2, 1 + maxDisplacedRankEvents, 1},
+		{"far up", 500, 0, true, 1, maxDisplacedRankEvents, 1},
+	}
+	for _, tc := range cases {
+		start, stop, moved := displacedRange(tc.previous, tc.rank, tc.hadPrevious)
+		if start != tc.start || stop != tc.stop || moved != tc.moved {
+			t.Fatalf("Expected %s to displace %d..%d by %d, got %d..%d by %d", tc.name, tc.start, tc.stop, tc.moved, start, stop, moved)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"errors"
+	"jfernsio/stonksbackend/database"
+	"jfernsio/stonksbackend/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type FollowItem struct {
+	UserID   uint   `json:"user_id"`
+	Username string `json:"username"`
+}
+
+func FollowUser(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	followeeID, err := c.ParamsInt("userId")
+	if err != nil || followeeID <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user id"})
+	}
+	if uint(followeeID) == userID {
+		return c.Status(400).JSON(fiber.Map{"error": "You cannot follow yourself"})
+	}
+
+	var followee models.UserModel
+	if err := database.Database.Db.Select("id").First(&followee, followeeID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": "Database error"})
+	}
+
+	follow := models.Follow{
+		FollowerID: userID,
+		FolloweeID: followee.ID,
+	}
+	// following twice is a no-op
+	if err := database.Database.Db.Clauses(clause.OnConflict{DoNothing: true}).Create(&follow).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to follow user"})
+	}
+
+	return c.Status(201).JSON(fiber.Map{
+		"message": "Followed user",
+		"user_id": followee.ID,
+	})
+}
+
+func UnfollowUser(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	followeeID, err := c.ParamsInt("userId")
+	if err != nil || followeeID <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid user id"})
+	}
+
+	err = database.Database.Db.
+		Where("follower_id = ? AND followee_id = ?", userID, followeeID).
+		Delete(&models.Follow{}).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to unfollow user"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Unfollowed user", "user_id": followeeID})
+}
+
+func GetFollowing(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var following []FollowItem
+	err := database.Database.Db.
+		Model(&models.Follow{}).
+		Select("user_models.id as user_id, user_models.user_name as username").
+		Joins("JOIN user_models ON user_models.id = follows.followee_id").
+		Where("follows.follower_id = ?", userID).
+		Order("user_models.user_name").
+		Scan(&following).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch follows"})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   following,
+	})
+}
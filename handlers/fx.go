@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"jfernsio/stonksbackend/config"
+	"jfernsio/stonksbackend/models"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// BaseCurrency is what wallet balances, prices and trades are in
+const BaseCurrency = "USD"
+
+// SupportedCurrencies can be held as cash and used as a display currency
+var SupportedCurrencies = []string{"USD", "EUR", "INR"}
+
+const fxCacheTTL = time.Hour
+
+// FXRates maps a currency to how many units of it one BaseCurrency buys
+type FXRates map[string]decimal.Decimal
+
+// parseCurrency normalizes a currency code and checks it's supported
+func parseCurrency(code string) (string, bool) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	for _, c := range SupportedCurrencies {
+		if c == code {
+			return code, true
+		}
+	}
+	return code, false
+}
+
+// Convert converts amount between two currencies through the base currency
+func (r FXRates) Convert(amount decimal.Decimal, from, to string) (decimal.Decimal, error) {
+	if from == to {
+		return amount, nil
+	}
+	fromRate, ok := r[from]
+	if !ok || !fromRate.IsPositive() {
+		return decimal.Zero, fmt.Errorf("no rate for %s", from)
+	}
+	toRate, ok := r[to]
+	if !ok || !toRate.IsPositive() {
+		return decimal.Zero, fmt.Errorf("no rate for %s", to)
+	}
+	return amount.Div(fromRate).Mul(toRate), nil
+}
+
+// Rate is how many units of to one unit of from buys
+func (r FXRates) Rate(from, to string) (decimal.Decimal, error) {
+	return r.Convert(decimal.NewFromInt(1), from, to)
+}
+
+// listingRate is the currency symbol is listed in and how many BaseCurrency
+// one unit of it buys. Symbols finnhub has no profile for are taken to be
+// listed in BaseCurrency.
+func listingRate(ctx context.Context, symbol, fihubApi string) (string, decimal.Decimal, error) {
+	one := decimal.NewFromInt(1)
+	profile, err := GetCompanyProfile(ctx, symbol, fihubApi)
+	if errors.Is(err, ErrNoProfile) {
+		return BaseCurrency, one, nil
+	}
+	if err != nil {
+		return "", decimal.Zero, err
+	}
+	currency, ok := parseCurrency(profile.Currency)
+	if currency == "" || currency == BaseCurrency {
+		return BaseCurrency, one, nil
+	}
+	if !ok {
+		return "", decimal.Zero, fmt.Errorf("%s is listed in unsupported currency %s", symbol, currency)
+	}
+	rates, err := GetFXRates(ctx)
+	if err != nil {
+		return "", decimal.Zero, err
+	}
+	rate, err := rates.Rate(currency, BaseCurrency)
+	return currency, rate, err
+}
+
+type frankfurterResponse struct {
+	Base  string             `json:"base"`
+	Date  string             `json:"date"`
+	Rates map[string]float64 `json:"rates"`
+}
+
+// GetFXRates returns reference rates from the ECB via frankfurter.app, cached
+// for an hour since they only change once a day
+func GetFXRates(ctx context.Context) (FXRates, error) {
+	cacheKey := "fx_rates:" + BaseCurrency
+
+	rates := FXRates{}
+	if cached, err := config.Redis.Client.Get(ctx, cacheKey).Bytes(); err == nil {
+		if err := json.Unmarshal(cached, &rates); err == nil {
+			return rates, nil
+		}
+	}
+
+	symbols := make([]string, 0, len(SupportedCurrencies))
+	for _, c := range SupportedCurrencies {
+		if c != BaseCurrency {
+			symbols = append(symbols, c)
+		}
+	}
+	url := fmt.Sprintf("https://api.frankfurter.app/latest?from=%s&to=%s", BaseCurrency, strings.Join(symbols, ","))
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() != 200 {
+		return nil, fmt.Errorf("fx rates status %d", resp.StatusCode())
+	}
+
+	var body frankfurterResponse
+	if err := json.Unmarshal(resp.Body(), &body); err != nil {
+		return nil, err
+	}
+	rates[BaseCurrency] = decimal.NewFromInt(1)
+	for code, rate := range body.Rates {
+		rates[code] = decimal.NewFromFloat(rate)
+	}
+	for _, c := range SupportedCurrencies {
+		if _, ok := rates[c]; !ok {
+			return nil, fmt.Errorf("no fx rate for %s", c)
+		}
+	}
+
+	if data, err := json.Marshal(rates); err == nil {
+		if err := config.Redis.Client.Set(ctx, cacheKey, data, fxCacheTTL).Err(); err != nil {
+			log.Printf("Failed to cache fx rates: %v", err)
+		}
+	}
+	return rates, nil
+}
+
+// ForeignCashValue is the wallet's foreign cash in the base currency. Rates
+// are only fetched when there is foreign cash, and without them the cash is
+// valued at what it cost.
+func ForeignCashValue(ctx context.Context, db *gorm.DB, walletID uint) (decimal.Decimal, error) {
+	var balances []models.CashBalance
+	if err := db.Where("wallet_id = ? AND amount > 0", walletID).Find(&balances).Error; err != nil {
+		return decimal.Zero, err
+	}
+	if len(balances) == 0 {
+		return decimal.Zero, nil
+	}
+
+	rates, err := GetFXRates(ctx)
+	if err != nil {
+		log.Printf("Failed to get fx rates, valuing foreign cash at cost: %v", err)
+	}
+	total := decimal.Zero
+	for _, b := range balances {
+		value, err := rates.Convert(b.Amount, b.Currency, BaseCurrency)
+		if err != nil {
+			value = b.CostBasis
+		}
+		total = total.Add(value)
+	}
+	return total, nil
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"fmt"
+	"jfernsio/stonksbackend/config"
+	"jfernsio/stonksbackend/database"
+	"jfernsio/stonksbackend/models"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// lockCashBalance loads the wallet's balance in currency for update, creating
+// an empty one the first time that currency is bought
+func lockCashBalance(tx *gorm.DB, walletID uint, currency string) (models.CashBalance, error) {
+	balance := models.CashBalance{WalletID: walletID, Currency: currency}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&balance).Error; err != nil {
+		return balance, err
+	}
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("wallet_id = ? AND currency = ?", walletID, currency).
+		First(&balance).Error
+	return balance, err
+}
+
+// buyForeign spends usd of the wallet's base cash on currency. The foreign
+// cash is carried at what it cost so FX gains are realized on the way back.
+func buyForeign(tx *gorm.DB, wallet *models.Wallet, currency string, usd decimal.Decimal, rates FXRates) (models.Transaction, error) {
+	if wallet.Balance.LessThan(usd) {
+		return models.Transaction{}, &tradeError{Status: 422, Message: "Insufficient balance"}
+	}
+	amount, err := rates.Convert(usd, wallet.Currency, currency)
+	if err != nil {
+		return models.Transaction{}, &tradeError{Status: 503, Message: "FX rates unavailable"}
+	}
+	amount = amount.Round(2)
+	if !amount.IsPositive() {
+		return models.Transaction{}, &tradeError{Status: 400, Message: "Amount is too small to convert"}
+	}
+
+	balance, err := lockCashBalance(tx, wallet.ID, currency)
+	if err != nil {
+		return models.Transaction{}, err
+	}
+
+	record := models.Transaction{
+		WalletID:     wallet.ID,
+		Symbol:       currency,
+		Type:         models.FxBuy,
+		Quantity:     amount,
+		PricePerUnit: usd.Div(amount),
+		TotalAmount:  usd,
+		Currency:     wallet.Currency,
+	}
+	if err := tx.Create(&record).Error; err != nil {
+		return record, err
+	}
+	if err := PostJournal(tx, wallet.ID, models.JournalTrade, &record.ID, tradeMemo(record),
+		debit(models.FxCashAccount, usd),
+		credit(models.CashAccount, usd),
+	); err != nil {
+		return record, err
+	}
+
+	balance.Amount = balance.Amount.Add(amount)
+	balance.CostBasis = balance.CostBasis.Add(usd)
+	if err := tx.Save(&balance).Error; err != nil {
+		return record, err
+	}
+	wallet.Balance = wallet.Balance.Sub(usd)
+	return record, nil
+}
+
+// sellForeign converts amount of currency back to base cash, realizing the
+// difference between what it's worth now and its share of the cost. Without
+// rates it's converted at cost.
+func sellForeign(tx *gorm.DB, wallet *models.Wallet, currency string, amount decimal.Decimal, rates FXRates) (models.Transaction, error) {
+	balance, err := lockCashBalance(tx, wallet.ID, currency)
+	if err != nil {
+		return models.Transaction{}, err
+	}
+	if balance.Amount.LessThan(amount) {
+		return models.Transaction{}, &tradeError{Status: 422, Message: "Insufficient " + currency + " balance"}
+	}
+
+	cost := balance.CostBasis
+	if amount.LessThan(balance.Amount) {
+		cost = balance.CostBasis.Mul(amount).Div(balance.Amount).Round(8)
+	}
+	proceeds := cost
+	if converted, err := rates.Convert(amount, currency, wallet.Currency); err == nil {
+		proceeds = converted.Round(2)
+	}
+
+	record := models.Transaction{
+		WalletID:     wallet.ID,
+		Symbol:       currency,
+		Type:         models.FxSell,
+		Quantity:     amount,
+		PricePerUnit: proceeds.Div(amount),
+		TotalAmount:  proceeds,
+		RealizedPnL:  proceeds.Sub(cost),
+		Currency:     wallet.Currency,
+	}
+	if err := tx.Create(&record).Error; err != nil {
+		return record, err
+	}
+	if err := PostJournal(tx, wallet.ID, models.JournalTrade, &record.ID, tradeMemo(record),
+		debit(models.CashAccount, proceeds),
+		credit(models.FxCashAccount, cost),
+		gainLine(proceeds.Sub(cost)),
+	); err != nil {
+		return record, err
+	}
+
+	balance.Amount = balance.Amount.Sub(amount)
+	balance.CostBasis = balance.CostBasis.Sub(cost)
+	if err := tx.Save(&balance).Error; err != nil {
+		return record, err
+	}
+	wallet.Balance = wallet.Balance.Add(proceeds)
+	return record, nil
+}
+
+// convertCash moves amount of from into to. Foreign to foreign goes through
+// the base currency as a sell and a buy in one db transaction.
+func convertCash(userID uint, from, to string, amount decimal.Decimal, rates FXRates) (models.Wallet, []models.Transaction, error) {
+	var wallet models.Wallet
+	var records []models.Transaction
+
+	err := database.Database.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ?", userID).
+			First(&wallet).Error; err != nil {
+			return &tradeError{Status: 404, Message: "Wallet not found"}
+		}
+
+		usd := amount
+		if from != wallet.Currency {
+			sale, err := sellForeign(tx, &wallet, from, amount, rates)
+			if err != nil {
+				return err
+			}
+			records = append(records, sale)
+			usd = sale.TotalAmount
+		}
+		if to != wallet.Currency {
+			purchase, err := buyForeign(tx, &wallet, to, usd, rates)
+			if err != nil {
+				return err
+			}
+			records = append(records, purchase)
+		}
+		return tx.Save(&wallet).Error
+	})
+	return wallet, records, err
+}
+
+// GetFXRatesHandler returns what one unit of the base currency buys in every
+// supported currency
+func GetFXRatesHandler(c *fiber.Ctx) error {
+	rates, err := GetFXRates(c.Context())
+	if err != nil {
+		log.Printf("Failed to get fx rates: %v", err)
+		return c.Status(503).JSON(fiber.Map{"error": "FX rates unavailable"})
+	}
+
+	return c.JSON(fiber.Map{
+		"status":     "success",
+		"base":       BaseCurrency,
+		"currencies": SupportedCurrencies,
+		"rates":      rates,
+	})
+}
+
+// ConvertCurrency exchanges cash between currencies with
+// {"from": "USD", "to": "EUR", "amount": "100"}, amount being in from
+func ConvertCurrency(c *fiber.Ctx) error {
+	cfg := c.Locals("config").(*config.Config)
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	type request struct {
+		From   string          `json:"from"`
+		To     string          `json:"to"`
+		Amount decimal.Decimal `json:"amount"`
+	}
+
+	var body request
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	from, ok := parseCurrency(body.From)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("Unsupported currency %q", body.From)})
+	}
+	to, ok := parseCurrency(body.To)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("Unsupported currency %q", body.To)})
+	}
+	if from == to {
+		return c.Status(400).JSON(fiber.Map{"error": "Currencies must differ"})
+	}
+	amount := body.Amount.Round(2)
+	if !amount.IsPositive() {
+		return c.Status(400).JSON(fiber.Map{"error": "Amount must be positive"})
+	}
+
+	// rates are fetched before any rows are locked
+	rates, err := GetFXRates(c.Context())
+	if err != nil {
+		log.Printf("Failed to get fx rates: %v", err)
+		return c.Status(503).JSON(fiber.Map{"error": "FX rates unavailable"})
+	}
+
+	wallet, records, err := convertCash(userID, from, to, amount, rates)
+	if err != nil {
+		return respondTradeError(c, err)
+	}
+	go RefreshUserScore(userID, wallet, cfg.FinHub)
+	go publishFill(userID, wallet, records...)
+
+	return c.JSON(fiber.Map{
+		"status":       "success",
+		"message":      "Conversion successful",
+		"transactions": records,
+		"balance":      wallet.Balance.StringFixed(2),
+	})
+}
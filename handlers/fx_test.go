@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"fmt"
+	"jfernsio/stonksbackend/models"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestParseCurrency(t *testing.T) {
+	fmt.Println("Starting unit tests for fx.go")
+	fmt.Println("Testing parseCurrency function")
+
+	if code, ok := parseCurrency(" eur "); !ok || code != "EUR" {
+		t.Fatalf("Expected EUR to be supported, got %s %v", code, ok)
+	}
+	if _, ok := parseCurrency("GBP"); ok {
+		t.Fatalf("Expected GBP to be unsupported, got supported")
+	}
+}
+
+func TestFXRatesConvert(t *testing.T) {
+	fmt.Println("Testing FXRates.Convert function")
+
+	rates := FXRates{
+		"USD": decimal.NewFromInt(1),
+		"EUR": decimal.RequireFromString("0.8"),
+		"INR": decimal.NewFromInt(80),
+	}
+
+	got, err := rates.Convert(decimal.NewFromInt(100), "USD", "EUR")
+	if err != nil || !got.Equal(decimal.NewFromInt(80)) {
+		t.Fatalf("Expected 100 USD to be 80 EUR, got %s (%v)", got, err)
+	}
+	got, err = rates.Convert(decimal.NewFromInt(80), "EUR", "INR")
+	if err != nil || !got.Equal(decimal.NewFromInt(8000)) {
+		t.Fatalf("Expected 80 EUR to be 8000 INR, got %s (%v)", got, err)
+	}
+	got, err = rates.Convert(decimal.NewFromInt(5), "GBP", "GBP")
+	if err != nil || !got.Equal(decimal.NewFromInt(5)) {
+		t.Fatalf("Expected converting to the same currency to be a no-op, got %s (%v)", got, err)
+	}
+	if _, err := rates.Convert(decimal.NewFromInt(1), "USD", "GBP"); err == nil {
+		t.Fatalf("Expected an error for a missing rate, got nil")
+	}
+	if _, err := FXRates(nil).Convert(decimal.NewFromInt(1), "EUR", "USD"); err == nil {
+		t.Fatalf("Expected an error without rates, got nil")
+	}
+}
+
+func TestFXRatesRate(t *testing.T) {
+	fmt.Println("Testing FXRates.Rate function")
+
+	rates := FXRates{"USD": decimal.NewFromInt(1), "EUR": decimal.RequireFromString("0.8")}
+	got, err := rates.Rate("EUR", "USD")
+	if err != nil || !got.Equal(decimal.RequireFromString("1.25")) {
+		t.Fatalf("Expected 1 EUR to buy 1.25 USD, got %s (%v)", got, err)
+	}
+}
+
+func TestListingQuote(t *testing.T) {
+	fmt.Println("Testing listingQuote Base and stampListing functions")
+
+	quote := listingQuote{Currency: "EUR", Price: decimal.NewFromInt(50), Rate: decimal.RequireFromString("1.1")}
+	if !quote.Base().Equal(decimal.NewFromInt(55)) {
+		t.Fatalf("Expected 50 EUR to be 55 USD, got %s", quote.Base())
+	}
+
+	var trade models.Transaction
+	stampListing(&trade, &quote)
+	if trade.ListingCurrency != "EUR" || trade.ListingPrice == nil || !trade.ListingPrice.Equal(decimal.NewFromInt(50)) {
+		t.Fatalf("Expected the EUR quote on the trade, got %s %v", trade.ListingCurrency, trade.ListingPrice)
+	}
+
+	var local models.Transaction
+	stampListing(&local, &listingQuote{Currency: BaseCurrency, Price: decimal.NewFromInt(50), Rate: decimal.NewFromInt(1)})
+	if local.ListingCurrency != "" || local.ListingPrice != nil {
+		t.Fatalf("Expected nothing stamped for a USD listing, got %s", local.ListingCurrency)
+	}
+}
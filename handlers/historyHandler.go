@@ -29,7 +29,7 @@ func GetHistory(c *fiber.Ctx) error {
 	var transactions []models.Transaction
 	err := database.Database.Db.
 		Joins("JOIN wallets ON wallets.id = transactions.wallet_id").
-		Where("wallets.user_id = ?", userID).
+		Where("wallets.user_id = ? AND transactions.archived_at IS NULL", userID).
 		Order("transactions.created_at DESC").
 		Limit(limit).
 		Offset(offset).
@@ -58,7 +58,9 @@ func GetHistory(c *fiber.Ctx) error {
 		COALESCE(SUM(realized_pn_l),0) as total_pnl,  
 		SUM(CASE WHEN realized_pn_l > 0 THEN 1 ELSE 0 END) as win_trades,
 		COALESCE(SUM(total_amount),0) as total_volume
-		`).Where("wallets.user_id = ?", userID).
+		`).Where("wallets.user_id = ? AND transactions.archived_at IS NULL", userID).
+		//only trades count towards stats, not dividends or splits
+		Where("transactions.type IN ?", []models.TransactionType{models.Buy, models.Sell}).
 		Scan(&stats).Error
 
 	if err != nil {
@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"fmt"
+	"jfernsio/stonksbackend/database"
+	"jfernsio/stonksbackend/models"
+	"jfernsio/stonksbackend/utils"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// InterestTier pays APY (in percent) on the part of the balance above From.
+// Tiers are marginal like tax brackets.
+type InterestTier struct {
+	From decimal.Decimal `json:"from"`
+	APY  decimal.Decimal `json:"apy"`
+}
+
+var daysPerYear = decimal.NewFromInt(365)
+
+// ParseInterestTiers reads the cash yield config. tiers looks like
+// "0:1.5,10000:3,100000:4.25" and wins over a flat apy. Both empty means
+// interest is turned off.
+func ParseInterestTiers(apy, tiers string) ([]InterestTier, error) {
+	if strings.TrimSpace(tiers) == "" {
+		if strings.TrimSpace(apy) == "" {
+			return nil, nil
+		}
+		rate, err := decimal.NewFromString(strings.TrimSpace(apy))
+		if err != nil || rate.IsNegative() {
+			return nil, fmt.Errorf("invalid CASH_APY %q", apy)
+		}
+		return []InterestTier{{From: decimal.Zero, APY: rate}}, nil
+	}
+
+	var parsed []InterestTier
+	for _, part := range strings.Split(tiers, ",") {
+		from, rate, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid tier %q, expected balance:apy", part)
+		}
+		f, err := decimal.NewFromString(strings.TrimSpace(from))
+		if err != nil || f.IsNegative() {
+			return nil, fmt.Errorf("invalid tier balance %q", from)
+		}
+		r, err := decimal.NewFromString(strings.TrimSpace(rate))
+		if err != nil || r.IsNegative() {
+			return nil, fmt.Errorf("invalid tier apy %q", rate)
+		}
+		parsed = append(parsed, InterestTier{From: f, APY: r})
+	}
+
+	sort.Slice(parsed, func(i, j int) bool { return parsed[i].From.LessThan(parsed[j].From) })
+	for i := 1; i < len(parsed); i++ {
+		if parsed[i].From.Equal(parsed[i-1].From) {
+			return nil, fmt.Errorf("duplicate tier at %s", parsed[i].From)
+		}
+	}
+	return parsed, nil
+}
+
+// DailyInterest is one day of interest on balance, rounded to the wallet's precision
+func DailyInterest(balance decimal.Decimal, tiers []InterestTier) decimal.Decimal {
+	total := decimal.Zero
+	for i, tier := range tiers {
+		if !balance.GreaterThan(tier.From) {
+			break
+		}
+		top := balance
+		if i+1 < len(tiers) && tiers[i+1].From.LessThan(balance) {
+			top = tiers[i+1].From
+		}
+		portion := top.Sub(tier.From)
+		total = total.Add(portion.Mul(tier.APY).Div(decimal.NewFromInt(100)).Div(daysPerYear))
+	}
+	return total.Truncate(8)
+}
+
+// EffectiveAPY is the blended APY a balance earns across the tiers, in percent
+func EffectiveAPY(balance decimal.Decimal, tiers []InterestTier) decimal.Decimal {
+	if !balance.IsPositive() {
+		return decimal.Zero
+	}
+	yearly := DailyInterest(balance, tiers).Mul(daysPerYear)
+	return yearly.Div(balance).Mul(decimal.NewFromInt(100)).Round(4)
+}
+
+// accrueWalletInterest posts one day of interest to a wallet unless that day
+// was already paid
+func accrueWalletInterest(tx *gorm.DB, walletID uint, day string, tiers []InterestTier) (decimal.Decimal, error) {
+	var wallet models.Wallet
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&wallet, walletID).Error; err != nil {
+		return decimal.Zero, err
+	}
+
+	amount := DailyInterest(wallet.Balance, tiers)
+	if !amount.IsPositive() {
+		return decimal.Zero, nil
+	}
+
+	accrual := models.InterestAccrual{
+		WalletID: wallet.ID,
+		Date:     day,
+		Balance:  wallet.Balance,
+		Amount:   amount,
+	}
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&accrual)
+	if result.Error != nil {
+		return decimal.Zero, result.Error
+	}
+	if result.RowsAffected == 0 {
+		// already paid for this day
+		return decimal.Zero, nil
+	}
+
+	// dollars like a deposit, the balance it was paid on is on the accrual
+	record := models.Transaction{
+		WalletID:     wallet.ID,
+		Symbol:       cashSymbol,
+		Type:         models.Interest,
+		Quantity:     amount,
+		PricePerUnit: decimal.NewFromInt(1),
+		TotalAmount:  amount,
+	}
+	if err := tx.Create(&record).Error; err != nil {
+		return decimal.Zero, err
+	}
+	if err := tx.Model(&accrual).Update("transaction_id", record.ID).Error; err != nil {
+		return decimal.Zero, err
+	}
+	if err := PostJournal(tx, wallet.ID, models.JournalInterest, &record.ID, fmt.Sprintf("interest for %s at %s%% APY", day, EffectiveAPY(wallet.Balance, tiers).StringFixed(4)),
+		debit(models.CashAccount, amount),
+		credit(models.IncomeAccount, amount),
+	); err != nil {
+		return decimal.Zero, err
+	}
+
+	wallet.Balance = wallet.Balance.Add(amount)
+	if err := tx.Save(&wallet).Error; err != nil {
+		return decimal.Zero, err
+	}
+	return amount, nil
+}
+
+// AccrueInterest pays interest for the New York day starting at day to every
+// wallet that existed by its end
+func AccrueInterest(tiers []InterestTier, day time.Time) (int, decimal.Decimal, error) {
+	db := database.Database.Db
+	start := utils.StartOfDay(day)
+	end := start.AddDate(0, 0, 1)
+	key := start.Format("2006-01-02")
+
+	var walletIDs []uint
+	if err := db.Model(&models.Wallet{}).Where("created_at < ?", end).Pluck("id", &walletIDs).Error; err != nil {
+		return 0, decimal.Zero, err
+	}
+
+	count, total := 0, decimal.Zero
+	for _, walletID := range walletIDs {
+		var amount decimal.Decimal
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			amount, err = accrueWalletInterest(tx, walletID, key, tiers)
+			return err
+		})
+		if err != nil {
+			log.Printf("Failed to accrue interest for wallet %d: %v", walletID, err)
+			continue
+		}
+		if amount.IsPositive() {
+			count++
+			total = total.Add(amount)
+		}
+	}
+	return count, total, nil
+}
+
+// StartInterestJob pays each day's interest just after midnight New York
+// time. Yesterday is caught up on start in case the server was down.
+func StartInterestJob(tiers []InterestTier) {
+	if len(tiers) == 0 {
+		log.Println("Cash interest is disabled")
+		return
+	}
+
+	accrue := func(day time.Time) {
+		// the accrual is keyed by day, but one replica scanning every wallet is enough
+		if !runsJobs() {
+			return
+		}
+		count, total, err := AccrueInterest(tiers, day)
+		if err != nil {
+			log.Printf("Interest job failed: %v", err)
+			return
+		}
+		log.Printf("Paid %s interest to %d wallets for %s", total.StringFixed(2), count, day.Format("2006-01-02"))
+	}
+
+	today := utils.StartOfDay(time.Now())
+	accrue(today.AddDate(0, 0, -1))
+
+	for {
+		next := utils.StartOfDay(time.Now()).AddDate(0, 0, 1).Add(5 * time.Minute)
+		time.Sleep(time.Until(next))
+		accrue(utils.StartOfDay(next).AddDate(0, 0, -1))
+	}
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"jfernsio/stonksbackend/config"
+	"jfernsio/stonksbackend/database"
+	"jfernsio/stonksbackend/models"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/shopspring/decimal"
+)
+
+// GetInterestSummary shows the cash yield tiers, what the caller's balance
+// earns now and the interest paid so far
+func GetInterestSummary(c *fiber.Ctx) error {
+	cfg := c.Locals("config").(*config.Config)
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	tiers, err := ParseInterestTiers(cfg.CashAPY, cfg.CashAPYTiers)
+	if err != nil {
+		log.Printf("Invalid interest config: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Interest is misconfigured"})
+	}
+
+	var wallet models.Wallet
+	if err := database.Database.Db.Where("user_id = ?", userID).First(&wallet).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Wallet not found"})
+	}
+
+	var earned decimal.Decimal
+	if err := database.Database.Db.Model(&models.Transaction{}).
+		Select("COALESCE(SUM(total_amount), 0)").
+		Where("wallet_id = ? AND type = ?", wallet.ID, models.Interest).
+		Scan(&earned).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to sum interest"})
+	}
+
+	if tiers == nil {
+		tiers = []InterestTier{}
+	}
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data": fiber.Map{
+			"enabled":         len(tiers) > 0,
+			"tiers":           tiers,
+			"cash_balance":    wallet.Balance.StringFixed(2),
+			"effective_apy":   EffectiveAPY(wallet.Balance, tiers).StringFixed(4),
+			"daily_interest":  DailyInterest(wallet.Balance, tiers).StringFixed(4),
+			"interest_earned": earned.StringFixed(2),
+		},
+	})
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestParseInterestTiers(t *testing.T) {
+	fmt.Println("Starting unit tests for interest.go")
+	fmt.Println("Testing ParseInterestTiers function")
+
+	tiers, err := ParseInterestTiers("", "")
+	if err != nil || tiers != nil {
+		t.Fatalf("Expected interest to be disabled, got %v %v", tiers, err)
+	}
+
+	tiers, err = ParseInterestTiers("4.5", "")
+	if err != nil || len(tiers) != 1 || !tiers[0].APY.Equal(decimal.RequireFromString("4.5")) {
+		t.Fatalf("Expected a single 4.5%% tier, got %v %v", tiers, err)
+	}
+
+	// tiers win over the flat rate and are sorted
+	tiers, err = ParseInterestTiers("4.5", "10000:3, 0:1")
+	if err != nil || len(tiers) != 2 || !tiers[0].From.IsZero() || !tiers[1].APY.Equal(decimal.NewFromInt(3)) {
+		t.Fatalf("Expected sorted tiers, got %v %v", tiers, err)
+	}
+
+	for _, bad := range []string{"0:1,0:2", "1000", "x:1", "0:-1"} {
+		if _, err := ParseInterestTiers("", bad); err == nil {
+			t.Fatalf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestDailyInterest(t *testing.T) {
+	fmt.Println("Testing DailyInterest function")
+
+	flat := []InterestTier{{From: decimal.Zero, APY: decimal.NewFromFloat(3.65)}}
+	got := DailyInterest(decimal.NewFromInt(100000), flat)
+	if !got.Equal(decimal.NewFromInt(10)) {
+		t.Fatalf("Expected 10, got %s", got)
+	}
+
+	// 1% on the first 10k and 3.65% above it
+	tiers := []InterestTier{
+		{From: decimal.Zero, APY: decimal.NewFromFloat(1)},
+		{From: decimal.NewFromInt(10000), APY: decimal.NewFromFloat(3.65)},
+	}
+	got = DailyInterest(decimal.NewFromInt(20000), tiers)
+	want := decimal.NewFromInt(100).Div(daysPerYear).Add(decimal.NewFromInt(1)).Truncate(8)
+	if !got.Equal(want) {
+		t.Fatalf("Expected %s, got %s", want, got)
+	}
+
+	if got := DailyInterest(decimal.NewFromInt(5000), tiers[1:]); !got.IsZero() {
+		t.Fatalf("Expected nothing below the first tier, got %s", got)
+	}
+	if got := DailyInterest(decimal.Zero, flat); !got.IsZero() {
+		t.Fatalf("Expected nothing on an empty balance, got %s", got)
+	}
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"jfernsio/stonksbackend/config"
+	"jfernsio/stonksbackend/utils"
+)
+
+// held by the replica running the background jobs
+const jobsLeaderKey = "jobs:leader"
+
+// jobLeader picks the replica that runs the background jobs, nil until
+// StartJobLeader in which case every job runs
+var jobLeader *utils.Leader
+
+// StartJobLeader elects one replica to run the background jobs, so snapshots
+// aren't written once per replica and paid APIs aren't called by each of them.
+// Close the leader on shutdown to hand the jobs over straight away.
+func StartJobLeader(instance string) *utils.Leader {
+	jobLeader = utils.NewLeader(config.Redis.Client, jobsLeaderKey, instance)
+	jobLeader.Start()
+	return jobLeader
+}
+
+// runsJobs reports whether this replica should run the background jobs
+func runsJobs() bool {
+	return jobLeader == nil || jobLeader.Leading()
+}
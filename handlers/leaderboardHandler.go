@@ -2,66 +2,240 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"jfernsio/stonksbackend/config"
+	"jfernsio/stonksbackend/database"
+	"jfernsio/stonksbackend/models"
+	"log"
+	"sort"
 	"strconv"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/redis/go-redis/v9"
 )
 
+const (
+	defaultLeaderboardLimit = 10
+	maxLeaderboardLimit     = 100
+	maxAroundMeSpan         = 50
+)
+
 // LeaderboardEntry represents a single leaderboard entry
 type LeaderboardEntry struct {
-	UserID   uint    `json:"user_id"`
-	Username string  `json:"username"`
-	Balance  float64 `json:"balance"`
-	Rank     int     `json:"rank"`
+	UserID     uint    `json:"user_id"`
+	Username   string  `json:"username"`
+	Balance    float64 `json:"balance"`
+	Rank       int     `json:"rank"`
+	GlobalRank int     `json:"global_rank,omitempty"`
+}
+
+// parseLeaderboardMember converts a sorted set member back into a user id
+func parseLeaderboardMember(member interface{}) (uint, bool) {
+	switch m := member.(type) {
+	case uint:
+		return m, true
+	case string:
+		id, err := strconv.ParseUint(m, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		return uint(id), true
+	}
+	return 0, false
+}
+
+// buildLeaderboardEntries attaches usernames to a slice of sorted set results.
+// Only the usernames for the given page are fetched instead of the whole hash.
+func buildLeaderboardEntries(ctx context.Context, users []redis.Z, startRank int) ([]LeaderboardEntry, error) {
+	ids := make([]uint, 0, len(users))
+	fields := make([]string, 0, len(users))
+	scores := make([]float64, 0, len(users))
+	for _, user := range users {
+		userID, ok := parseLeaderboardMember(user.Member)
+		if !ok {
+			continue
+		}
+		ids = append(ids, userID)
+		fields = append(fields, fmt.Sprint(userID))
+		scores = append(scores, user.Score)
+	}
+
+	response := make([]LeaderboardEntry, 0, len(ids))
+	if len(ids) == 0 {
+		return response, nil
+	}
+
+	names, err := config.Redis.Client.HMGet(ctx, "leaderboard:usernames", fields...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, userID := range ids {
+		username, _ := names[i].(string)
+		if username == "" {
+			username = "Unknown"
+		}
+		response = append(response, LeaderboardEntry{
+			UserID:   userID,
+			Username: username,
+			Balance:  scores[i],
+			Rank:     startRank + i + 1,
+		})
+	}
+	return response, nil
 }
 
-// GetLeaderboard retrieves the top users from the leaderboard with usernames
+// GetLeaderboard retrieves a page of users from the leaderboard with usernames.
+// Pagination is rank based: ?cursor= (or ?offset=) is the 0-indexed rank to start
+// from and the response carries next_cursor while more entries remain.
 func GetLeaderboard(c *fiber.Ctx) error {
 	ctx := c.Context()
 
-	// Get top 10 users by score (highest balance first)
-	users, err := config.Redis.Client.ZRevRangeWithScores(ctx, "leaderboard:all_time", 0, 9).Result()
+	limit := c.QueryInt("limit", defaultLeaderboardLimit)
+	if limit < 1 || limit > maxLeaderboardLimit {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("limit must be between 1 and %d", maxLeaderboardLimit)})
+	}
+	offset := c.QueryInt("offset", 0)
+	if cursor := c.Query("cursor"); cursor != "" {
+		parsed, err := strconv.Atoi(cursor)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid cursor"})
+		}
+		offset = parsed
+	}
+	if offset < 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "offset must not be negative"})
+	}
+
+	total, err := config.Redis.Client.ZCard(ctx, "leaderboard:all_time").Result()
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch leaderboard"})
 	}
 
-	// Get all usernames from hash
-	usernameMap, err := config.Redis.Client.HGetAll(ctx, "leaderboard:usernames").Result()
+	// Highest balance first
+	users, err := config.Redis.Client.ZRevRangeWithScores(ctx, "leaderboard:all_time", int64(offset), int64(offset+limit-1)).Result()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch leaderboard"})
+	}
+
+	response, err := buildLeaderboardEntries(ctx, users, offset)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch usernames"})
 	}
 
-	// Build response with usernames
-	var response []LeaderboardEntry
-	for rank, user := range users {
-		userID, ok := user.Member.(uint)
-		if !ok {
-			// Try to parse as uint from interface
-			userIDStr, ok := user.Member.(string)
-			if !ok {
-				continue
-			}
-			id, err := strconv.ParseUint(userIDStr, 10, 64)
-			if err != nil {
-				continue
-			}
-			userID = uint(id)
-		}
+	var nextCursor interface{}
+	if int64(offset+limit) < total {
+		nextCursor = strconv.Itoa(offset + limit)
+	}
 
-		username := usernameMap[fmt.Sprint(userID)]
-		if username == "" {
-			username = "Unknown"
+	return c.JSON(fiber.Map{
+		"status":      "success",
+		"data":        response,
+		"total":       total,
+		"limit":       limit,
+		"offset":      offset,
+		"next_cursor": nextCursor,
+	})
+}
+
+// GetLeaderboardAroundMe returns the caller together with up to ?n= users
+// ranked directly above and below them
+func GetLeaderboardAroundMe(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	n := c.QueryInt("n", 5)
+	if n < 1 || n > maxAroundMeSpan {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("n must be between 1 and %d", maxAroundMeSpan)})
+	}
+
+	ctx := c.Context()
+
+	rank, err := config.Redis.Client.ZRevRank(ctx, "leaderboard:all_time", fmt.Sprint(userID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return c.Status(404).JSON(fiber.Map{"error": "You are not on the leaderboard yet"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to get user rank"})
+	}
+
+	start := rank - int64(n)
+	if start < 0 {
+		start = 0
+	}
+	users, err := config.Redis.Client.ZRevRangeWithScores(ctx, "leaderboard:all_time", start, rank+int64(n)).Result()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch leaderboard"})
+	}
+
+	response, err := buildLeaderboardEntries(ctx, users, int(start))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch usernames"})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   response,
+		"rank":   rank + 1,
+	})
+}
+
+// GetFriendsLeaderboard ranks the caller against the users they follow
+func GetFriendsLeaderboard(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var ids []uint
+	if err := database.Database.Db.Model(&models.Follow{}).
+		Where("follower_id = ?", userID).
+		Pluck("followee_id", &ids).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch follows"})
+	}
+	ids = append(ids, userID)
+
+	ctx := c.Context()
+
+	// fetch score and global rank of every friend in one round trip
+	pipe := config.Redis.Client.Pipeline()
+	scoreCmds := make([]*redis.FloatCmd, len(ids))
+	rankCmds := make([]*redis.IntCmd, len(ids))
+	for i, id := range ids {
+		scoreCmds[i] = pipe.ZScore(ctx, "leaderboard:all_time", fmt.Sprint(id))
+		rankCmds[i] = pipe.ZRevRank(ctx, "leaderboard:all_time", fmt.Sprint(id))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch leaderboard"})
+	}
+
+	users := make([]redis.Z, 0, len(ids))
+	globalRanks := make(map[uint]int64, len(ids))
+	for i, id := range ids {
+		score, err := scoreCmds[i].Result()
+		if err != nil {
+			// not on the leaderboard yet
+			continue
 		}
+		users = append(users, redis.Z{Score: score, Member: fmt.Sprint(id)})
+		if rank, err := rankCmds[i].Result(); err == nil {
+			globalRanks[id] = rank + 1
+		}
+	}
 
-		response = append(response, LeaderboardEntry{
-			UserID:   userID,
-			Username: username,
-			Balance:  user.Score,
-			Rank:     rank + 1,
-		})
+	sort.SliceStable(users, func(i, j int) bool {
+		return users[i].Score > users[j].Score
+	})
+
+	response, err := buildLeaderboardEntries(ctx, users, 0)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch usernames"})
+	}
+	for i := range response {
+		response[i].GlobalRank = int(globalRanks[response[i].UserID])
 	}
 
 	return c.JSON(fiber.Map{
@@ -163,3 +337,41 @@ func GetUserRank(c *fiber.Ctx) error {
 		},
 	})
 }
+
+// RefreshUserScore revalues a user's portfolio and pushes its LeaderboardScore.
+// Meant to be run in a goroutine after a trade commits. The user's event
+// streams get the new balance, and their rank if it moved along with those of
+// the users they passed or fell behind.
+func RefreshUserScore(userID uint, wallet models.Wallet, fihubApi string) {
+	total, score, err := LeaderboardScore(database.Database.Db, wallet, fihubApi, nil)
+	if err != nil {
+		log.Printf("Failed to value portfolio for user %d: %v", userID, err)
+		return
+	}
+
+	ctx := context.Background()
+	member := fmt.Sprint(userID)
+	previous, prevErr := config.Redis.Client.ZRevRank(ctx, "leaderboard:all_time", member).Result()
+
+	if err := UpdateUserBalance(userID, score.InexactFloat64()); err != nil {
+		log.Printf("Failed to update leaderboard for user %d: %v", userID, err)
+	}
+
+	PublishUserEvent(userID, EventBalance, fiber.Map{
+		"balance":     wallet.Balance.StringFixed(2),
+		"total_value": total.StringFixed(2),
+	})
+
+	rank, err := config.Redis.Client.ZRevRank(ctx, "leaderboard:all_time", member).Result()
+	if err != nil || (prevErr == nil && rank == previous) {
+		return
+	}
+	event := fiber.Map{"rank": rank + 1}
+	if prevErr == nil {
+		event["previous_rank"] = previous + 1
+	}
+	PublishUserEvent(userID, EventRank, event)
+	if prevErr == nil || errors.Is(prevErr, redis.Nil) {
+		publishDisplacedRanks(ctx, previous, rank, prevErr == nil)
+	}
+}
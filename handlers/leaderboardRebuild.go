@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"jfernsio/stonksbackend/config"
+	"jfernsio/stonksbackend/database"
+	"jfernsio/stonksbackend/models"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+)
+
+// default relative difference (in percent) tolerated between a redis score
+// and the score computed from postgres. scores are only pushed after trades
+// so some drift from price movement is expected.
+const defaultDriftTolerance = 1.0
+
+type leaderboardRow struct {
+	Username string
+	Score    float64
+}
+
+type DriftMismatch struct {
+	UserID        uint    `json:"user_id"`
+	Username      string  `json:"username"`
+	Issue         string  `json:"issue"`
+	ExpectedScore float64 `json:"expected_score"`
+	RedisScore    float64 `json:"redis_score"`
+}
+
+type DriftReport struct {
+	CheckedAt    time.Time       `json:"checked_at"`
+	UsersChecked int             `json:"users_checked"`
+	RedisEntries int             `json:"redis_entries"`
+	Tolerance    float64         `json:"tolerance_pct"`
+	Mismatches   []DriftMismatch `json:"mismatches"`
+}
+
+// computeLeaderboardScores values every user's portfolio from postgres
+func computeLeaderboardScores(fihubApi string) (map[uint]leaderboardRow, error) {
+	db := database.Database.Db
+
+	var users []models.UserModel
+	if err := db.Select("id", "user_name").Find(&users).Error; err != nil {
+		return nil, err
+	}
+
+	var wallets []models.Wallet
+	if err := db.Find(&wallets).Error; err != nil {
+		return nil, err
+	}
+	walletByUser := make(map[uint]models.Wallet, len(wallets))
+	for _, w := range wallets {
+		walletByUser[w.UserID] = w
+	}
+
+	// share quotes between users holding the same symbol
+	prices := make(map[string]decimal.Decimal)
+
+	rows := make(map[uint]leaderboardRow, len(users))
+	for _, u := range users {
+		wallet, ok := walletByUser[u.ID]
+		if !ok {
+			continue
+		}
+		_, score, err := LeaderboardScore(db, wallet, fihubApi, prices)
+		if err != nil {
+			return nil, err
+		}
+		rows[u.ID] = leaderboardRow{Username: u.UserName, Score: score.InexactFloat64()}
+	}
+	return rows, nil
+}
+
+// RebuildLeaderboard recreates leaderboard:all_time, leaderboard:usernames and
+// leaderboard:balances from postgres. The new keys are built under temporary
+// names and renamed into place so readers never see a half built board.
+func RebuildLeaderboard(ctx context.Context, fihubApi string) (int, error) {
+	rows, err := computeLeaderboardScores(fihubApi)
+	if err != nil {
+		return 0, err
+	}
+
+	suffix := fmt.Sprintf(":rebuild:%d", time.Now().UnixNano())
+	keys := []string{"leaderboard:all_time", "leaderboard:usernames", "leaderboard:balances"}
+
+	pipe := config.Redis.Client.TxPipeline()
+	for _, key := range keys {
+		pipe.Del(ctx, key+suffix)
+	}
+	for userID, row := range rows {
+		member := fmt.Sprint(userID)
+		pipe.ZAdd(ctx, keys[0]+suffix, redis.Z{Score: row.Score, Member: member})
+		pipe.HSet(ctx, keys[1]+suffix, member, row.Username)
+		pipe.HSet(ctx, keys[2]+suffix, member, fmt.Sprintf("%.2f", row.Score))
+	}
+	if len(rows) > 0 {
+		for _, key := range keys {
+			pipe.Rename(ctx, key+suffix, key)
+		}
+	} else {
+		pipe.Del(ctx, keys...)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+
+	return len(rows), nil
+}
+
+// CheckLeaderboardDrift compares the redis leaderboard with postgres and
+// reports missing, stale and out of date entries
+func CheckLeaderboardDrift(ctx context.Context, fihubApi string, tolerance float64) (DriftReport, error) {
+	report := DriftReport{
+		CheckedAt:  time.Now(),
+		Tolerance:  tolerance,
+		Mismatches: []DriftMismatch{},
+	}
+
+	rows, err := computeLeaderboardScores(fihubApi)
+	if err != nil {
+		return report, err
+	}
+	report.UsersChecked = len(rows)
+
+	entries, err := config.Redis.Client.ZRangeWithScores(ctx, "leaderboard:all_time", 0, -1).Result()
+	if err != nil {
+		return report, err
+	}
+	report.RedisEntries = len(entries)
+
+	usernames, err := config.Redis.Client.HGetAll(ctx, "leaderboard:usernames").Result()
+	if err != nil {
+		return report, err
+	}
+
+	seen := make(map[uint]bool, len(entries))
+	for _, entry := range entries {
+		userID, ok := parseLeaderboardMember(entry.Member)
+		if !ok {
+			continue
+		}
+		seen[userID] = true
+
+		row, ok := rows[userID]
+		if !ok {
+			report.Mismatches = append(report.Mismatches, DriftMismatch{
+				UserID:     userID,
+				Username:   usernames[fmt.Sprint(userID)],
+				Issue:      "stale_in_redis",
+				RedisScore: entry.Score,
+			})
+			continue
+		}
+
+		if usernames[fmt.Sprint(userID)] != row.Username {
+			report.Mismatches = append(report.Mismatches, DriftMismatch{
+				UserID:        userID,
+				Username:      row.Username,
+				Issue:         "username_mismatch",
+				ExpectedScore: row.Score,
+				RedisScore:    entry.Score,
+			})
+		}
+
+		if scoreDrift(row.Score, entry.Score) > tolerance {
+			report.Mismatches = append(report.Mismatches, DriftMismatch{
+				UserID:        userID,
+				Username:      row.Username,
+				Issue:         "score_mismatch",
+				ExpectedScore: row.Score,
+				RedisScore:    entry.Score,
+			})
+		}
+	}
+
+	for userID, row := range rows {
+		if seen[userID] {
+			continue
+		}
+		report.Mismatches = append(report.Mismatches, DriftMismatch{
+			UserID:        userID,
+			Username:      row.Username,
+			Issue:         "missing_in_redis",
+			ExpectedScore: row.Score,
+		})
+	}
+
+	sort.Slice(report.Mismatches, func(i, j int) bool {
+		return report.Mismatches[i].UserID < report.Mismatches[j].UserID
+	})
+
+	return report, nil
+}
+
+// scoreDrift returns the difference between two scores as a percentage of the expected one
+func scoreDrift(expected, actual float64) float64 {
+	if expected == 0 {
+		if actual == 0 {
+			return 0
+		}
+		return 100
+	}
+	return math.Abs(actual-expected) / math.Abs(expected) * 100
+}
+
+// StartLeaderboardDriftCheck periodically logs any drift between redis and postgres
+func StartLeaderboardDriftCheck(fihubApi string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !runsJobs() {
+			continue
+		}
+		report, err := CheckLeaderboardDrift(context.Background(), fihubApi, defaultDriftTolerance)
+		if err != nil {
+			log.Printf("Leaderboard drift check failed: %v", err)
+			continue
+		}
+		if len(report.Mismatches) == 0 {
+			log.Printf("Leaderboard drift check ok: %d users", report.UsersChecked)
+			continue
+		}
+		log.Printf("Leaderboard drift check found %d mismatches across %d users", len(report.Mismatches), report.UsersChecked)
+		for _, m := range report.Mismatches {
+			log.Printf("  user %d (%s): %s expected=%.2f redis=%.2f", m.UserID, m.Username, m.Issue, m.ExpectedScore, m.RedisScore)
+		}
+	}
+}
+
+func RebuildLeaderboardHandler(c *fiber.Ctx) error {
+	cfg := c.Locals("config").(*config.Config)
+
+	count, err := RebuildLeaderboard(c.Context(), cfg.FinHub)
+	if err != nil {
+		log.Printf("Leaderboard rebuild failed: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to rebuild leaderboard"})
+	}
+
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"message": "Leaderboard rebuilt",
+		"users":   count,
+	})
+}
+
+func LeaderboardDriftHandler(c *fiber.Ctx) error {
+	cfg := c.Locals("config").(*config.Config)
+
+	tolerance := defaultDriftTolerance
+	if t := c.Query("tolerance"); t != "" {
+		parsed, err := strconv.ParseFloat(t, 64)
+		if err != nil || parsed < 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid tolerance"})
+		}
+		tolerance = parsed
+	}
+
+	report, err := CheckLeaderboardDrift(c.Context(), cfg.FinHub, tolerance)
+	if err != nil {
+		log.Printf("Leaderboard drift check failed: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to check leaderboard"})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   report,
+	})
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestScoreDrift(t *testing.T) {
+	fmt.Println("Starting unit tests for leaderboardRebuild.go")
+	fmt.Println("Testing scoreDrift function")
+
+	cases := []struct {
+		expected, actual, want float64
+	}{
+		{100000, 100000, 0},
+		{100000, 99000, 1},
+		{100000, 110000, 10},
+		{0, 0, 0},
+		{0, 50, 100},
+	}
+
+	for _, tc := range cases {
+		got := scoreDrift(tc.expected, tc.actual)
+		if fmt.Sprintf("%.4f", got) != fmt.Sprintf("%.4f", tc.want) {
+			t.Fatalf("Expected drift of %v and %v to be %v, got %v", tc.expected, tc.actual, tc.want, got)
+		}
+	}
+}
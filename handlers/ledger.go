@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"jfernsio/stonksbackend/database"
+	"jfernsio/stonksbackend/models"
+	"log"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+var ErrUnbalancedEntry = errors.New("journal entry debits and credits don't balance")
+
+func debit(account models.LedgerAccount, amount decimal.Decimal) models.JournalLine {
+	return models.JournalLine{Account: account, Debit: amount, Credit: decimal.Zero}
+}
+
+func credit(account models.LedgerAccount, amount decimal.Decimal) models.JournalLine {
+	return models.JournalLine{Account: account, Debit: decimal.Zero, Credit: amount}
+}
+
+// gainLine books a realized gain as income, or a loss against it
+func gainLine(gain decimal.Decimal) models.JournalLine {
+	if gain.IsNegative() {
+		return debit(models.IncomeAccount, gain.Neg())
+	}
+	return credit(models.IncomeAccount, gain)
+}
+
+// validateJournal checks every line is one sided and debits equal credits
+func validateJournal(lines []models.JournalLine) error {
+	if len(lines) < 2 {
+		return fmt.Errorf("%w: need at least two lines", ErrUnbalancedEntry)
+	}
+	debits, credits := decimal.Zero, decimal.Zero
+	for _, line := range lines {
+		if line.Debit.IsNegative() || line.Credit.IsNegative() {
+			return fmt.Errorf("%w: negative amount on %s", ErrUnbalancedEntry, line.Account)
+		}
+		if line.Debit.IsPositive() == line.Credit.IsPositive() {
+			return fmt.Errorf("%w: %s line must be either a debit or a credit", ErrUnbalancedEntry, line.Account)
+		}
+		debits = debits.Add(line.Debit)
+		credits = credits.Add(line.Credit)
+	}
+	if !debits.Equal(credits) {
+		return fmt.Errorf("%w: debits %s, credits %s", ErrUnbalancedEntry, debits, credits)
+	}
+	return nil
+}
+
+// PostJournal appends a balanced entry to the wallet's ledger. It must run in
+// the same db transaction as the balance change it records. Zero lines are
+// dropped and an entry with nothing left is skipped.
+func PostJournal(tx *gorm.DB, walletID uint, kind models.JournalKind, transactionID *uint, memo string, lines ...models.JournalLine) error {
+	kept := make([]models.JournalLine, 0, len(lines))
+	for _, line := range lines {
+		if line.Debit.IsZero() && line.Credit.IsZero() {
+			continue
+		}
+		line.WalletID = walletID
+		kept = append(kept, line)
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	if err := validateJournal(kept); err != nil {
+		return err
+	}
+
+	entry := models.JournalEntry{
+		WalletID:      walletID,
+		Kind:          kind,
+		TransactionID: transactionID,
+		Memo:          memo,
+		Lines:         kept,
+	}
+	return tx.Create(&entry).Error
+}
+
+// tradeMemo describes a transaction for its journal entry
+func tradeMemo(t models.Transaction) string {
+	return fmt.Sprintf("%s %s %s", t.Type, t.Quantity.String(), t.Symbol)
+}
+
+// postOpeningBalance books a wallet's current cash and positions at cost
+// against equity
+func postOpeningBalance(tx *gorm.DB, wallet models.Wallet, kind models.JournalKind, memo string) error {
+	var holdings []models.Holding
+	if err := tx.Where("wallet_id = ?", wallet.ID).Find(&holdings).Error; err != nil {
+		return err
+	}
+	atCost := decimal.Zero
+	for _, h := range holdings {
+		atCost = atCost.Add(h.Quantity.Mul(h.AvgBuyPrice))
+	}
+
+	return PostJournal(tx, wallet.ID, kind, nil, memo,
+		debit(models.CashAccount, wallet.Balance),
+		debit(models.HoldingsAccount, atCost),
+		credit(models.EquityAccount, wallet.Balance.Add(atCost)),
+	)
+}
+
+// BackfillOpeningBalances gives wallets created before the ledger existed an
+// opening entry so reconciliation starts from their current balance
+func BackfillOpeningBalances() (int, error) {
+	db := database.Database.Db
+
+	var wallets []models.Wallet
+	if err := db.Where("id NOT IN (?)", db.Model(&models.JournalEntry{}).Select("wallet_id")).
+		Find(&wallets).Error; err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, wallet := range wallets {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			return postOpeningBalance(tx, wallet, models.JournalOpening, "opening balance")
+		})
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			// another replica posted it first
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to post opening balance for wallet %d: %v", wallet.ID, err)
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+type LedgerMismatch struct {
+	WalletID   uint            `json:"wallet_id"`
+	UserID     uint            `json:"user_id"`
+	Balance    decimal.Decimal `json:"balance"`
+	LedgerCash decimal.Decimal `json:"ledger_cash"`
+	Difference decimal.Decimal `json:"difference"`
+}
+
+// ledgerTolerance absorbs rounding from storing products of 8 decimal amounts
+var ledgerTolerance = decimal.RequireFromString("0.000001")
+
+// ReconcileLedger returns every wallet whose balance isn't the sum of its cash account
+func ReconcileLedger() ([]LedgerMismatch, error) {
+	var mismatches []LedgerMismatch
+	err := database.Database.Db.Raw(`
+		SELECT w.id AS wallet_id, w.user_id, w.balance,
+			COALESCE(SUM(l.debit - l.credit), 0) AS ledger_cash,
+			w.balance - COALESCE(SUM(l.debit - l.credit), 0) AS difference
+		FROM wallets w
+		LEFT JOIN journal_lines l ON l.wallet_id = w.id AND l.account = ?
+		GROUP BY w.id, w.user_id, w.balance
+		HAVING ABS(w.balance - COALESCE(SUM(l.debit - l.credit), 0)) > ?
+		ORDER BY w.id
+	`, models.CashAccount, ledgerTolerance).Scan(&mismatches).Error
+	return mismatches, err
+}
+
+// StartLedgerReconciliation checks the ledger against wallet balances every interval
+func StartLedgerReconciliation(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !runsJobs() {
+			continue
+		}
+		mismatches, err := ReconcileLedger()
+		if err != nil {
+			log.Printf("Ledger reconciliation failed: %v", err)
+			continue
+		}
+		for _, m := range mismatches {
+			log.Printf("Ledger mismatch for wallet %d: balance %s, ledger %s, off by %s",
+				m.WalletID, m.Balance, m.LedgerCash, m.Difference)
+		}
+		if len(mismatches) == 0 {
+			log.Println("Ledger reconciled with wallet balances")
+		}
+	}
+}
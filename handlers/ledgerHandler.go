@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"errors"
+	"jfernsio/stonksbackend/config"
+	"jfernsio/stonksbackend/database"
+	"jfernsio/stonksbackend/models"
+	"log"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ReconcileLedgerHandler lists wallets whose balance disagrees with the ledger
+func ReconcileLedgerHandler(c *fiber.Ctx) error {
+	mismatches, err := ReconcileLedger()
+	if err != nil {
+		log.Printf("Ledger reconciliation failed: %v", err)
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to reconcile ledger"})
+	}
+	if mismatches == nil {
+		mismatches = []LedgerMismatch{}
+	}
+
+	return c.JSON(fiber.Map{
+		"status":     "success",
+		"reconciled": len(mismatches) == 0,
+		"mismatches": mismatches,
+	})
+}
+
+// GetWalletLedger returns a wallet's journal, newest first, with account
+// balances for investigating discrepancies
+func GetWalletLedger(c *fiber.Ctx) error {
+	walletID, err := c.ParamsInt("walletId")
+	if err != nil || walletID <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid wallet id"})
+	}
+	limit := c.QueryInt("limit", 50)
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+	offset := c.QueryInt("offset", 0)
+	if offset < 0 {
+		offset = 0
+	}
+
+	db := database.Database.Db
+	var entries []models.JournalEntry
+	if err := db.Preload("Lines").
+		Where("wallet_id = ?", walletID).
+		Order("id DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&entries).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch ledger"})
+	}
+
+	type accountBalance struct {
+		Account models.LedgerAccount `json:"account"`
+		Balance decimal.Decimal      `json:"balance"` // debits minus credits
+	}
+	var balances []accountBalance
+	if err := db.Model(&models.JournalLine{}).
+		Select("account, SUM(debit - credit) AS balance").
+		Where("wallet_id = ?", walletID).
+		Group("account").
+		Order("account").
+		Scan(&balances).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to sum ledger"})
+	}
+
+	return c.JSON(fiber.Map{
+		"status":   "success",
+		"accounts": balances,
+		"entries":  entries,
+	})
+}
+
+// AdjustWalletBalance grants or removes cash with {"amount": "-12.50", "memo": "..."},
+// booked against equity so the change is explained in the ledger
+func AdjustWalletBalance(c *fiber.Ctx) error {
+	cfg := c.Locals("config").(*config.Config)
+	walletID, err := c.ParamsInt("walletId")
+	if err != nil || walletID <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid wallet id"})
+	}
+
+	type request struct {
+		Amount decimal.Decimal `json:"amount"`
+		Memo   string          `json:"memo"`
+	}
+
+	var body request
+	if err := c.BodyParser(&body); err != nil || body.Amount.IsZero() {
+		return c.Status(400).JSON(fiber.Map{"error": "A non zero amount is required"})
+	}
+	memo := strings.TrimSpace(body.Memo)
+	if memo == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "A memo explaining the adjustment is required"})
+	}
+
+	var wallet models.Wallet
+	err = database.Database.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&wallet, walletID).Error; err != nil {
+			return err
+		}
+
+		newBalance := wallet.Balance.Add(body.Amount)
+		if newBalance.IsNegative() {
+			return &tradeError{Status: 422, Message: "Adjustment would make the balance negative"}
+		}
+
+		lines := []models.JournalLine{
+			debit(models.CashAccount, body.Amount),
+			credit(models.EquityAccount, body.Amount),
+		}
+		if body.Amount.IsNegative() {
+			lines = []models.JournalLine{
+				debit(models.EquityAccount, body.Amount.Neg()),
+				credit(models.CashAccount, body.Amount.Neg()),
+			}
+		}
+		if err := PostJournal(tx, wallet.ID, models.JournalAdjustment, nil, memo, lines...); err != nil {
+			return err
+		}
+
+		wallet.Balance = newBalance
+		return tx.Save(&wallet).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(fiber.Map{"error": "Wallet not found"})
+	}
+	if err != nil {
+		return respondTradeError(c, err)
+	}
+
+	go RefreshUserScore(wallet.UserID, wallet, cfg.FinHub)
+
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"balance": wallet.Balance.StringFixed(2),
+	})
+}
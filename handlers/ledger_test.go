@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"jfernsio/stonksbackend/models"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestValidateJournal(t *testing.T) {
+	fmt.Println("Starting unit tests for ledger.go")
+	fmt.Println("Testing validateJournal function")
+
+	d := decimal.NewFromInt
+
+	// a sell at a gain
+	sell := []models.JournalLine{
+		debit(models.CashAccount, d(150)),
+		credit(models.HoldingsAccount, d(100)),
+		gainLine(d(50)),
+	}
+	if err := validateJournal(sell); err != nil {
+		t.Fatalf("Expected a balanced entry, got %v", err)
+	}
+
+	// and at a loss
+	loss := []models.JournalLine{
+		debit(models.CashAccount, d(80)),
+		credit(models.HoldingsAccount, d(100)),
+		gainLine(d(-20)),
+	}
+	if err := validateJournal(loss); err != nil {
+		t.Fatalf("Expected a balanced entry, got %v", err)
+	}
+
+	bad := map[string][]models.JournalLine{
+		"unbalanced": {debit(models.CashAccount, d(100)), credit(models.EquityAccount, d(99))},
+		"one line":   {debit(models.CashAccount, d(100))},
+		"negative":   {debit(models.CashAccount, d(-100)), credit(models.EquityAccount, d(-100))},
+		"two sided":  {{Account: models.CashAccount, Debit: d(1), Credit: d(1)}, credit(models.EquityAccount, d(0))},
+	}
+	for name, lines := range bad {
+		if err := validateJournal(lines); !errors.Is(err, ErrUnbalancedEntry) {
+			t.Fatalf("Expected %s entry to be rejected, got %v", name, err)
+		}
+	}
+}
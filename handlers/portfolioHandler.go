@@ -5,6 +5,7 @@ import (
 	"jfernsio/stonksbackend/database"
 	"jfernsio/stonksbackend/models"
 	"log"
+	"sort"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -21,6 +22,86 @@ type PortfolioResponse struct {
 	TotalReturn      string `json:"total_return"`
 	RealizedPnL      string `json:"realized_pnl"`
 	UnrealizedPnL    string `json:"unrealized_pnl"`
+	InterestEarned   string `json:"interest_earned"`
+	// Currency every amount is reported in
+	Currency string `json:"currency"`
+
+	CashBalances []CashBalanceDetail `json:"cash_balances"`
+
+	Positions []PositionDetail `json:"positions"`
+	Breakdown Breakdown        `json:"breakdown"`
+}
+
+type PositionDetail struct {
+	Symbol               string             `json:"symbol"`
+	Type                 models.HoldingType `json:"type"`
+	Quantity             string             `json:"quantity"`
+	AvgCost              string             `json:"avg_cost"`
+	CurrentPrice         string             `json:"current_price"`
+	Value                string             `json:"value"`
+	CostBasis            string             `json:"cost_basis"`
+	Weight               string             `json:"weight"` // percent of total balance
+	UnrealizedPnL        string             `json:"unrealized_pnl"`
+	UnrealizedPnLPercent string             `json:"unrealized_pnl_percent"`
+	Sector               string             `json:"sector"`
+	MarketCap            string             `json:"market_cap"`
+	// PriceStale is set when no live quote was available and the position is valued at cost
+	PriceStale bool `json:"price_stale"`
+}
+
+// CashBalanceDetail is cash held in one currency, Value is in the display currency
+type CashBalanceDetail struct {
+	Currency string `json:"currency"`
+	Amount   string `json:"amount"`
+	Value    string `json:"value"`
+}
+
+type AllocationSlice struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Weight string `json:"weight"`
+}
+
+// Breakdown groups the portfolio for pie charts. Asset class weights include
+// cash and are relative to the total balance, sector and market cap weights
+// are relative to the holdings value.
+type Breakdown struct {
+	AssetClass []AllocationSlice `json:"asset_class"`
+	Sector     []AllocationSlice `json:"sector"`
+	MarketCap  []AllocationSlice `json:"market_cap"`
+}
+
+// percentOf returns part as a percentage of whole, or zero for an empty whole
+func percentOf(part, whole decimal.Decimal) decimal.Decimal {
+	if whole.IsZero() {
+		return decimal.Zero
+	}
+	return part.Div(whole).Mul(decimal.NewFromInt(100))
+}
+
+// allocationSlices turns grouped values into slices sorted largest first,
+// with values converted to the display currency at rate
+func allocationSlices(groups map[string]decimal.Decimal, whole, rate decimal.Decimal) []AllocationSlice {
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if cmp := groups[names[i]].Cmp(groups[names[j]]); cmp != 0 {
+			return cmp > 0
+		}
+		return names[i] < names[j]
+	})
+
+	slices := make([]AllocationSlice, 0, len(names))
+	for _, name := range names {
+		slices = append(slices, AllocationSlice{
+			Name:   name,
+			Value:  groups[name].Mul(rate).StringFixed(2),
+			Weight: percentOf(groups[name], whole).StringFixed(2),
+		})
+	}
+	return slices
 }
 
 func PortfolioHandler(c *fiber.Ctx) error {
@@ -37,6 +118,32 @@ func PortfolioHandler(c *fiber.Ctx) error {
 		return c.Status(404).JSON(fiber.Map{"error": "Wallet not found"})
 	}
 
+	// Amounts are reported in ?currency=, the wallet's currency by default
+	currency, ok := parseCurrency(c.Query("currency", wallet.Currency))
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Unsupported currency"})
+	}
+
+	var cashBalances []models.CashBalance
+	if err := database.Database.Db.Where("wallet_id = ? AND amount > 0", wallet.ID).Order("currency").Find(&cashBalances).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch cash balances"})
+	}
+
+	var rates FXRates
+	if currency != wallet.Currency || len(cashBalances) > 0 {
+		var err error
+		if rates, err = GetFXRates(c.Context()); err != nil && currency != wallet.Currency {
+			return c.Status(503).JSON(fiber.Map{"error": "FX rates unavailable"})
+		}
+	}
+	displayRate := decimal.NewFromInt(1)
+	if currency != wallet.Currency {
+		displayRate, _ = rates.Rate(wallet.Currency, currency)
+	}
+	display := func(amount decimal.Decimal) string {
+		return amount.Mul(displayRate).StringFixed(2)
+	}
+
 	// Fetch holdings
 	var holdings []models.Holding
 	if err := database.Database.Db.Where("wallet_id = ?", wallet.ID).Find(&holdings).Error; err != nil {
@@ -46,7 +153,7 @@ func PortfolioHandler(c *fiber.Ctx) error {
 
 	// Fetch transactions
 	var transactions []models.Transaction
-	if err := database.Database.Db.Where("wallet_id = ?", wallet.ID).Find(&transactions).Error; err != nil {
+	if err := database.Database.Db.Where("wallet_id = ? AND archived_at IS NULL", wallet.ID).Find(&transactions).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch transactions"})
 	}
 	//save to redis
@@ -58,6 +165,7 @@ func PortfolioHandler(c *fiber.Ctx) error {
 	todayPnL := decimal.Zero
 	realizedPnL := decimal.Zero
 	unrealizedPnL := decimal.Zero
+	interestEarned := decimal.Zero
 
 	// Get today's date
 	today := time.Now().Truncate(24 * time.Hour)
@@ -67,6 +175,9 @@ func PortfolioHandler(c *fiber.Ctx) error {
 		if tx.Type == models.Buy {
 			totalInvested = totalInvested.Add(tx.TotalAmount)
 		}
+		if tx.Type == models.Interest {
+			interestEarned = interestEarned.Add(tx.TotalAmount)
+		}
 		realizedPnL = realizedPnL.Add(tx.RealizedPnL)
 
 		// Check if transaction is from today
@@ -75,39 +186,54 @@ func PortfolioHandler(c *fiber.Ctx) error {
 		}
 	}
 
-	// Calculate holdings value and unrealized P&L
-	for _, holding := range holdings {
-		//initalize current price var
-		var currentPrice decimal.Decimal
-		var err error
-		//first check holding type
-		//if not stock get crypto price
-		if holding.Type != models.STOCK {
-			currentPrice, err = MarketPrice(holding.Symbol)
-		} else {
-			// look for stocks
-			currentPrice, err = StockMarketPrice(holding.Symbol, fihubApi)
-
-		}
-		if err != nil {
-			log.Printf("Failed to get price for %s: %v", holding.Symbol, err)
-			// Skip this holding or use last known price? For now, skip
-			continue
-		}
-
-		currentValue := holding.Quantity.Mul(currentPrice)
-		holdingsValue = holdingsValue.Add(currentValue)
+	// Calculate holdings value and unrealized P&L. Holdings without a live
+	// quote are valued at cost and flagged as stale.
+	positions := ValuePositions(holdings, fihubApi, nil)
+	for _, p := range positions {
+		holdingsValue = holdingsValue.Add(p.Value)
 
 		// Unrealized P&L = (current_price - avg_buy_price) * quantity
-		unrealized := currentPrice.Sub(holding.AvgBuyPrice).Mul(holding.Quantity)
+		unrealized := p.Price.Sub(p.Holding.AvgBuyPrice).Mul(p.Holding.Quantity)
 		unrealizedPnL = unrealizedPnL.Add(unrealized)
 	}
 
+	// Foreign cash at live rates, or at cost without them. FX moves count as
+	// unrealized P&L until the cash is converted back.
+	cashDetails := []CashBalanceDetail{{
+		Currency: wallet.Currency,
+		Amount:   wallet.Balance.StringFixed(2),
+		Value:    display(wallet.Balance),
+	}}
+	for _, b := range cashBalances {
+		value, err := rates.Convert(b.Amount, b.Currency, wallet.Currency)
+		if err != nil {
+			value = b.CostBasis
+		}
+		cashBalance = cashBalance.Add(value)
+		unrealizedPnL = unrealizedPnL.Add(value.Sub(b.CostBasis))
+		cashDetails = append(cashDetails, CashBalanceDetail{
+			Currency: b.Currency,
+			Amount:   b.Amount.StringFixed(2),
+			Value:    display(value),
+		})
+	}
+
 	// Total balance = cash + holdings value
 	totalBalance := cashBalance.Add(holdingsValue)
 
-	// Total return = realized + unrealized
-	totalReturn := realizedPnL.Add(unrealizedPnL)
+	// Day P&L = current equity - equity at the prior close - cash moved in
+	// since. Until the first close snapshot exists fall back to today's
+	// realized P&L.
+	if priorClose, closedAt, ok := PriorCloseEquity(database.Database.Db, wallet, time.Now()); ok {
+		if flows, err := ExternalFlowsSince(database.Database.Db, wallet.ID, closedAt); err == nil {
+			todayPnL = totalBalance.Sub(priorClose).Sub(flows)
+		} else {
+			log.Printf("Failed to sum cash flows for wallet %d: %v", wallet.ID, err)
+		}
+	}
+
+	// Total return = realized + unrealized + interest on cash
+	totalReturn := realizedPnL.Add(unrealizedPnL).Add(interestEarned)
 
 	// Percentage change = (total_balance - total_invested) / total_invested * 100
 	percentageChange := decimal.Zero
@@ -116,16 +242,71 @@ func PortfolioHandler(c *fiber.Ctx) error {
 		percentageChange = change.Div(totalInvested).Mul(decimal.NewFromInt(100))
 	}
 
+	// Per position detail and allocation breakdowns, largest positions first
+	sort.SliceStable(positions, func(i, j int) bool {
+		return positions[i].Value.GreaterThan(positions[j].Value)
+	})
+	details := make([]PositionDetail, 0, len(positions))
+	byAssetClass := map[string]decimal.Decimal{"CASH": cashBalance}
+	bySector := make(map[string]decimal.Decimal)
+	byMarketCap := make(map[string]decimal.Decimal)
+	for _, p := range positions {
+		h := p.Holding
+		sector, marketCap := "Crypto", "Crypto"
+		if h.Type == models.STOCK {
+			sector, marketCap = "Unknown", "Unknown"
+			if profile, err := GetCompanyProfile(c.Context(), h.Symbol, fihubApi); err == nil {
+				if profile.Industry != "" {
+					sector = profile.Industry
+				}
+				marketCap = MarketCapBucket(profile.MarketCap)
+			} else {
+				log.Printf("Failed to get profile for %s: %v", h.Symbol, err)
+			}
+		}
+
+		costBasis := h.Quantity.Mul(h.AvgBuyPrice)
+		unrealized := p.Value.Sub(costBasis)
+		details = append(details, PositionDetail{
+			Symbol:               h.Symbol,
+			Type:                 h.Type,
+			Quantity:             h.Quantity.StringFixed(8),
+			AvgCost:              display(h.AvgBuyPrice),
+			CurrentPrice:         display(p.Price),
+			Value:                display(p.Value),
+			CostBasis:            display(costBasis),
+			Weight:               percentOf(p.Value, totalBalance).StringFixed(2),
+			UnrealizedPnL:        display(unrealized),
+			UnrealizedPnLPercent: percentOf(unrealized, costBasis).StringFixed(2),
+			Sector:               sector,
+			MarketCap:            marketCap,
+			PriceStale:           !p.Priced,
+		})
+
+		byAssetClass[string(h.Type)] = byAssetClass[string(h.Type)].Add(p.Value)
+		bySector[sector] = bySector[sector].Add(p.Value)
+		byMarketCap[marketCap] = byMarketCap[marketCap].Add(p.Value)
+	}
+
 	response := PortfolioResponse{
-		TotalBalance:     totalBalance.StringFixed(2),
-		CashBalance:      cashBalance.StringFixed(2),
-		HoldingsValue:    holdingsValue.StringFixed(2),
-		TotalInvested:    totalInvested.StringFixed(2),
+		TotalBalance:     display(totalBalance),
+		CashBalance:      display(cashBalance),
+		HoldingsValue:    display(holdingsValue),
+		TotalInvested:    display(totalInvested),
 		PercentageChange: percentageChange.StringFixed(2),
-		TodayPnL:         todayPnL.StringFixed(2),
-		TotalReturn:      totalReturn.StringFixed(2),
-		RealizedPnL:      realizedPnL.StringFixed(2),
-		UnrealizedPnL:    unrealizedPnL.StringFixed(2),
+		TodayPnL:         display(todayPnL),
+		TotalReturn:      display(totalReturn),
+		RealizedPnL:      display(realizedPnL),
+		UnrealizedPnL:    display(unrealizedPnL),
+		InterestEarned:   display(interestEarned),
+		Currency:         currency,
+		CashBalances:     cashDetails,
+		Positions:        details,
+		Breakdown: Breakdown{
+			AssetClass: allocationSlices(byAssetClass, totalBalance, displayRate),
+			Sector:     allocationSlices(bySector, holdingsValue, displayRate),
+			MarketCap:  allocationSlices(byMarketCap, holdingsValue, displayRate),
+		},
 	}
 
 	return c.JSON(fiber.Map{
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestAllocationSlices(t *testing.T) {
+	fmt.Println("Starting unit tests for portfolioHandler.go")
+	fmt.Println("Testing allocationSlices function")
+
+	groups := map[string]decimal.Decimal{
+		"Technology": decimal.NewFromInt(750),
+		"Crypto":     decimal.NewFromInt(250),
+	}
+
+	slices := allocationSlices(groups, decimal.NewFromInt(1000), decimal.NewFromInt(1))
+	if len(slices) != 2 {
+		t.Fatalf("Expected 2 slices, got %d", len(slices))
+	}
+	if slices[0].Name != "Technology" || slices[0].Weight != "75.00" {
+		t.Fatalf("Expected Technology at 75.00 first, got %s at %s", slices[0].Name, slices[0].Weight)
+	}
+	if slices[1].Name != "Crypto" || slices[1].Weight != "25.00" {
+		t.Fatalf("Expected Crypto at 25.00 second, got %s at %s", slices[1].Name, slices[1].Weight)
+	}
+}
+
+func TestMarketCapBucket(t *testing.T) {
+	fmt.Println("Testing MarketCapBucket function")
+
+	cases := map[float64]string{
+		3_000_000: "Mega Cap",
+		50_000:    "Large Cap",
+		5_000:     "Mid Cap",
+		500:       "Small Cap",
+		100:       "Micro Cap",
+		0:         "Unknown",
+	}
+	for marketCap, want := range cases {
+		if got := MarketCapBucket(marketCap); got != want {
+			t.Fatalf("Expected %v to be %s, got %s", marketCap, want, got)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"jfernsio/stonksbackend/config"
+	"jfernsio/stonksbackend/database"
+	"jfernsio/stonksbackend/models"
+	"jfernsio/stonksbackend/utils"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+type EquityPoint struct {
+	Time          time.Time             `json:"time"`
+	TotalValue    string                `json:"total_value"`
+	Cash          string                `json:"cash"`
+	HoldingsValue string                `json:"holdings_value"`
+	Source        models.SnapshotSource `json:"source"`
+}
+
+// TakePortfolioSnapshot values the wallet at current prices and stores the
+// result with one row per position
+func TakePortfolioSnapshot(db *gorm.DB, wallet models.Wallet, fihubApi string, source models.SnapshotSource, prices map[string]decimal.Decimal) (models.PortfolioSnapshot, error) {
+	var holdings []models.Holding
+	if err := db.Where("wallet_id = ?", wallet.ID).Find(&holdings).Error; err != nil {
+		return models.PortfolioSnapshot{}, err
+	}
+
+	holdingsValue := decimal.Zero
+	positions := make([]models.PositionSnapshot, 0, len(holdings))
+	for _, p := range ValuePositions(holdings, fihubApi, prices) {
+		holdingsValue = holdingsValue.Add(p.Value)
+		positions = append(positions, models.PositionSnapshot{
+			Symbol:      p.Holding.Symbol,
+			Type:        p.Holding.Type,
+			Quantity:    p.Holding.Quantity,
+			AvgBuyPrice: p.Holding.AvgBuyPrice,
+			Price:       p.Price,
+			Value:       p.Value,
+		})
+	}
+
+	// cash includes foreign currency at today's rates
+	foreignCash, err := ForeignCashValue(context.Background(), db, wallet.ID)
+	if err != nil {
+		return models.PortfolioSnapshot{}, err
+	}
+	cash := wallet.Balance.Add(foreignCash)
+
+	snapshot := models.PortfolioSnapshot{
+		WalletID:      wallet.ID,
+		Cash:          cash,
+		HoldingsValue: holdingsValue,
+		TotalValue:    cash.Add(holdingsValue),
+		Source:        source,
+		TakenAt:       time.Now(),
+		Positions:     positions,
+	}
+	if err := db.Create(&snapshot).Error; err != nil {
+		return models.PortfolioSnapshot{}, err
+	}
+	return snapshot, nil
+}
+
+// SnapshotAllPortfolios takes today's close snapshot for every wallet that
+// doesn't have one yet, so a restart after the close doesn't double up
+func SnapshotAllPortfolios(fihubApi string) (int, error) {
+	db := database.Database.Db
+	today := utils.StartOfDay(time.Now())
+
+	var wallets []models.Wallet
+	err := db.Where("id NOT IN (?)",
+		db.Model(&models.PortfolioSnapshot{}).
+			Select("wallet_id").
+			Where("source = ? AND taken_at >= ?", models.SnapshotClose, today),
+	).Find(&wallets).Error
+	if err != nil {
+		return 0, err
+	}
+
+	prices := make(map[string]decimal.Decimal)
+	count := 0
+	for _, wallet := range wallets {
+		if _, err := TakePortfolioSnapshot(db, wallet, fihubApi, models.SnapshotClose, prices); err != nil {
+			log.Printf("Failed to snapshot wallet %d: %v", wallet.ID, err)
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+// StartPortfolioSnapshotJob snapshots every portfolio shortly after each
+// New York market close. It runs daily since crypto trades through weekends.
+func StartPortfolioSnapshotJob(fihubApi string) {
+	for {
+		next := utils.NextMarketClose(time.Now())
+		time.Sleep(time.Until(next))
+		if !runsJobs() {
+			continue
+		}
+
+		count, err := SnapshotAllPortfolios(fihubApi)
+		if err != nil {
+			log.Printf("Portfolio snapshot job failed: %v", err)
+			continue
+		}
+		log.Printf("Took close snapshots for %d portfolios", count)
+	}
+}
+
+// PriorCloseEquity returns the total value of the wallet at the most recent
+// close before today and when that close was taken, ignoring closes from
+// before an account reset
+func PriorCloseEquity(db *gorm.DB, wallet models.Wallet, now time.Time) (decimal.Decimal, time.Time, bool) {
+	var snapshot models.PortfolioSnapshot
+	query := db.Where("wallet_id = ? AND source = ? AND taken_at < ?", wallet.ID, models.SnapshotClose, utils.StartOfDay(now))
+	if wallet.ResetAt != nil {
+		query = query.Where("taken_at >= ?", *wallet.ResetAt)
+	}
+	err := query.
+		Order("taken_at DESC").
+		First(&snapshot).Error
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Failed to fetch prior close for wallet %d: %v", wallet.ID, err)
+		}
+		return decimal.Zero, time.Time{}, false
+	}
+	return snapshot.TotalValue, snapshot.TakenAt, true
+}
+
+func CreatePortfolioSnapshot(c *fiber.Ctx) error {
+	cfg := c.Locals("config").(*config.Config)
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var wallet models.Wallet
+	if err := database.Database.Db.Where("user_id = ?", userID).First(&wallet).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Wallet not found"})
+	}
+
+	snapshot, err := TakePortfolioSnapshot(database.Database.Db, wallet, cfg.FinHub, models.SnapshotManual, nil)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to take snapshot"})
+	}
+
+	return c.Status(201).JSON(fiber.Map{
+		"status": "success",
+		"data":   snapshot,
+	})
+}
+
+// GetPortfolioHistory returns the caller's equity curve over ?range=1W|1M|YTD|ALL
+func GetPortfolioHistory(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	rng := strings.ToUpper(c.Query("range", "1M"))
+	since, ok := utils.RangeStart(rng, time.Now())
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid range"})
+	}
+
+	var wallet models.Wallet
+	if err := database.Database.Db.Where("user_id = ?", userID).First(&wallet).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Wallet not found"})
+	}
+
+	// history starts over when the account is reset
+	if wallet.ResetAt != nil && wallet.ResetAt.After(since) {
+		since = *wallet.ResetAt
+	}
+
+	var snapshots []models.PortfolioSnapshot
+	if err := database.Database.Db.
+		Where("wallet_id = ? AND taken_at >= ?", wallet.ID, since).
+		Order("taken_at").
+		Find(&snapshots).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch portfolio history"})
+	}
+
+	points := make([]EquityPoint, 0, len(snapshots))
+	for _, s := range snapshots {
+		points = append(points, EquityPoint{
+			Time:          s.TakenAt,
+			TotalValue:    s.TotalValue.StringFixed(2),
+			Cash:          s.Cash.StringFixed(2),
+			HoldingsValue: s.HoldingsValue.StringFixed(2),
+			Source:        s.Source,
+		})
+	}
+
+	summary := fiber.Map{}
+	if len(snapshots) > 0 {
+		first, last := snapshots[0].TotalValue, snapshots[len(snapshots)-1].TotalValue
+		change := last.Sub(first)
+		changePct := decimal.Zero
+		if !first.IsZero() {
+			changePct = change.Div(first).Mul(decimal.NewFromInt(100))
+		}
+		summary = fiber.Map{
+			"start_value":    first.StringFixed(2),
+			"end_value":      last.StringFixed(2),
+			"change":         change.StringFixed(2),
+			"change_percent": changePct.StringFixed(2),
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"range":   rng,
+		"data":    points,
+		"summary": summary,
+	})
+}
@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"jfernsio/stonksbackend/config"
+	"jfernsio/stonksbackend/database"
+	"jfernsio/stonksbackend/models"
+	"jfernsio/stonksbackend/utils"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/gofiber/websocket/v2"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// ticks arriving faster than this are folded into one update
+	portfolioStreamInterval = time.Second
+	portfolioPongWait       = 60 * time.Second
+	portfolioPingPeriod     = portfolioPongWait * 9 / 10
+	portfolioWriteWait      = 10 * time.Second
+	// a trade publishes a fill and a balance change, both are reloaded once
+	portfolioReloadDelay = 250 * time.Millisecond
+)
+
+// LivePosition is one holding in a live portfolio update
+type LivePosition struct {
+	Symbol               string             `json:"symbol"`
+	Type                 models.HoldingType `json:"type"`
+	Quantity             string             `json:"quantity"`
+	Price                string             `json:"price"`
+	Value                string             `json:"value"`
+	UnrealizedPnL        string             `json:"unrealized_pnl"`
+	UnrealizedPnLPercent string             `json:"unrealized_pnl_percent"`
+	// Live is set once the price comes from the stream rather than a quote
+	Live bool `json:"live"`
+}
+
+// LivePortfolio is pushed to the portfolio stream on connect, after the user
+// trades, and whenever a held symbol ticks
+type LivePortfolio struct {
+	Type          string         `json:"type"`
+	TotalEquity   string         `json:"total_equity"`
+	CashBalance   string         `json:"cash_balance"`
+	HoldingsValue string         `json:"holdings_value"`
+	UnrealizedPnL string         `json:"unrealized_pnl"`
+	Positions     []LivePosition `json:"positions"`
+	// Updated lists the holdings whose ticks caused this update
+	Updated []string `json:"updated"`
+	Time    int64    `json:"time"`
+}
+
+// livePortfolio is a user's holdings kept valued from the price hub
+type livePortfolio struct {
+	// cash in every currency, in the wallet's currency
+	cash      decimal.Decimal
+	positions []PositionValue
+	live      map[string]bool
+	// listingRates convert the ticks of stocks listed in another currency
+	listingRates map[string]decimal.Decimal
+}
+
+// holdingStream is the hub symbol a holding is priced from, "" for holdings
+// with a fixed price like stablecoins
+func holdingStream(h models.Holding) string {
+	switch h.Type {
+	case models.STOCK:
+		return h.Symbol
+	case models.CRYPTO:
+		if stablecoins[h.Symbol] {
+			return ""
+		}
+		return utils.BinancePrefix + h.Symbol + DefaultQuoteAsset
+	}
+	return ""
+}
+
+// loadLivePortfolio values the user's holdings, taking prices from known
+// before asking for quotes
+func loadLivePortfolio(userID uint, fihubApi string, known map[string]decimal.Decimal) (*livePortfolio, error) {
+	db := database.Database.Db
+	var wallet models.Wallet
+	if err := db.Where("user_id = ?", userID).First(&wallet).Error; err != nil {
+		return nil, err
+	}
+	var holdings []models.Holding
+	if err := db.Where("wallet_id = ?", wallet.ID).Order("symbol").Find(&holdings).Error; err != nil {
+		return nil, err
+	}
+	foreign, err := ForeignCashValue(context.Background(), db, wallet.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	listingRates := make(map[string]decimal.Decimal)
+	for _, h := range holdings {
+		if h.Type != models.STOCK {
+			continue
+		}
+		currency, rate, err := listingRate(context.Background(), h.Symbol, fihubApi)
+		if err != nil {
+			return nil, err
+		}
+		if currency != BaseCurrency {
+			listingRates[h.Symbol] = rate
+		}
+	}
+
+	if known == nil {
+		known = make(map[string]decimal.Decimal)
+	}
+	return &livePortfolio{
+		cash:         wallet.Balance.Add(foreign),
+		positions:    ValuePositions(holdings, fihubApi, known),
+		live:         make(map[string]bool),
+		listingRates: listingRates,
+	}, nil
+}
+
+// streams lists the hub symbols the portfolio needs
+func (p *livePortfolio) streams() []string {
+	var symbols []string
+	for _, position := range p.positions {
+		if stream := holdingStream(position.Holding); stream != "" {
+			symbols = append(symbols, stream)
+		}
+	}
+	return symbols
+}
+
+// prices are the current prices of priced holdings, to carry over a reload
+func (p *livePortfolio) prices() map[string]decimal.Decimal {
+	prices := make(map[string]decimal.Decimal, len(p.positions))
+	for _, position := range p.positions {
+		if position.Priced {
+			prices[position.Holding.Symbol] = position.Price
+		}
+	}
+	return prices
+}
+
+// applyTicks reprices the holdings that traded and returns their symbols
+func (p *livePortfolio) applyTicks(ticks map[string]utils.Tick) []string {
+	var updated []string
+	for i := range p.positions {
+		position := &p.positions[i]
+		tick, ok := ticks[holdingStream(position.Holding)]
+		if !ok || tick.Price <= 0 {
+			continue
+		}
+		price := decimal.NewFromFloat(tick.Price)
+		if rate, ok := p.listingRates[position.Holding.Symbol]; ok {
+			price = price.Mul(rate).Round(8)
+		}
+		position.Price = price
+		position.Value = position.Holding.Quantity.Mul(price)
+		position.Priced = true
+		p.live[position.Holding.Symbol] = true
+		updated = append(updated, position.Holding.Symbol)
+	}
+	return updated
+}
+
+func (p *livePortfolio) snapshot(updated []string) LivePortfolio {
+	holdingsValue := decimal.Zero
+	unrealizedPnL := decimal.Zero
+	positions := make([]LivePosition, 0, len(p.positions))
+	for _, position := range p.positions {
+		h := position.Holding
+		costBasis := h.AvgBuyPrice.Mul(h.Quantity)
+		unrealized := position.Value.Sub(costBasis)
+		holdingsValue = holdingsValue.Add(position.Value)
+		unrealizedPnL = unrealizedPnL.Add(unrealized)
+		positions = append(positions, LivePosition{
+			Symbol:               h.Symbol,
+			Type:                 h.Type,
+			Quantity:             h.Quantity.String(),
+			Price:                position.Price.StringFixed(2),
+			Value:                position.Value.StringFixed(2),
+			UnrealizedPnL:        unrealized.StringFixed(2),
+			UnrealizedPnLPercent: percentOf(unrealized, costBasis).StringFixed(2),
+			Live:                 p.live[h.Symbol],
+		})
+	}
+
+	if updated == nil {
+		updated = []string{}
+	}
+	return LivePortfolio{
+		Type:          "portfolio",
+		TotalEquity:   p.cash.Add(holdingsValue).StringFixed(2),
+		CashBalance:   p.cash.StringFixed(2),
+		HoldingsValue: holdingsValue.StringFixed(2),
+		UnrealizedPnL: unrealizedPnL.StringFixed(2),
+		Positions:     positions,
+		Updated:       updated,
+		Time:          time.Now().UnixMilli(),
+	}
+}
+
+// PortfolioWSHandler streams the user's total equity and per position
+// unrealized P&L. Positions are revalued only when a held symbol ticks, and
+// the holdings are reloaded when the user's own trades or balance change.
+func PortfolioWSHandler(hub *utils.Hub) func(*websocket.Conn) {
+	return func(c *websocket.Conn) {
+		defer c.Close()
+
+		cfg := c.Locals("config").(*config.Config)
+		userID, ok := c.Locals("user_id").(uint)
+		if !ok {
+			return
+		}
+
+		send := func(v any) bool {
+			c.SetWriteDeadline(time.Now().Add(portfolioWriteWait))
+			return c.WriteJSON(v) == nil
+		}
+
+		watcher, ok := hub.Watch()
+		if !ok {
+			return
+		}
+		defer watcher.Close()
+
+		// fills and balance changes mean different holdings or cash. Subscribe
+		// before loading so a trade in between isn't missed.
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		sub := config.Redis.Client.Subscribe(ctx, userEventsChannel(userID))
+		defer sub.Close()
+		if _, err := sub.Receive(ctx); err != nil {
+			log.Printf("Failed to subscribe to events for user %d: %v", userID, err)
+			send(map[string]any{"type": "error", "message": "Failed to load portfolio"})
+			return
+		}
+		events := sub.Channel()
+
+		portfolio, err := loadLivePortfolio(userID, cfg.FinHub, nil)
+		if err != nil {
+			log.Printf("Failed to load portfolio for user %d: %v", userID, err)
+			send(map[string]any{"type": "error", "message": "Failed to load portfolio"})
+			return
+		}
+		watcher.Set(portfolio.streams())
+		if !send(portfolio.snapshot(nil)) {
+			return
+		}
+
+		// clients only answer pings, reading just notices them leaving
+		gone := make(chan struct{})
+		c.SetReadDeadline(time.Now().Add(portfolioPongWait))
+		c.SetPongHandler(func(string) error {
+			return c.SetReadDeadline(time.Now().Add(portfolioPongWait))
+		})
+		go func() {
+			defer close(gone)
+			for {
+				if _, _, err := c.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		ping := time.NewTicker(portfolioPingPeriod)
+		defer ping.Stop()
+		throttle := time.NewTicker(portfolioStreamInterval)
+		defer throttle.Stop()
+		pending := make(map[string]bool)
+		var reload <-chan time.Time
+
+		for {
+			select {
+			case <-watcher.Changed():
+				for _, symbol := range portfolio.applyTicks(watcher.Take()) {
+					pending[symbol] = true
+				}
+
+			case <-throttle.C:
+				if len(pending) == 0 {
+					continue
+				}
+				updated := make([]string, 0, len(pending))
+				for symbol := range pending {
+					updated = append(updated, symbol)
+				}
+				sort.Strings(updated)
+				pending = make(map[string]bool)
+				if !send(portfolio.snapshot(updated)) {
+					return
+				}
+
+			case msg, ok := <-events:
+				if !ok {
+					events = nil
+					continue
+				}
+				var event UserEvent
+				if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil ||
+					(event.Type != EventFill && event.Type != EventBalance) {
+					continue
+				}
+				if reload == nil {
+					reload = time.After(portfolioReloadDelay)
+				}
+
+			case <-reload:
+				reload = nil
+				reloaded, err := loadLivePortfolio(userID, cfg.FinHub, portfolio.prices())
+				if err != nil {
+					log.Printf("Failed to reload portfolio for user %d: %v", userID, err)
+					continue
+				}
+				for symbol := range portfolio.live {
+					reloaded.live[symbol] = true
+				}
+				portfolio = reloaded
+				watcher.Set(portfolio.streams())
+				if !send(portfolio.snapshot(nil)) {
+					return
+				}
+
+			case <-ping.C:
+				if err := c.WriteControl(websocket.PingMessage, nil, time.Now().Add(portfolioWriteWait)); err != nil {
+					return
+				}
+
+			case <-watcher.Done():
+				c.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(portfolioWriteWait))
+				return
+
+			case <-gone:
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"fmt"
+	"jfernsio/stonksbackend/models"
+	"jfernsio/stonksbackend/utils"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestHoldingStream(t *testing.T) {
+	fmt.Println("Starting unit tests for portfolioStream.go")
+	fmt.Println("Testing holdingStream function")
+
+	cases := []struct {
+		holding models.Holding
+		want    string
+	}{
+		{models.Holding{Symbol: "AAPL", Type: models.STOCK}, "AAPL"},
+		{models.Holding{Symbol: "BTC", Type: models.CRYPTO}, "BINANCE:BTCUSDT"},
+		{models.Holding{Symbol: "USDC", Type: models.CRYPTO}, ""},
+	}
+	for _, tc := range cases {
+		if got := holdingStream(tc.holding); got != tc.want {
+			t.Fatalf("Expected %q for %s, got %q", tc.want, tc.holding.Symbol, got)
+		}
+	}
+}
+
+func TestLivePortfolioApplyTicks(t *testing.T) {
+	fmt.Println("Testing livePortfolio applyTicks and snapshot functions")
+
+	aapl := models.Holding{Symbol: "AAPL", Type: models.STOCK, Quantity: decimal.NewFromInt(10), AvgBuyPrice: decimal.NewFromInt(100)}
+	btc := models.Holding{Symbol: "BTC", Type: models.CRYPTO, Quantity: decimal.NewFromFloat(0.5), AvgBuyPrice: decimal.NewFromInt(20000)}
+	p := &livePortfolio{
+		cash: decimal.NewFromInt(1000),
+		positions: []PositionValue{
+			{Holding: aapl, Price: decimal.NewFromInt(100), Value: decimal.NewFromInt(1000), Priced: true},
+			{Holding: btc, Price: decimal.NewFromInt(20000), Value: decimal.NewFromInt(10000), Priced: true},
+		},
+		live: make(map[string]bool),
+	}
+
+	updated := p.applyTicks(map[string]utils.Tick{
+		"BINANCE:BTCUSDT": {Symbol: "BINANCE:BTCUSDT", Price: 30000},
+		"MSFT":            {Symbol: "MSFT", Price: 400},
+	})
+	if len(updated) != 1 || updated[0] != "BTC" {
+		t.Fatalf("Expected only BTC updated, got %v", updated)
+	}
+
+	snap := p.snapshot(updated)
+	if snap.TotalEquity != "17000.00" {
+		t.Fatalf("Expected total equity 17000.00, got %s", snap.TotalEquity)
+	}
+	if snap.UnrealizedPnL != "5000.00" {
+		t.Fatalf("Expected unrealized P&L 5000.00, got %s", snap.UnrealizedPnL)
+	}
+	if btcPos := snap.Positions[1]; !btcPos.Live || btcPos.UnrealizedPnLPercent != "50.00" {
+		t.Fatalf("Expected live BTC up 50.00%%, got %+v", btcPos)
+	}
+	if snap.Positions[0].Live {
+		t.Fatalf("Expected AAPL still quoted, got live")
+	}
+}
+
+func TestLivePortfolioApplyTicksListingRate(t *testing.T) {
+	fmt.Println("Testing livePortfolio applyTicks for stocks listed in another currency")
+
+	infy := models.Holding{Symbol: "INFY.NS", Type: models.STOCK, Quantity: decimal.NewFromInt(10), AvgBuyPrice: decimal.NewFromInt(18)}
+	p := &livePortfolio{
+		positions:    []PositionValue{{Holding: infy, Price: decimal.NewFromInt(18), Value: decimal.NewFromInt(180), Priced: true}},
+		live:         make(map[string]bool),
+		listingRates: map[string]decimal.Decimal{"INFY.NS": decimal.RequireFromString("0.012")},
+	}
+
+	// the tick is in rupees, the position in dollars
+	p.applyTicks(map[string]utils.Tick{"INFY.NS": {Symbol: "INFY.NS", Price: 1600}})
+	if !p.positions[0].Price.Equal(decimal.RequireFromString("19.2")) {
+		t.Fatalf("Expected 1600 INR to be 19.2 USD, got %s", p.positions[0].Price)
+	}
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"jfernsio/stonksbackend/config"
+	"jfernsio/stonksbackend/database"
+	"jfernsio/stonksbackend/models"
+	"jfernsio/stonksbackend/utils"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type RankPoint struct {
+	Time       time.Time `json:"time"`
+	Rank       int64     `json:"rank"`
+	Score      float64   `json:"score"`
+	TotalUsers int64     `json:"total_users"`
+}
+
+// RecordRankSnapshots stores every user's current rank and score
+func RecordRankSnapshots(ctx context.Context) (int, error) {
+	users, err := config.Redis.Client.ZRevRangeWithScores(ctx, "leaderboard:all_time", 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+	if len(users) == 0 {
+		return 0, nil
+	}
+
+	now := time.Now()
+	snapshots := make([]models.RankSnapshot, 0, len(users))
+	for rank, user := range users {
+		userID, ok := parseLeaderboardMember(user.Member)
+		if !ok {
+			continue
+		}
+		snapshots = append(snapshots, models.RankSnapshot{
+			UserID:     userID,
+			Rank:       int64(rank + 1),
+			Score:      user.Score,
+			TotalUsers: int64(len(users)),
+			RecordedAt: now,
+		})
+	}
+
+	if err := database.Database.Db.CreateInBatches(&snapshots, 500).Error; err != nil {
+		return 0, err
+	}
+	return len(snapshots), nil
+}
+
+// StartRankHistoryJob records a rank snapshot for every user at each interval
+func StartRankHistoryJob(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !runsJobs() {
+			continue
+		}
+		count, err := RecordRankSnapshots(context.Background())
+		if err != nil {
+			log.Printf("Failed to record rank snapshots: %v", err)
+			continue
+		}
+		log.Printf("Recorded rank snapshots for %d users", count)
+	}
+}
+
+// GetRankHistory returns the caller's rank and score over ?range= (default 1M).
+// Ranges longer than a month are downsampled to the last snapshot of each day.
+func GetRankHistory(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	rng := strings.ToUpper(c.Query("range", "1M"))
+	since, ok := utils.RangeStart(rng, time.Now())
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid range"})
+	}
+
+	db := database.Database.Db
+	points := []RankPoint{}
+
+	var err error
+	switch rng {
+	case "1D", "1W", "1M":
+		err = db.Model(&models.RankSnapshot{}).
+			Select("recorded_at as time, rank, score, total_users").
+			Where("user_id = ? AND recorded_at >= ?", userID, since).
+			Order("recorded_at").
+			Scan(&points).Error
+	default:
+		err = db.Raw(`
+		SELECT time, rank, score, total_users FROM (
+			SELECT DISTINCT ON (date_trunc('day', recorded_at))
+				recorded_at as time, rank, score, total_users
+			FROM rank_snapshots
+			WHERE user_id = ? AND recorded_at >= ?
+			ORDER BY date_trunc('day', recorded_at), recorded_at DESC
+		) daily ORDER BY time`, userID, since).
+			Scan(&points).Error
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch rank history"})
+	}
+
+	summary := fiber.Map{}
+	if len(points) > 0 {
+		best := points[0].Rank
+		for _, p := range points {
+			if p.Rank < best {
+				best = p.Rank
+			}
+		}
+		first, last := points[0], points[len(points)-1]
+		summary = fiber.Map{
+			"best_rank":    best,
+			"current_rank": last.Rank,
+			// positive means the user climbed
+			"rank_change":  first.Rank - last.Rank,
+			"score_change": last.Score - first.Score,
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"range":   rng,
+		"data":    points,
+		"summary": summary,
+	})
+}
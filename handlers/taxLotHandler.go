@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"jfernsio/stonksbackend/database"
+	"jfernsio/stonksbackend/models"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var costBasisMethods = map[models.CostBasisMethod]bool{
+	models.FIFO:        true,
+	models.LIFO:        true,
+	models.HIFO:        true,
+	models.AverageCost: true,
+	models.SpecificLot: true,
+}
+
+type TaxLotItem struct {
+	models.TaxLot
+	Term       models.HoldingTerm `json:"term"`
+	LongTermAt time.Time          `json:"long_term_at"`
+}
+
+// GetTaxLots lists the caller's open lots, optionally for a single ?symbol=
+func GetTaxLots(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var wallet models.Wallet
+	if err := database.Database.Db.Where("user_id = ?", userID).First(&wallet).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Wallet not found"})
+	}
+
+	query := database.Database.Db.Where("wallet_id = ? AND remaining > 0", wallet.ID)
+	if symbol := strings.ToUpper(strings.TrimSpace(c.Query("symbol"))); symbol != "" {
+		query = query.Where("symbol = ?", symbol)
+	}
+
+	var lots []models.TaxLot
+	if err := query.Order("symbol, acquired_at, id").Find(&lots).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch lots"})
+	}
+
+	now := time.Now()
+	items := make([]TaxLotItem, 0, len(lots))
+	for _, lot := range lots {
+		items = append(items, TaxLotItem{
+			TaxLot:     lot,
+			Term:       holdingTerm(lot.AcquiredAt, now),
+			LongTermAt: lot.AcquiredAt.AddDate(1, 0, 0),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data":   items,
+	})
+}
+
+func GetCostBasisMethod(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var user models.UserModel
+	if err := database.Database.Db.Select("id", "cost_basis_method").First(&user, userID).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"method": user.CostBasisMethod,
+	})
+}
+
+func UpdateCostBasisMethod(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	type request struct {
+		Method string `json:"method"`
+	}
+
+	var body request
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	method := models.CostBasisMethod(strings.ToUpper(strings.TrimSpace(body.Method)))
+	if !costBasisMethods[method] {
+		return c.Status(400).JSON(fiber.Map{"error": "Method must be one of FIFO, LIFO, HIFO, AVERAGE or SPECIFIC"})
+	}
+
+	if err := database.Database.Db.Model(&models.UserModel{}).
+		Where("id = ?", userID).
+		Update("cost_basis_method", method).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update cost basis method"})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"method": method,
+	})
+}
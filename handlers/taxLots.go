@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"fmt"
+	"jfernsio/stonksbackend/models"
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// lotFill is the quantity a sell takes from lots[Index]
+type lotFill struct {
+	Index    int
+	Quantity decimal.Decimal
+}
+
+// holdingTerm classifies a disposal as long term when the lot was held for
+// more than a year
+func holdingTerm(acquiredAt, soldAt time.Time) models.HoldingTerm {
+	if soldAt.After(acquiredAt.AddDate(1, 0, 0)) {
+		return models.LongTerm
+	}
+	return models.ShortTerm
+}
+
+// combineTerms returns the term shared by all disposals or MIXED
+func combineTerms(disposals []models.LotDisposal) models.HoldingTerm {
+	var term models.HoldingTerm
+	for _, d := range disposals {
+		if term == "" {
+			term = d.Term
+		} else if term != d.Term {
+			return models.MixedTerm
+		}
+	}
+	return term
+}
+
+// selectLots picks which lots a sell of qty consumes. lotIDs are only used by
+// the SPECIFIC method and are consumed in the order given. AVERAGE consumes
+// lots first in first out so holding periods are still tracked.
+func selectLots(lots []models.TaxLot, qty decimal.Decimal, method models.CostBasisMethod, lotIDs []uint) ([]lotFill, error) {
+	order := make([]int, 0, len(lots))
+
+	if method == models.SpecificLot {
+		if len(lotIDs) == 0 {
+			return nil, &tradeError{Status: 400, Message: "Specific lot ids are required"}
+		}
+		byID := make(map[uint]int, len(lots))
+		for i, lot := range lots {
+			byID[lot.ID] = i
+		}
+		seen := make(map[uint]bool, len(lotIDs))
+		for _, id := range lotIDs {
+			i, ok := byID[id]
+			if !ok {
+				return nil, &tradeError{Status: 400, Message: fmt.Sprintf("Lot %d is not an open lot of this asset", id)}
+			}
+			if !seen[id] {
+				seen[id] = true
+				order = append(order, i)
+			}
+		}
+	} else {
+		for i := range lots {
+			order = append(order, i)
+		}
+		sort.SliceStable(order, func(a, b int) bool {
+			la, lb := lots[order[a]], lots[order[b]]
+			switch method {
+			case models.LIFO:
+				if !la.AcquiredAt.Equal(lb.AcquiredAt) {
+					return la.AcquiredAt.After(lb.AcquiredAt)
+				}
+				return la.ID > lb.ID
+			case models.HIFO:
+				if cmp := la.CostPerUnit.Cmp(lb.CostPerUnit); cmp != 0 {
+					return cmp > 0
+				}
+			}
+			if !la.AcquiredAt.Equal(lb.AcquiredAt) {
+				return la.AcquiredAt.Before(lb.AcquiredAt)
+			}
+			return la.ID < lb.ID
+		})
+	}
+
+	need := qty
+	fills := make([]lotFill, 0, len(order))
+	for _, i := range order {
+		if !need.IsPositive() {
+			break
+		}
+		take := decimal.Min(need, lots[i].Remaining)
+		if !take.IsPositive() {
+			continue
+		}
+		fills = append(fills, lotFill{Index: i, Quantity: take})
+		need = need.Sub(take)
+	}
+	if need.IsPositive() {
+		return nil, &tradeError{Status: 422, Message: "Selected lots don't cover the quantity"}
+	}
+	return fills, nil
+}
+
+// openLots locks and returns the holding's lots with quantity remaining. Holdings
+// bought before lots were tracked get a single lot for the uncovered quantity,
+// at what the average price leaves after the tracked lots.
+func openLots(tx *gorm.DB, holding models.Holding) ([]models.TaxLot, error) {
+	var lots []models.TaxLot
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("wallet_id = ? AND symbol = ? AND remaining > 0", holding.WalletID, holding.Symbol).
+		Order("acquired_at, id").
+		Find(&lots).Error; err != nil {
+		return nil, err
+	}
+
+	covered := decimal.Zero
+	for _, lot := range lots {
+		covered = covered.Add(lot.Remaining)
+	}
+	if uncovered := holding.Quantity.Sub(covered); uncovered.IsPositive() {
+		lot := models.TaxLot{
+			WalletID:    holding.WalletID,
+			Symbol:      holding.Symbol,
+			Type:        holding.Type,
+			Quantity:    uncovered,
+			Remaining:   uncovered,
+			CostPerUnit: legacyLotCost(holding, lots, uncovered),
+			AcquiredAt:  holding.CreatedAt,
+		}
+		if err := tx.Create(&lot).Error; err != nil {
+			return nil, err
+		}
+		lots = append(lots, lot)
+	}
+	return lots, nil
+}
+
+// legacyLotCost is the unit cost of the uncovered quantity. The average price
+// is blended with the tracked lots bought since, so their cost is taken out.
+func legacyLotCost(holding models.Holding, lots []models.TaxLot, uncovered decimal.Decimal) decimal.Decimal {
+	cost := holding.Quantity.Mul(holding.AvgBuyPrice)
+	for _, lot := range lots {
+		cost = cost.Sub(lot.Remaining.Mul(lot.CostPerUnit))
+	}
+	if cost.IsNegative() {
+		return decimal.Zero
+	}
+	return cost.Div(uncovered)
+}
+
+// lotsAverageCost returns the weighted average cost of what's left in the lots
+func lotsAverageCost(lots []models.TaxLot) (decimal.Decimal, bool) {
+	qty, cost := decimal.Zero, decimal.Zero
+	for _, lot := range lots {
+		qty = qty.Add(lot.Remaining)
+		cost = cost.Add(lot.Remaining.Mul(lot.CostPerUnit))
+	}
+	if qty.IsZero() {
+		return decimal.Zero, false
+	}
+	return cost.Div(qty), true
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"fmt"
+	"jfernsio/stonksbackend/models"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func testLots() []models.TaxLot {
+	day := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	return []models.TaxLot{
+		{ID: 1, Remaining: decimal.NewFromInt(10), CostPerUnit: decimal.NewFromInt(100), AcquiredAt: day},
+		{ID: 2, Remaining: decimal.NewFromInt(10), CostPerUnit: decimal.NewFromInt(150), AcquiredAt: day.AddDate(0, 1, 0)},
+		{ID: 3, Remaining: decimal.NewFromInt(10), CostPerUnit: decimal.NewFromInt(120), AcquiredAt: day.AddDate(0, 2, 0)},
+	}
+}
+
+func filledIDs(lots []models.TaxLot, fills []lotFill) string {
+	out := ""
+	for _, f := range fills {
+		out += fmt.Sprintf("%d:%s ", lots[f.Index].ID, f.Quantity.String())
+	}
+	return out
+}
+
+func TestSelectLots(t *testing.T) {
+	fmt.Println("Starting unit tests for taxLots.go")
+	fmt.Println("Testing selectLots function")
+
+	lots := testLots()
+	qty := decimal.NewFromInt(15)
+
+	cases := []struct {
+		method models.CostBasisMethod
+		ids    []uint
+		want   string
+	}{
+		{models.FIFO, nil, "1:10 2:5 "},
+		{models.AverageCost, nil, "1:10 2:5 "},
+		{models.LIFO, nil, "3:10 2:5 "},
+		{models.HIFO, nil, "2:10 3:5 "},
+		{models.SpecificLot, []uint{3, 1}, "3:10 1:5 "},
+	}
+
+	for _, tc := range cases {
+		fills, err := selectLots(lots, qty, tc.method, tc.ids)
+		if err != nil {
+			t.Fatalf("Expected no error for %s, got %v", tc.method, err)
+		}
+		if got := filledIDs(lots, fills); got != tc.want {
+			t.Fatalf("Expected %s to fill %q, got %q", tc.method, tc.want, got)
+		}
+	}
+}
+
+func TestSelectLotsErrors(t *testing.T) {
+	fmt.Println("Testing selectLots errors")
+
+	lots := testLots()
+
+	if _, err := selectLots(lots, decimal.NewFromInt(5), models.SpecificLot, []uint{9}); err == nil {
+		t.Fatalf("Expected unknown lot id to fail")
+	}
+	if _, err := selectLots(lots, decimal.NewFromInt(15), models.SpecificLot, []uint{1}); err == nil {
+		t.Fatalf("Expected lots that don't cover the quantity to fail")
+	}
+	if _, err := selectLots(lots, decimal.NewFromInt(5), models.SpecificLot, nil); err == nil {
+		t.Fatalf("Expected specific method without lot ids to fail")
+	}
+}
+
+func TestHoldingTerm(t *testing.T) {
+	fmt.Println("Testing holdingTerm function")
+
+	bought := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	if term := holdingTerm(bought, bought.AddDate(1, 0, 0)); term != models.ShortTerm {
+		t.Fatalf("Expected exactly one year to be short term, got %s", term)
+	}
+	if term := holdingTerm(bought, bought.AddDate(1, 0, 1)); term != models.LongTerm {
+		t.Fatalf("Expected over one year to be long term, got %s", term)
+	}
+}
+
+func TestLegacyLotCost(t *testing.T) {
+	fmt.Println("Testing legacyLotCost function")
+
+	// 10 bought at 50 before lots, then 10 at 150 with a lot, averaging 100
+	holding := models.Holding{Quantity: decimal.NewFromInt(20), AvgBuyPrice: decimal.NewFromInt(100)}
+	lots := []models.TaxLot{{ID: 1, Remaining: decimal.NewFromInt(10), CostPerUnit: decimal.NewFromInt(150)}}
+	if got := legacyLotCost(holding, lots, decimal.NewFromInt(10)); !got.Equal(decimal.NewFromInt(50)) {
+		t.Fatalf("Expected the untracked shares to cost 50, got %s", got)
+	}
+
+	// without lots it's just the average
+	if got := legacyLotCost(holding, nil, decimal.NewFromInt(20)); !got.Equal(decimal.NewFromInt(100)) {
+		t.Fatalf("Expected the average price of 100, got %s", got)
+	}
+}
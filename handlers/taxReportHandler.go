@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"jfernsio/stonksbackend/database"
+	"jfernsio/stonksbackend/models"
+	"jfernsio/stonksbackend/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/shopspring/decimal"
+)
+
+// TaxReportRow is one line of a Form 8949 style report
+type TaxReportRow struct {
+	TransactionID uint               `json:"transaction_id"`
+	LotID         *uint              `json:"lot_id,omitempty"`
+	Description   string             `json:"description"`
+	DateAcquired  string             `json:"date_acquired"`
+	DateSold      string             `json:"date_sold"`
+	Proceeds      decimal.Decimal    `json:"proceeds"`
+	CostBasis     decimal.Decimal    `json:"cost_basis"`
+	Gain          decimal.Decimal    `json:"gain"`
+	Term          models.HoldingTerm `json:"term"`
+}
+
+type TaxReportTotals struct {
+	Proceeds  decimal.Decimal `json:"proceeds"`
+	CostBasis decimal.Decimal `json:"cost_basis"`
+	Gain      decimal.Decimal `json:"gain"`
+}
+
+func (t *TaxReportTotals) add(row TaxReportRow) {
+	t.Proceeds = t.Proceeds.Add(row.Proceeds)
+	t.CostBasis = t.CostBasis.Add(row.CostBasis)
+	t.Gain = t.Gain.Add(row.Gain)
+}
+
+// TaxReport is the year's realized gains. Totals covers the capital gains in
+// ShortTerm and LongTerm. Currency gains from converting foreign cash back are
+// ordinary income rather than capital gains, so they're kept apart.
+type TaxReport struct {
+	Year           int             `json:"year"`
+	GeneratedAt    time.Time       `json:"generated_at"`
+	ShortTerm      []TaxReportRow  `json:"short_term"`
+	LongTerm       []TaxReportRow  `json:"long_term"`
+	Currency       []TaxReportRow  `json:"currency"`
+	ShortTotals    TaxReportTotals `json:"short_term_totals"`
+	LongTotals     TaxReportTotals `json:"long_term_totals"`
+	CurrencyTotals TaxReportTotals `json:"currency_totals"`
+	Totals         TaxReportTotals `json:"totals"`
+}
+
+// BuildTaxReport walks the wallet's sells and currency conversions back to
+// cash in the tax year (New York time), including those archived by a reset,
+// and turns each consumed lot into a report row. Sells made before lots were
+// tracked and currency conversions, whose cost is pooled, are reported as a
+// single row with various acquisition dates.
+func BuildTaxReport(walletID uint, year int) (TaxReport, error) {
+	db := database.Database.Db
+	loc := utils.NewYork()
+	from := time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
+	to := from.AddDate(1, 0, 0)
+
+	report := TaxReport{
+		Year:        year,
+		GeneratedAt: time.Now(),
+		ShortTerm:   []TaxReportRow{},
+		LongTerm:    []TaxReportRow{},
+		Currency:    []TaxReportRow{},
+	}
+
+	var sells []models.Transaction
+	if err := db.Where("wallet_id = ? AND type IN ? AND created_at >= ? AND created_at < ?",
+		walletID, []models.TransactionType{models.Sell, models.FxSell}, from, to).
+		Order("created_at, id").
+		Find(&sells).Error; err != nil {
+		return report, err
+	}
+	if len(sells) == 0 {
+		return report, nil
+	}
+
+	ids := make([]uint, len(sells))
+	for i, tx := range sells {
+		ids[i] = tx.ID
+	}
+	var disposals []models.LotDisposal
+	if err := db.Where("transaction_id IN ?", ids).Order("id").Find(&disposals).Error; err != nil {
+		return report, err
+	}
+	byTx := make(map[uint][]models.LotDisposal, len(sells))
+	for _, d := range disposals {
+		byTx[d.TransactionID] = append(byTx[d.TransactionID], d)
+	}
+
+	var rows []TaxReportRow
+	for _, tx := range sells {
+		sold := tx.CreatedAt.In(loc).Format("2006-01-02")
+
+		if lots := byTx[tx.ID]; len(lots) > 0 {
+			for _, d := range lots {
+				lotID := d.LotID
+				// gain from the rounded figures so each row adds up on the form
+				proceeds, cost := d.Proceeds.Round(2), d.CostBasis.Round(2)
+				rows = append(rows, TaxReportRow{
+					TransactionID: tx.ID,
+					LotID:         &lotID,
+					Description:   fmt.Sprintf("%s %s", d.Quantity.String(), tx.Symbol),
+					DateAcquired:  d.AcquiredAt.In(loc).Format("2006-01-02"),
+					DateSold:      sold,
+					Proceeds:      proceeds,
+					CostBasis:     cost,
+					Gain:          proceeds.Sub(cost),
+					Term:          d.Term,
+				})
+			}
+			continue
+		}
+
+		proceeds, cost := tx.TotalAmount.Round(2), tx.TotalAmount.Sub(tx.RealizedPnL).Round(2)
+		row := TaxReportRow{
+			TransactionID: tx.ID,
+			Description:   fmt.Sprintf("%s %s", tx.Quantity.String(), tx.Symbol),
+			DateAcquired:  "VARIOUS",
+			DateSold:      sold,
+			Proceeds:      proceeds,
+			CostBasis:     cost,
+			Gain:          proceeds.Sub(cost),
+		}
+		if tx.Type == models.FxSell {
+			report.Currency = append(report.Currency, row)
+			report.CurrencyTotals.add(row)
+			continue
+		}
+		row.Term = tx.Term
+		if row.Term == "" || row.Term == models.MixedTerm {
+			row.Term = models.ShortTerm
+		}
+		rows = append(rows, row)
+	}
+
+	for _, row := range rows {
+		if row.Term == models.LongTerm {
+			report.LongTerm = append(report.LongTerm, row)
+			report.LongTotals.add(row)
+		} else {
+			report.ShortTerm = append(report.ShortTerm, row)
+			report.ShortTotals.add(row)
+		}
+		report.Totals.add(row)
+	}
+	return report, nil
+}
+
+var taxReportHeader = []string{"Part", "Description", "Date Acquired", "Date Sold", "Proceeds", "Cost Basis", "Gain or Loss", "Transaction", "Lot"}
+
+func taxReportCSV(report TaxReport) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	if err := w.Write(taxReportHeader); err != nil {
+		return nil, err
+	}
+	write := func(part string, rows []TaxReportRow, totals TaxReportTotals) error {
+		for _, row := range rows {
+			lot := ""
+			if row.LotID != nil {
+				lot = strconv.FormatUint(uint64(*row.LotID), 10)
+			}
+			if err := w.Write([]string{
+				part, row.Description, row.DateAcquired, row.DateSold,
+				row.Proceeds.StringFixed(2), row.CostBasis.StringFixed(2), row.Gain.StringFixed(2),
+				strconv.FormatUint(uint64(row.TransactionID), 10), lot,
+			}); err != nil {
+				return err
+			}
+		}
+		return w.Write([]string{part, "TOTAL", "", "", totals.Proceeds.StringFixed(2), totals.CostBasis.StringFixed(2), totals.Gain.StringFixed(2), "", ""})
+	}
+	if err := write("I (short term)", report.ShortTerm, report.ShortTotals); err != nil {
+		return nil, err
+	}
+	if err := write("II (long term)", report.LongTerm, report.LongTotals); err != nil {
+		return nil, err
+	}
+	if err := write("Currency (ordinary)", report.Currency, report.CurrencyTotals); err != nil {
+		return nil, err
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func taxReportPDF(report TaxReport, username string) []byte {
+	doc := utils.NewTextPDF()
+	rowFormat := "%-36s %-13s %-13s %16s %16s %16s"
+	rule := strings.Repeat("-", 115)
+
+	doc.AddLines(
+		fmt.Sprintf("Sales and Other Dispositions of Capital Assets - Tax Year %d", report.Year),
+		fmt.Sprintf("Account: %s    Generated: %s", username, report.GeneratedAt.Format("2006-01-02 15:04 MST")),
+		"Simulated trading report for educational use only.",
+		"",
+	)
+
+	section := func(title string, rows []TaxReportRow, totals TaxReportTotals) {
+		doc.AddLines(
+			title,
+			rule,
+			fmt.Sprintf(rowFormat, "(a) Description", "(b) Acquired", "(c) Sold", "(d) Proceeds", "(e) Cost basis", "(h) Gain/loss"),
+			rule,
+		)
+		for _, row := range rows {
+			doc.AddLines(fmt.Sprintf(rowFormat, row.Description, row.DateAcquired, row.DateSold,
+				row.Proceeds.StringFixed(2), row.CostBasis.StringFixed(2), row.Gain.StringFixed(2)))
+		}
+		doc.AddLines(
+			rule,
+			fmt.Sprintf(rowFormat, "Totals", "", "", totals.Proceeds.StringFixed(2), totals.CostBasis.StringFixed(2), totals.Gain.StringFixed(2)),
+			"",
+		)
+	}
+	section("Part I - Short-Term (held one year or less)", report.ShortTerm, report.ShortTotals)
+	section("Part II - Long-Term (held more than one year)", report.LongTerm, report.LongTotals)
+
+	doc.AddLines(fmt.Sprintf("Net gain or loss: %s", report.Totals.Gain.StringFixed(2)), "")
+	section("Foreign Currency - ordinary gain or loss, not included above", report.Currency, report.CurrencyTotals)
+	return doc.Bytes()
+}
+
+// GetTaxReport returns the caller's realized gains for ?year= (default last year)
+// as ?format=json, csv or pdf
+func GetTaxReport(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	year := c.QueryInt("year", time.Now().In(utils.NewYork()).Year()-1)
+	if year < 2000 || year > time.Now().Year() {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid year"})
+	}
+	format := strings.ToLower(c.Query("format", "json"))
+
+	var wallet models.Wallet
+	if err := database.Database.Db.Where("user_id = ?", userID).First(&wallet).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Wallet not found"})
+	}
+
+	report, err := BuildTaxReport(wallet.ID, year)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to build tax report"})
+	}
+
+	filename := fmt.Sprintf("capital-gains-%d", year)
+	switch format {
+	case "json":
+		return c.JSON(fiber.Map{
+			"status": "success",
+			"data":   report,
+		})
+	case "csv":
+		data, err := taxReportCSV(report)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to write csv"})
+		}
+		c.Set(fiber.HeaderContentType, "text/csv")
+		c.Attachment(filename + ".csv")
+		return c.Send(data)
+	case "pdf":
+		username, _ := c.Locals("username").(string)
+		c.Set(fiber.HeaderContentType, "application/pdf")
+		c.Attachment(filename + ".pdf")
+		return c.Send(taxReportPDF(report, username))
+	}
+	return c.Status(400).JSON(fiber.Map{"error": "Format must be json, csv or pdf"})
+}
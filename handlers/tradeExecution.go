@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"errors"
+	"jfernsio/stonksbackend/database"
+	"jfernsio/stonksbackend/models"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// tradeError is a rejected trade that maps to an http status
+type tradeError struct {
+	Status  int
+	Message string
+}
+
+func (e *tradeError) Error() string { return e.Message }
+
+// respondTradeError writes a failed trade to the client
+func respondTradeError(c *fiber.Ctx, err error) error {
+	var te *tradeError
+	if errors.As(err, &te) {
+		return c.Status(te.Status).JSON(fiber.Map{"error": te.Message})
+	}
+	log.Printf("Trade failed: %v", err)
+	return c.SendStatus(500)
+}
+
+// parseLotIDs reads the optional ?lots=1,2,3 specific lot selection of a sell
+func parseLotIDs(raw string) ([]uint, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	parts := strings.Split(raw, ",")
+	ids := make([]uint, 0, len(parts))
+	for _, part := range parts {
+		id, err := strconv.ParseUint(strings.TrimSpace(part), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, uint(id))
+	}
+	return ids, nil
+}
+
+type buyResult struct {
+	Wallet  models.Wallet
+	Holding models.Holding
+	Trade   models.Transaction
+	Lot     models.TaxLot
+	// Listing is recorded on the trade when the price was converted from it
+	Listing *listingQuote
+}
+
+type sellResult struct {
+	Wallet    models.Wallet
+	Holding   models.Holding
+	Trade     models.Transaction
+	Disposals []models.LotDisposal
+	// Listing is recorded on the trade when the price was converted from it
+	Listing *listingQuote
+}
+
+// stampListing records the quote a trade's price was converted from
+func stampListing(trade *models.Transaction, listing *listingQuote) {
+	if listing == nil || listing.Currency == BaseCurrency {
+		return
+	}
+	trade.ListingCurrency = listing.Currency
+	trade.ListingPrice = &listing.Price
+}
+
+// lockWallet loads the user's wallet for update to prevent double spending
+func lockWallet(tx *gorm.DB, userID uint, wallet *models.Wallet) error {
+	if err := tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ?", userID).
+		First(wallet).Error; err != nil {
+		return &tradeError{Status: 404, Message: "Wallet not found"}
+	}
+	return nil
+}
+
+// executeBuy debits qty * price from the user's wallet, adds to the holding and
+// opens a tax lot, all in one db transaction. listing is the quote price was
+// converted from, if any.
+func executeBuy(userID uint, symbol string, holdingType models.HoldingType, qty, price decimal.Decimal, listing *listingQuote) (buyResult, error) {
+	res := buyResult{Listing: listing}
+	err := database.Database.Db.Transaction(func(tx *gorm.DB) error {
+		if err := lockWallet(tx, userID, &res.Wallet); err != nil {
+			return err
+		}
+		return buyWithin(tx, &res, symbol, holdingType, qty, price, qty.Mul(price))
+	})
+	return res, err
+}
+
+// buyWithin is executeBuy against the already locked res.Wallet, charging
+// totalCost. It's qty * price except where price is derived from the total.
+func buyWithin(tx *gorm.DB, res *buyResult, symbol string, holdingType models.HoldingType, qty, price, totalCost decimal.Decimal) error {
+	if res.Wallet.Balance.Cmp(totalCost) < 0 {
+		return &tradeError{Status: 422, Message: "Insufficient balance"}
+	}
+
+	res.Wallet.Balance = res.Wallet.Balance.Sub(totalCost)
+	if err := tx.Save(&res.Wallet).Error; err != nil {
+		return err
+	}
+
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("wallet_id = ? AND symbol = ?", res.Wallet.ID, symbol).
+		First(&res.Holding).Error
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		//creates new holding
+		res.Holding = models.Holding{
+			WalletID:    res.Wallet.ID,
+			Symbol:      symbol,
+			Type:        holdingType,
+			Quantity:    qty,
+			AvgBuyPrice: price,
+		}
+		if err := tx.Create(&res.Holding).Error; err != nil {
+			return err
+		}
+	} else if err == nil {
+		//adds to existing holding
+		currentValue := res.Holding.Quantity.Mul(res.Holding.AvgBuyPrice)
+		newQty := res.Holding.Quantity.Add(qty)
+
+		res.Holding.AvgBuyPrice = currentValue.Add(totalCost).Div(newQty)
+		res.Holding.Quantity = newQty
+
+		if err := tx.Save(&res.Holding).Error; err != nil {
+			return err
+		}
+	} else {
+		return err
+	}
+
+	res.Trade = models.Transaction{
+		WalletID:     res.Wallet.ID,
+		Symbol:       symbol,
+		Type:         models.Buy,
+		Quantity:     qty,
+		PricePerUnit: price,
+		TotalAmount:  totalCost,
+	}
+	stampListing(&res.Trade, res.Listing)
+	if err := tx.Create(&res.Trade).Error; err != nil {
+		return err
+	}
+	if err := PostJournal(tx, res.Wallet.ID, models.JournalTrade, &res.Trade.ID, tradeMemo(res.Trade),
+		debit(models.HoldingsAccount, totalCost),
+		credit(models.CashAccount, totalCost),
+	); err != nil {
+		return err
+	}
+
+	res.Lot = models.TaxLot{
+		WalletID:      res.Wallet.ID,
+		Symbol:        symbol,
+		Type:          res.Holding.Type,
+		TransactionID: &res.Trade.ID,
+		Quantity:      qty,
+		Remaining:     qty,
+		CostPerUnit:   price,
+		AcquiredAt:    res.Trade.CreatedAt,
+	}
+	return tx.Create(&res.Lot).Error
+}
+
+// executeSell sells qty of symbol at price, consuming tax lots by the user's
+// cost basis method (or the given lot ids) to work out the realized P&L.
+// listing is the quote price was converted from, if any.
+func executeSell(userID uint, symbol string, qty, price decimal.Decimal, lotIDs []uint, listing *listingQuote) (sellResult, error) {
+	res := sellResult{Listing: listing}
+	err := database.Database.Db.Transaction(func(tx *gorm.DB) error {
+		if err := lockWallet(tx, userID, &res.Wallet); err != nil {
+			return err
+		}
+		return sellWithin(tx, &res, symbol, qty, price, "", lotIDs)
+	})
+	return res, err
+}
+
+// sellMethod is the cost basis method a sell uses: SPECIFIC when lots are
+// given, then method if set, then the user's saved one
+func sellMethod(saved, method models.CostBasisMethod, lotIDs []uint) models.CostBasisMethod {
+	if len(lotIDs) > 0 {
+		return models.SpecificLot
+	}
+	if method != "" {
+		return method
+	}
+	return saved
+}
+
+// sellWithin is executeSell against the already locked res.Wallet. method
+// overrides the user's cost basis method for sells the user can't pick lots
+// for, empty keeps it.
+func sellWithin(tx *gorm.DB, res *sellResult, symbol string, qty, price decimal.Decimal, method models.CostBasisMethod, lotIDs []uint) error {
+	totalSale := qty.Mul(price)
+
+	//lock the holding row to prevent double spending
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("wallet_id = ? AND symbol = ?", res.Wallet.ID, symbol).
+		First(&res.Holding).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &tradeError{Status: 400, Message: "You do not own this asset"}
+		}
+		return &tradeError{Status: 404, Message: "Database error"}
+	}
+
+	//check if user has enuf quantity to sell
+	if res.Holding.Quantity.Cmp(qty) < 0 {
+		return &tradeError{Status: 422, Message: "Insufficient asset quantity"}
+	}
+
+	var user models.UserModel
+	if method == "" && len(lotIDs) == 0 {
+		if err := tx.Select("id", "cost_basis_method").First(&user, res.Wallet.UserID).Error; err != nil {
+			return err
+		}
+	}
+	method = sellMethod(user.CostBasisMethod, method, lotIDs)
+
+	lots, err := openLots(tx, res.Holding)
+	if err != nil {
+		return err
+	}
+	fills, err := selectLots(lots, qty, method, lotIDs)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	costBasis := decimal.Zero
+	for _, fill := range fills {
+		lot := &lots[fill.Index]
+		unitCost := lot.CostPerUnit
+		if method == models.AverageCost {
+			unitCost = res.Holding.AvgBuyPrice
+		}
+		lotCost := fill.Quantity.Mul(unitCost)
+		costBasis = costBasis.Add(lotCost)
+
+		lot.Remaining = lot.Remaining.Sub(fill.Quantity)
+		if err := tx.Save(lot).Error; err != nil {
+			return err
+		}
+
+		res.Disposals = append(res.Disposals, models.LotDisposal{
+			LotID:      lot.ID,
+			Quantity:   fill.Quantity,
+			CostBasis:  lotCost,
+			Proceeds:   fill.Quantity.Mul(price),
+			AcquiredAt: lot.AcquiredAt,
+			Term:       holdingTerm(lot.AcquiredAt, now),
+		})
+	}
+
+	//calculate pnl against the consumed lots
+	pnl := totalSale.Sub(costBasis)
+
+	//update holding quantity
+	res.Holding.Quantity = res.Holding.Quantity.Sub(qty)
+	//if quantity is zeeo delete holding
+	if res.Holding.Quantity.IsZero() {
+		if err := tx.Delete(&res.Holding).Error; err != nil {
+			return err
+		}
+	} else {
+		// the running average follows the lots left unless the user averages
+		if method != models.AverageCost {
+			if avg, ok := lotsAverageCost(lots); ok {
+				res.Holding.AvgBuyPrice = avg
+			}
+		}
+		if err := tx.Save(&res.Holding).Error; err != nil {
+			return err
+		}
+	}
+
+	//update wallet balance
+	res.Wallet.Balance = res.Wallet.Balance.Add(totalSale)
+	if err := tx.Save(&res.Wallet).Error; err != nil {
+		return &tradeError{Status: 500, Message: "Couldnt update wallet balance"}
+	}
+
+	//create transaction record w pnl
+	res.Trade = models.Transaction{
+		WalletID:     res.Wallet.ID,
+		Symbol:       symbol,
+		Type:         models.Sell,
+		Quantity:     qty,
+		PricePerUnit: price,
+		TotalAmount:  totalSale,
+		RealizedPnL:  pnl,
+		Term:         combineTerms(res.Disposals),
+	}
+	stampListing(&res.Trade, res.Listing)
+	if err := tx.Create(&res.Trade).Error; err != nil {
+		return err
+	}
+	if err := PostJournal(tx, res.Wallet.ID, models.JournalTrade, &res.Trade.ID, tradeMemo(res.Trade),
+		debit(models.CashAccount, totalSale),
+		credit(models.HoldingsAccount, costBasis),
+		gainLine(pnl),
+	); err != nil {
+		return err
+	}
+
+	for i := range res.Disposals {
+		res.Disposals[i].TransactionID = res.Trade.ID
+	}
+	return tx.Create(&res.Disposals).Error
+}
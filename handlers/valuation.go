@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"jfernsio/stonksbackend/models"
+	"log"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// PositionValue is a holding valued at its current market price
+type PositionValue struct {
+	Holding models.Holding
+	Price   decimal.Decimal
+	Value   decimal.Decimal
+	// Priced is false when no live quote was available and Price fell back to the avg buy price
+	Priced bool
+}
+
+// HoldingPrice returns the live market price of a holding in BaseCurrency,
+// Binance for crypto and Finnhub for stocks
+func HoldingPrice(holding models.Holding, fihubApi string) (decimal.Decimal, error) {
+	if holding.Type != models.STOCK {
+		return MarketPrice(holding.Symbol)
+	}
+	quote, err := quoteStock(context.Background(), holding.Symbol, fihubApi)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return quote.Base(), nil
+}
+
+// ValuePositions prices every holding. Holdings without a live quote are valued
+// at their average buy price. prices is an optional symbol -> price cache
+// shared across calls.
+func ValuePositions(holdings []models.Holding, fihubApi string, prices map[string]decimal.Decimal) []PositionValue {
+	positions := make([]PositionValue, 0, len(holdings))
+	for _, h := range holdings {
+		price, priced := prices[h.Symbol]
+		if !priced {
+			var err error
+			price, err = HoldingPrice(h, fihubApi)
+			if err != nil {
+				log.Printf("Failed to get price for %s, using avg buy price: %v", h.Symbol, err)
+				price = h.AvgBuyPrice
+			} else {
+				priced = true
+				if prices != nil {
+					prices[h.Symbol] = price
+				}
+			}
+		}
+		positions = append(positions, PositionValue{
+			Holding: h,
+			Price:   price,
+			Value:   h.Quantity.Mul(price),
+			Priced:  priced,
+		})
+	}
+	return positions
+}
+
+// PortfolioValue returns the wallet's cash in every currency plus the market value of its holdings.
+// Falling back to cost for unpriced holdings means one flaky quote doesn't
+// knock a user down the leaderboard.
+func PortfolioValue(db *gorm.DB, wallet models.Wallet, fihubApi string, prices map[string]decimal.Decimal) (decimal.Decimal, error) {
+	var holdings []models.Holding
+	if err := db.Where("wallet_id = ?", wallet.ID).Find(&holdings).Error; err != nil {
+		return decimal.Zero, err
+	}
+
+	foreign, err := ForeignCashValue(context.Background(), db, wallet.ID)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	total := wallet.Balance.Add(foreign)
+	for _, p := range ValuePositions(holdings, fihubApi, prices) {
+		total = total.Add(p.Value)
+	}
+	return total, nil
+}
+
+// netFlowSum adds up transaction amounts with withdrawals, the argument,
+// counted as outflows
+const netFlowSum = "COALESCE(SUM(CASE WHEN type = ? THEN -total_amount ELSE total_amount END), 0)"
+
+// NetDeposits is what the user has deposited less what they've withdrawn
+// since the wallet was last reset
+func NetDeposits(db *gorm.DB, walletID uint) (decimal.Decimal, error) {
+	var net decimal.Decimal
+	err := db.Model(&models.Transaction{}).
+		Select(netFlowSum, models.Withdrawal).
+		Where("wallet_id = ? AND archived_at IS NULL AND type IN ?", walletID, []models.TransactionType{models.Deposit, models.Withdrawal}).
+		Scan(&net).Error
+	return net, err
+}
+
+// ExternalFlowsSince sums the cash deposited, withdrawn or reset into the
+// wallet after since, archived or not, the sum of externalFlow in SQL
+func ExternalFlowsSince(db *gorm.DB, walletID uint, since time.Time) (decimal.Decimal, error) {
+	var net decimal.Decimal
+	err := db.Model(&models.Transaction{}).
+		Select(netFlowSum, models.Withdrawal).
+		Where("wallet_id = ? AND created_at > ? AND type IN ?", walletID, since, []models.TransactionType{models.Deposit, models.Withdrawal, models.Reset}).
+		Scan(&net).Error
+	return net, err
+}
+
+// LeaderboardScore is the portfolio value less net deposits, the starting
+// capital plus what the user has made trading. Depositing or withdrawing
+// doesn't move it.
+func LeaderboardScore(db *gorm.DB, wallet models.Wallet, fihubApi string, prices map[string]decimal.Decimal) (total, score decimal.Decimal, err error) {
+	if total, err = PortfolioValue(db, wallet, fihubApi, prices); err != nil {
+		return total, score, err
+	}
+	deposited, err := NetDeposits(db, wallet.ID)
+	if err != nil {
+		return total, score, err
+	}
+	return total, total.Sub(deposited), nil
+}
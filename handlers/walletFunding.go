@@ -0,0 +1,410 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"jfernsio/stonksbackend/config"
+	"jfernsio/stonksbackend/database"
+	"jfernsio/stonksbackend/models"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	defaultStartingCapital  = decimal.NewFromInt(100000)
+	defaultMaxTotalDeposits = decimal.NewFromInt(1000000)
+)
+
+// cashSymbol is the symbol on transactions that only move base currency cash
+const cashSymbol = "CASH"
+
+// FundingLimits are the virtual funding settings. Zero caps mean no limit.
+type FundingLimits struct {
+	StartingCapital decimal.Decimal `json:"starting_capital"`
+	MaxDeposit      decimal.Decimal `json:"max_deposit"`
+	MaxWithdrawal   decimal.Decimal `json:"max_withdrawal"`
+	// MaxTotalDeposits caps net deposits since the last reset
+	MaxTotalDeposits decimal.Decimal `json:"max_total_deposits"`
+}
+
+func parseMoneyConfig(name, raw string, fallback decimal.Decimal) (decimal.Decimal, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return fallback, nil
+	}
+	amount, err := decimal.NewFromString(raw)
+	if err != nil || amount.IsNegative() {
+		return decimal.Zero, fmt.Errorf("invalid %s %q", name, raw)
+	}
+	return amount, nil
+}
+
+// LoadFundingLimits reads STARTING_CAPITAL, MAX_DEPOSIT, MAX_WITHDRAWAL and
+// MAX_TOTAL_DEPOSITS
+func LoadFundingLimits(cfg *config.Config) (FundingLimits, error) {
+	var limits FundingLimits
+	var err error
+	if limits.StartingCapital, err = parseMoneyConfig("STARTING_CAPITAL", cfg.StartingCapital, defaultStartingCapital); err != nil {
+		return limits, err
+	}
+	if limits.MaxDeposit, err = parseMoneyConfig("MAX_DEPOSIT", cfg.MaxDeposit, decimal.Zero); err != nil {
+		return limits, err
+	}
+	if limits.MaxWithdrawal, err = parseMoneyConfig("MAX_WITHDRAWAL", cfg.MaxWithdrawal, decimal.Zero); err != nil {
+		return limits, err
+	}
+	if limits.MaxTotalDeposits, err = parseMoneyConfig("MAX_TOTAL_DEPOSITS", cfg.MaxTotalDeposits, defaultMaxTotalDeposits); err != nil {
+		return limits, err
+	}
+	return limits, nil
+}
+
+// fundingLimits is LoadFundingLimits for handlers, falling back to defaults
+// on bad config which main already refuses to start with
+func fundingLimits(c *fiber.Ctx) FundingLimits {
+	limits, err := LoadFundingLimits(c.Locals("config").(*config.Config))
+	if err != nil {
+		log.Printf("Invalid funding config: %v", err)
+		return FundingLimits{StartingCapital: defaultStartingCapital, MaxTotalDeposits: defaultMaxTotalDeposits}
+	}
+	return limits
+}
+
+// parseFundingAmount reads {"amount": "..."} rounded to cents
+func parseFundingAmount(c *fiber.Ctx) (decimal.Decimal, error) {
+	type request struct {
+		Amount decimal.Decimal `json:"amount"`
+	}
+
+	var body request
+	if err := c.BodyParser(&body); err != nil {
+		return decimal.Zero, errors.New("Invalid request body")
+	}
+	amount := body.Amount.Round(2)
+	if !amount.IsPositive() {
+		return decimal.Zero, errors.New("Amount must be positive")
+	}
+	return amount, nil
+}
+
+// moveCash deposits (positive amount) or withdraws (negative amount) virtual
+// cash. Deposits that would take net deposits since the last reset past
+// maxTotal are refused, zero means no limit.
+func moveCash(userID uint, amount, maxTotal decimal.Decimal) (models.Wallet, models.Transaction, error) {
+	var wallet models.Wallet
+	var record models.Transaction
+
+	err := database.Database.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ?", userID).
+			First(&wallet).Error; err != nil {
+			return &tradeError{Status: 404, Message: "Wallet not found"}
+		}
+
+		if amount.IsPositive() && maxTotal.IsPositive() {
+			deposited, err := NetDeposits(tx, wallet.ID)
+			if err != nil {
+				return err
+			}
+			if deposited.Add(amount).GreaterThan(maxTotal) {
+				return &tradeError{Status: 422, Message: "Deposits are limited to " + maxTotal.StringFixed(2) + " in total"}
+			}
+		}
+
+		txType, kind := models.Deposit, models.JournalDeposit
+		lines := []models.JournalLine{
+			debit(models.CashAccount, amount),
+			credit(models.EquityAccount, amount),
+		}
+		if amount.IsNegative() {
+			if wallet.Balance.LessThan(amount.Neg()) {
+				return &tradeError{Status: 422, Message: "Insufficient balance"}
+			}
+			txType, kind = models.Withdrawal, models.JournalWithdrawal
+			lines = []models.JournalLine{
+				debit(models.EquityAccount, amount.Neg()),
+				credit(models.CashAccount, amount.Neg()),
+			}
+		}
+
+		record = models.Transaction{
+			WalletID:     wallet.ID,
+			Symbol:       cashSymbol,
+			Type:         txType,
+			Quantity:     amount.Abs(),
+			PricePerUnit: decimal.NewFromInt(1),
+			TotalAmount:  amount.Abs(),
+		}
+		if err := tx.Create(&record).Error; err != nil {
+			return err
+		}
+		if err := PostJournal(tx, wallet.ID, kind, &record.ID, tradeMemo(record), lines...); err != nil {
+			return err
+		}
+
+		wallet.Balance = wallet.Balance.Add(amount)
+		return tx.Save(&wallet).Error
+	})
+	return wallet, record, err
+}
+
+func DepositFunds(c *fiber.Ctx) error {
+	cfg := c.Locals("config").(*config.Config)
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	amount, err := parseFundingAmount(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	limits := fundingLimits(c)
+	if limits.MaxDeposit.IsPositive() && amount.GreaterThan(limits.MaxDeposit) {
+		return c.Status(422).JSON(fiber.Map{"error": "Deposits are limited to " + limits.MaxDeposit.StringFixed(2)})
+	}
+
+	wallet, record, err := moveCash(userID, amount, limits.MaxTotalDeposits)
+	if err != nil {
+		return respondTradeError(c, err)
+	}
+	go RefreshUserScore(userID, wallet, cfg.FinHub)
+
+	return c.JSON(fiber.Map{
+		"status":      "success",
+		"message":     "Deposit successful",
+		"transaction": record,
+		"balance":     wallet.Balance.StringFixed(2),
+	})
+}
+
+func WithdrawFunds(c *fiber.Ctx) error {
+	cfg := c.Locals("config").(*config.Config)
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	amount, err := parseFundingAmount(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	limits := fundingLimits(c)
+	if limits.MaxWithdrawal.IsPositive() && amount.GreaterThan(limits.MaxWithdrawal) {
+		return c.Status(422).JSON(fiber.Map{"error": "Withdrawals are limited to " + limits.MaxWithdrawal.StringFixed(2)})
+	}
+
+	wallet, record, err := moveCash(userID, amount.Neg(), decimal.Zero)
+	if err != nil {
+		return respondTradeError(c, err)
+	}
+	go RefreshUserScore(userID, wallet, cfg.FinHub)
+
+	return c.JSON(fiber.Map{
+		"status":      "success",
+		"message":     "Withdrawal successful",
+		"transaction": record,
+		"balance":     wallet.Balance.StringFixed(2),
+	})
+}
+
+// liquidationMethod picks the lots for sells the user doesn't choose lots for,
+// whatever their saved method
+const liquidationMethod = models.FIFO
+
+// resetWallet sells every holding at prices and converts foreign cash back at
+// rates, archives the wallet's history and puts the balance back to the
+// starting capital
+func resetWallet(userID uint, prices map[string]decimal.Decimal, rates FXRates, startingCapital decimal.Decimal) (models.Wallet, models.Transaction, error) {
+	var wallet models.Wallet
+	var record models.Transaction
+
+	err := database.Database.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ?", userID).
+			First(&wallet).Error; err != nil {
+			return &tradeError{Status: 404, Message: "Wallet not found"}
+		}
+
+		var holdings []models.Holding
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("wallet_id = ?", wallet.ID).
+			Find(&holdings).Error; err != nil {
+			return err
+		}
+
+		// liquidate at the quoted price, or at cost without a quote, through the
+		// lots like any sale so the tax report keeps the disposals. There are no
+		// lots to pick, so FIFO stands in for a user on SPECIFIC.
+		for _, h := range holdings {
+			price, ok := prices[h.Symbol]
+			if !ok {
+				price = h.AvgBuyPrice
+			}
+			sale := sellResult{Wallet: wallet}
+			if err := sellWithin(tx, &sale, h.Symbol, h.Quantity, price, liquidationMethod, nil); err != nil {
+				return err
+			}
+			wallet = sale.Wallet
+		}
+
+		var cashBalances []models.CashBalance
+		if err := tx.Where("wallet_id = ? AND amount > 0", wallet.ID).Find(&cashBalances).Error; err != nil {
+			return err
+		}
+		for _, b := range cashBalances {
+			if _, err := sellForeign(tx, &wallet, b.Currency, b.Amount, rates); err != nil {
+				return err
+			}
+		}
+
+		// lots left behind by a holding that no longer exists
+		if err := tx.Model(&models.TaxLot{}).
+			Where("wallet_id = ? AND remaining > 0", wallet.ID).
+			Update("remaining", decimal.Zero).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err := tx.Model(&models.Transaction{}).
+			Where("wallet_id = ? AND archived_at IS NULL", wallet.ID).
+			Update("archived_at", now).Error; err != nil {
+			return err
+		}
+
+		// the reset is the new account's opening cash
+		record = models.Transaction{
+			WalletID:     wallet.ID,
+			Symbol:       cashSymbol,
+			Type:         models.Reset,
+			Quantity:     startingCapital,
+			PricePerUnit: decimal.NewFromInt(1),
+			TotalAmount:  startingCapital,
+		}
+		if err := tx.Create(&record).Error; err != nil {
+			return err
+		}
+
+		diff := startingCapital.Sub(wallet.Balance)
+		lines := []models.JournalLine{
+			debit(models.CashAccount, diff),
+			credit(models.EquityAccount, diff),
+		}
+		if diff.IsNegative() {
+			lines = []models.JournalLine{
+				debit(models.EquityAccount, diff.Neg()),
+				credit(models.CashAccount, diff.Neg()),
+			}
+		}
+		if err := PostJournal(tx, wallet.ID, models.JournalReset, &record.ID, "account reset", lines...); err != nil {
+			return err
+		}
+
+		wallet.Balance = startingCapital
+		wallet.ResetAt = &now
+		return tx.Save(&wallet).Error
+	})
+	return wallet, record, err
+}
+
+// ResetWallet starts the caller's account over. It needs {"confirm": true}
+// since the old history is archived.
+func ResetWallet(c *fiber.Ctx) error {
+	cfg := c.Locals("config").(*config.Config)
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	type request struct {
+		Confirm bool `json:"confirm"`
+	}
+
+	var body request
+	if err := c.BodyParser(&body); err != nil || !body.Confirm {
+		return c.Status(400).JSON(fiber.Map{"error": "Resetting archives your history, send {\"confirm\": true} to continue"})
+	}
+
+	var wallet models.Wallet
+	if err := database.Database.Db.Where("user_id = ?", userID).First(&wallet).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Wallet not found"})
+	}
+	var holdings []models.Holding
+	if err := database.Database.Db.Where("wallet_id = ?", wallet.ID).Find(&holdings).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch holdings"})
+	}
+
+	// quote outside the db transaction so rows aren't locked during api calls
+	prices := make(map[string]decimal.Decimal)
+	ValuePositions(holdings, cfg.FinHub, prices)
+	rates, err := GetFXRates(c.Context())
+	if err != nil {
+		// foreign cash is converted back at cost
+		log.Printf("Failed to get fx rates for reset: %v", err)
+	}
+
+	wallet, record, err := resetWallet(userID, prices, rates, fundingLimits(c).StartingCapital)
+	if err != nil {
+		return respondTradeError(c, err)
+	}
+	go RefreshUserScore(userID, wallet, cfg.FinHub)
+
+	return c.JSON(fiber.Map{
+		"status":      "success",
+		"message":     "Account reset",
+		"transaction": record,
+		"balance":     wallet.Balance.StringFixed(2),
+	})
+}
+
+// GetWallet returns the caller's cash in every currency, funding totals and limits
+func GetWallet(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(uint)
+	if !ok {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var wallet models.Wallet
+	if err := database.Database.Db.Where("user_id = ?", userID).First(&wallet).Error; err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Wallet not found"})
+	}
+
+	type totals struct {
+		Deposited decimal.Decimal
+		Withdrawn decimal.Decimal
+	}
+	var t totals
+	if err := database.Database.Db.Model(&models.Transaction{}).
+		Select(`
+		COALESCE(SUM(CASE WHEN type = ? THEN total_amount ELSE 0 END), 0) as deposited,
+		COALESCE(SUM(CASE WHEN type = ? THEN total_amount ELSE 0 END), 0) as withdrawn
+		`, models.Deposit, models.Withdrawal).
+		Where("wallet_id = ? AND archived_at IS NULL", wallet.ID).
+		Scan(&t).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to sum deposits"})
+	}
+
+	var cashBalances []models.CashBalance
+	if err := database.Database.Db.Where("wallet_id = ? AND amount > 0", wallet.ID).Order("currency").Find(&cashBalances).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch cash balances"})
+	}
+
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"data": fiber.Map{
+			"balance":       wallet.Balance.StringFixed(2),
+			"currency":      wallet.Currency,
+			"cash_balances": cashBalances,
+			"deposited":     t.Deposited.StringFixed(2),
+			"withdrawn":     t.Withdrawn.StringFixed(2),
+			"reset_at":      wallet.ResetAt,
+			"limits":        fundingLimits(c),
+		},
+	})
+}
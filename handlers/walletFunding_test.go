@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"fmt"
+	"jfernsio/stonksbackend/config"
+	"jfernsio/stonksbackend/models"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestLoadFundingLimits(t *testing.T) {
+	fmt.Println("Starting unit tests for walletFunding.go")
+	fmt.Println("Testing LoadFundingLimits function")
+
+	limits, err := LoadFundingLimits(&config.Config{})
+	if err != nil {
+		t.Fatalf("Expected defaults, got %v", err)
+	}
+	if !limits.StartingCapital.Equal(defaultStartingCapital) || !limits.MaxDeposit.IsZero() || !limits.MaxWithdrawal.IsZero() {
+		t.Fatalf("Expected 100000 and no per request caps, got %+v", limits)
+	}
+	if !limits.MaxTotalDeposits.Equal(defaultMaxTotalDeposits) {
+		t.Fatalf("Expected a default total deposit cap of %s, got %s", defaultMaxTotalDeposits, limits.MaxTotalDeposits)
+	}
+
+	limits, err = LoadFundingLimits(&config.Config{StartingCapital: "25000", MaxDeposit: "5000", MaxWithdrawal: " 1000.50 "})
+	if err != nil {
+		t.Fatalf("Expected valid limits, got %v", err)
+	}
+	if !limits.StartingCapital.Equal(decimal.NewFromInt(25000)) || !limits.MaxWithdrawal.Equal(decimal.RequireFromString("1000.5")) {
+		t.Fatalf("Expected configured limits, got %+v", limits)
+	}
+
+	for _, bad := range []*config.Config{{StartingCapital: "lots"}, {MaxDeposit: "-1"}, {MaxTotalDeposits: "x"}} {
+		if _, err := LoadFundingLimits(bad); err == nil {
+			t.Fatalf("Expected %+v to be rejected", bad)
+		}
+	}
+}
+
+func TestExternalFlow(t *testing.T) {
+	fmt.Println("Testing externalFlow function")
+
+	amount := decimal.NewFromInt(500)
+	cases := map[models.TransactionType]decimal.Decimal{
+		models.Deposit:    amount,
+		models.Withdrawal: amount.Neg(),
+		models.Reset:      amount,
+		models.Buy:        decimal.Zero,
+		models.Dividend:   decimal.Zero,
+	}
+	for txType, want := range cases {
+		tx := models.Transaction{Type: txType, TotalAmount: amount}
+		if got := externalFlow(tx); !got.Equal(want) {
+			t.Fatalf("Expected %s flow of %s, got %s", txType, want, got)
+		}
+	}
+}
+
+func TestResetLiquidationMethod(t *testing.T) {
+	fmt.Println("Testing sellMethod function")
+
+	// a reset of a user on SPECIFIC has no lot ids to give
+	method := sellMethod(models.SpecificLot, liquidationMethod, nil)
+	if method != models.FIFO {
+		t.Fatalf("Expected a reset to sell FIFO, got %s", method)
+	}
+	lots := testLots()
+	fills, err := selectLots(lots, decimal.NewFromInt(30), method, nil)
+	if err != nil {
+		t.Fatalf("Expected a reset of a SPECIFIC user to sell every lot, got %v", err)
+	}
+	if got := filledIDs(lots, fills); got != "1:10 2:10 3:10 " {
+		t.Fatalf("Expected every lot sold oldest first, got %q", got)
+	}
+
+	if method := sellMethod(models.HIFO, "", nil); method != models.HIFO {
+		t.Fatalf("Expected a sale to keep the saved method, got %s", method)
+	}
+	if method := sellMethod(models.FIFO, "", []uint{2}); method != models.SpecificLot {
+		t.Fatalf("Expected lot ids to sell SPECIFIC, got %s", method)
+	}
+}
@@ -1,18 +1,29 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"jfernsio/stonksbackend/config"
 	"jfernsio/stonksbackend/database"
 	"jfernsio/stonksbackend/handlers"
 	"jfernsio/stonksbackend/middlewares"
+	"jfernsio/stonksbackend/utils"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/websocket/v2"
 	"github.com/joho/godotenv"
 )
 
 func main() {
+	rebuildLeaderboard := flag.Bool("rebuild-leaderboard", false, "rebuild the redis leaderboard from postgres and exit")
+	flag.Parse()
+
 	err := godotenv.Load()
 	if err != nil {
 		log.Fatal("Error loading .env file")
@@ -24,6 +35,40 @@ func main() {
 	})
 	config.InitRedis()
 	database.ConnectToDB()
+
+	if *rebuildLeaderboard {
+		count, err := handlers.RebuildLeaderboard(context.Background(), cfg.FinHub)
+		if err != nil {
+			log.Fatal("Leaderboard rebuild failed: ", err)
+		}
+		log.Printf("Leaderboard rebuilt with %d users", count)
+		return
+	}
+	//one replica runs the background jobs, interest is keyed by day so every replica may accrue it
+	instance := utils.InstanceID()
+	jobLeader := handlers.StartJobLeader(instance)
+	if count, err := handlers.BackfillOpeningBalances(); err != nil {
+		log.Printf("Failed to backfill ledger opening balances: %v", err)
+	} else if count > 0 {
+		log.Printf("Posted ledger opening balances for %d wallets", count)
+	}
+	go handlers.StartLedgerReconciliation(time.Hour)
+	go handlers.StartLeaderboardDriftCheck(cfg.FinHub, 6*time.Hour)
+	go handlers.StartRankHistoryJob(time.Hour)
+	go handlers.StartPortfolioSnapshotJob(cfg.FinHub)
+	go handlers.StartCorporateActionsJob(cfg.FinHub, 6*time.Hour)
+	go handlers.StartCandleBackfillJob(cfg, time.Hour)
+
+	interestTiers, err := handlers.ParseInterestTiers(cfg.CashAPY, cfg.CashAPYTiers)
+	if err != nil {
+		log.Fatal("Invalid cash interest config: ", err)
+	}
+	go handlers.StartInterestJob(interestTiers)
+
+	if _, err := handlers.LoadFundingLimits(cfg); err != nil {
+		log.Fatal("Invalid funding config: ", err)
+	}
+
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     "http://localhost:3000",
 		AllowHeaders:     "Origin, Content-Type, Accept",
@@ -44,6 +89,7 @@ func main() {
 	protected := v1.Group("", middlewares.AuthMiddleware) // Apply auth middleware to all routes in this group
 
 	protected.Get("/logout", handlers.UserLogout)
+	protected.Get("/events", handlers.StreamUserEvents)
 	//market routes
 	protected.Get("insider-data", handlers.RecentTransactions)
 	protected.Get("insider-data/:symbol", handlers.GetInsiderData)
@@ -58,6 +104,8 @@ func main() {
 
 	protected.Post("/buy-crypto/:symbol/:quantity", handlers.BuyHandler)
 	protected.Post("/sell-crypto/:symbol/:quantity", handlers.SellHandler)
+	protected.Post("/crypto/swap", handlers.SwapHandler)
+	protected.Get("/crypto/pairs", handlers.GetCryptoPairs)
 
 	protected.Get("/insider-sentiment", handlers.GetInsiderSentiment)
 
@@ -66,15 +114,108 @@ func main() {
 
 	//protfoluo routes
 	protected.Get("/portfolio", handlers.PortfolioHandler)
+	protected.Get("/portfolio/history", handlers.GetPortfolioHistory)
+	protected.Get("/portfolio/analytics", handlers.GetPortfolioAnalytics)
+	protected.Get("/portfolio/benchmark", handlers.GetBenchmarkComparison)
+	protected.Post("/portfolio/snapshot", handlers.CreatePortfolioSnapshot)
 
 	//leaderboard routes
 	protected.Get("/leaderboard", handlers.GetLeaderboard)
 	protected.Get("/leaderboard/rank", handlers.GetUserRank)
+	protected.Get("/leaderboard/rank/history", handlers.GetRankHistory)
+	protected.Get("/leaderboard/around", handlers.GetLeaderboardAroundMe)
+	protected.Get("/leaderboard/friends", handlers.GetFriendsLeaderboard)
+
+	//follow routes for the friends leaderboard
+	protected.Get("/following", handlers.GetFollowing)
+	protected.Post("/follow/:userId", handlers.FollowUser)
+	protected.Delete("/follow/:userId", handlers.UnfollowUser)
 
 	protected.Get("/history", handlers.GetHistory)
+
+	//tax lot routes
+	protected.Get("/lots", handlers.GetTaxLots)
+	protected.Get("/settings/cost-basis", handlers.GetCostBasisMethod)
+	protected.Put("/settings/cost-basis", handlers.UpdateCostBasisMethod)
+
+	//corporate action routes
+	protected.Get("/corporate-actions", handlers.GetCorporateActions)
+	protected.Get("/settings/drip", handlers.GetDripSetting)
+	protected.Put("/settings/drip", handlers.UpdateDripSetting)
+
+	//virtual funding
+	protected.Get("/wallet", handlers.GetWallet)
+	protected.Post("/wallet/deposit", handlers.DepositFunds)
+	protected.Post("/wallet/withdraw", handlers.WithdrawFunds)
+	protected.Post("/wallet/reset", handlers.ResetWallet)
+
+	//currencies
+	protected.Get("/fx/rates", handlers.GetFXRatesHandler)
+	protected.Post("/wallet/convert", handlers.ConvertCurrency)
+
+	//cash interest
+	protected.Get("/interest", handlers.GetInterestSummary)
+
+	//reports
+	protected.Get("/reports/tax", handlers.GetTaxReport)
+
 	protected.Get("/watchlist", handlers.GetWatchList)
 	protected.Post("/watchlist", handlers.AddWatchList)
 	protected.Delete("/watchlist/:symbol", handlers.DeletWatchList)
-	log.Fatal(app.Listen(":8000"))
+
+	//admin routes guarded by the ADMIN_KEY header
+	admin := v1.Group("/admin", middlewares.AdminMiddleware)
+	admin.Post("/leaderboard/rebuild", handlers.RebuildLeaderboardHandler)
+	admin.Get("/leaderboard/drift", handlers.LeaderboardDriftHandler)
+	admin.Get("/corporate-actions", handlers.ListCorporateActions)
+	admin.Post("/corporate-actions", handlers.UploadCorporateActions)
+	admin.Post("/corporate-actions/process", handlers.ProcessCorporateActionsHandler)
+	admin.Get("/ledger/reconcile", handlers.ReconcileLedgerHandler)
+	admin.Get("/ledger/:walletId", handlers.GetWalletLedger)
+	admin.Post("/wallets/:walletId/adjust", handlers.AdjustWalletBalance)
+
+	//live prices, stocks from finnhub and BINANCE: symbols from binance
+	feeds := []utils.Feed{utils.NewBinanceFeed()}
+	if cfg.FinHub != "" {
+		feeds = append(feeds, utils.NewFinnhubFeed(cfg.FinHub))
+	} else {
+		log.Println("No finnhub token, live stock prices are disabled")
+	}
+	//with replicas one elected instance owns the feeds and relays ticks over redis
+	var relay *utils.FeedRelay
+	var hub *utils.Hub
+	if cfg.PriceHubMode == "redis" {
+		relay = utils.NewFeedRelay(config.Redis.Client, instance, feeds...)
+		go relay.Run()
+		hub = utils.NewHub(utils.NewRedisFeed(config.Redis.Client, instance, feeds...))
+	} else {
+		hub = utils.NewHub(feeds...)
+	}
+	//intraday candles built from live trades
+	aggregator := handlers.StartCandleAggregator(hub)
+	protected.Get("/ws/stocks/:symbol?", middlewares.WebSocketUpgrade, websocket.New(utils.StockWSHandler(hub)))
+	//live portfolio valuation
+	protected.Get("/ws/portfolio", middlewares.WebSocketUpgrade, websocket.New(handlers.PortfolioWSHandler(hub)))
+
+	//graceful shutdown closes websockets before draining http
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+		<-quit
+		log.Println("Shutting down")
+		hub.Close()
+		aggregator.Close()
+		if relay != nil {
+			relay.Close()
+		}
+		jobLeader.Close()
+		if err := app.ShutdownWithTimeout(10 * time.Second); err != nil {
+			log.Printf("Shutdown error: %v", err)
+		}
+	}()
+
+	if err := app.Listen(":8000"); err != nil {
+		log.Fatal(err)
+	}
 
 }
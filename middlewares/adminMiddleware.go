@@ -0,0 +1,24 @@
+package middlewares
+
+import (
+	"crypto/subtle"
+	"jfernsio/stonksbackend/config"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// AdminMiddleware guards maintenance routes with the ADMIN_KEY shared secret
+// sent in the X-Admin-Key header. Admin routes are disabled when no key is set.
+func AdminMiddleware(c *fiber.Ctx) error {
+	cfg := c.Locals("config").(*config.Config)
+	if cfg.AdminKey == "" {
+		return fiber.NewError(fiber.StatusForbidden, "Admin routes are disabled")
+	}
+
+	key := c.Get("X-Admin-Key")
+	if subtle.ConstantTimeCompare([]byte(key), []byte(cfg.AdminKey)) != 1 {
+		return fiber.NewError(fiber.StatusUnauthorized, "Unauthorized")
+	}
+
+	return c.Next()
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// CandleBar is a closed OHLCV bar aggregated from live trades. The unique
+// index makes a bar written twice, e.g. by two replicas, an upsert. The
+// interval column is named bar_interval, interval is a postgres keyword.
+type CandleBar struct {
+	ID       uint      `json:"id" gorm:"primaryKey"`
+	Symbol   string    `json:"symbol" gorm:"type:varchar(32);not null;uniqueIndex:idx_candle_bars_symbol_interval_start"`
+	Interval string    `json:"interval" gorm:"column:bar_interval;type:varchar(10);not null;uniqueIndex:idx_candle_bars_symbol_interval_start"`
+	Start    time.Time `json:"start" gorm:"not null;uniqueIndex:idx_candle_bars_symbol_interval_start"`
+	Open     float64   `json:"open" gorm:"not null"`
+	High     float64   `json:"high" gorm:"not null"`
+	Low      float64   `json:"low" gorm:"not null"`
+	Close    float64   `json:"close" gorm:"not null"`
+	Volume   float64   `json:"volume" gorm:"not null"`
+	Trades   int       `json:"trades" gorm:"not null"`
+}
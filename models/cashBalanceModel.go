@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// CashBalance is cash a wallet holds in a currency other than its base
+// currency. CostBasis is what the cash cost in the base currency.
+type CashBalance struct {
+	ID        uint            `json:"id" gorm:"primaryKey"`
+	WalletID  uint            `json:"wallet_id" gorm:"not null;uniqueIndex:idx_wallet_currency"`
+	Currency  string          `json:"currency" gorm:"type:varchar(3);not null;uniqueIndex:idx_wallet_currency"`
+	Amount    decimal.Decimal `json:"amount" gorm:"not null;default:0;type:decimal(20,8)"`
+	CostBasis decimal.Decimal `json:"cost_basis" gorm:"not null;default:0;type:decimal(20,8)"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
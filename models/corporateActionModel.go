@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type CorporateActionType string
+
+const (
+	SplitAction    CorporateActionType = "SPLIT"
+	DividendAction CorporateActionType = "DIVIDEND"
+)
+
+// CorporateAction is a split or cash dividend announced for a stock. Splits
+// take effect on the ex date, dividends are paid on the pay date to whoever
+// held the stock the day before the ex date.
+type CorporateAction struct {
+	ID     uint                `json:"id" gorm:"primaryKey"`
+	Symbol string              `json:"symbol" gorm:"not null;uniqueIndex:idx_corporate_action"`
+	Type   CorporateActionType `json:"type" gorm:"type:varchar(10);not null;uniqueIndex:idx_corporate_action"`
+	ExDate time.Time           `json:"ex_date" gorm:"not null;uniqueIndex:idx_corporate_action"`
+	// PayDate is only used by dividends
+	PayDate *time.Time `json:"pay_date,omitempty"`
+	// a split turns FromFactor shares into ToFactor shares, e.g. 1 -> 4
+	FromFactor decimal.Decimal `json:"from_factor" gorm:"type:decimal(20,8);default:0"`
+	ToFactor   decimal.Decimal `json:"to_factor" gorm:"type:decimal(20,8);default:0"`
+	// cash dividend per share
+	Amount      decimal.Decimal `json:"amount" gorm:"type:decimal(20,8);default:0"`
+	Source      string          `json:"source" gorm:"type:varchar(20);not null"`
+	ProcessedAt *time.Time      `json:"processed_at" gorm:"index"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
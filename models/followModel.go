@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+type Follow struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	FollowerID uint      `json:"follower_id" gorm:"not null;uniqueIndex:idx_follower_followee"`
+	FolloweeID uint      `json:"followee_id" gorm:"not null;uniqueIndex:idx_follower_followee;index:idx_follows_followee"`
+	CreatedAt  time.Time `json:"created_at"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// HistoricalCandle is a provider candle kept once fetched, since history
+// doesn't change. Only the latest bar of a series is ever refetched.
+type HistoricalCandle struct {
+	ID       uint      `json:"id" gorm:"primaryKey"`
+	Provider string    `json:"provider" gorm:"type:varchar(20);not null;uniqueIndex:idx_historical_candles_key"`
+	Symbol   string    `json:"symbol" gorm:"type:varchar(32);not null;uniqueIndex:idx_historical_candles_key"`
+	Interval string    `json:"interval" gorm:"column:bar_interval;type:varchar(10);not null;uniqueIndex:idx_historical_candles_key"`
+	Time     time.Time `json:"time" gorm:"not null;uniqueIndex:idx_historical_candles_key"`
+	Open     float64   `json:"open" gorm:"not null"`
+	High     float64   `json:"high" gorm:"not null"`
+	Low      float64   `json:"low" gorm:"not null"`
+	Close    float64   `json:"close" gorm:"not null"`
+	Volume   float64   `json:"volume" gorm:"not null"`
+	// FetchedAt tells whether the bar could still have been in progress
+	FetchedAt time.Time `json:"fetched_at" gorm:"not null"`
+}
@@ -11,6 +11,8 @@ type HoldingType string
 const (
 	STOCK  HoldingType = "STOCK"
 	CRYPTO HoldingType = "CRYPTO"
+	// foreign cash, held in CashBalance rather than as a holding
+	FIAT HoldingType = "FIAT"
 )
 
 type Holding struct {
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// InterestAccrual records the interest posted to a wallet for one day, the
+// unique index keeps the daily job from paying a day twice
+type InterestAccrual struct {
+	ID            uint            `json:"id" gorm:"primaryKey"`
+	WalletID      uint            `json:"wallet_id" gorm:"not null;uniqueIndex:idx_interest_wallet_date"`
+	Date          string          `json:"date" gorm:"type:varchar(10);not null;uniqueIndex:idx_interest_wallet_date"`
+	Balance       decimal.Decimal `json:"balance" gorm:"not null;type:decimal(20,8)"`
+	Amount        decimal.Decimal `json:"amount" gorm:"not null;type:decimal(20,8)"`
+	TransactionID uint            `json:"transaction_id"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
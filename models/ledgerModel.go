@@ -0,0 +1,58 @@
+package models
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type LedgerAccount string
+
+const (
+	// assets, debits increase them
+	CashAccount     LedgerAccount = "CASH"
+	HoldingsAccount LedgerAccount = "HOLDINGS" // positions at cost
+	FxCashAccount   LedgerAccount = "FX_CASH"  // foreign cash at cost
+	// trading fees paid, debited against cash when a trade charges one. Trades
+	// are free for now so nothing posts to it yet.
+	FeesAccount LedgerAccount = "FEES"
+	// credits increase them
+	EquityAccount LedgerAccount = "EQUITY" // starting capital, deposits and adjustments
+	IncomeAccount LedgerAccount = "INCOME" // realized gains, dividends and interest
+)
+
+type JournalKind string
+
+const (
+	JournalOpening    JournalKind = "OPENING"
+	JournalSignup     JournalKind = "SIGNUP"
+	JournalTrade      JournalKind = "TRADE"
+	JournalDividend   JournalKind = "DIVIDEND"
+	JournalInterest   JournalKind = "INTEREST"
+	JournalAdjustment JournalKind = "ADJUSTMENT"
+	JournalDeposit    JournalKind = "DEPOSIT"
+	JournalWithdrawal JournalKind = "WITHDRAWAL"
+	JournalReset      JournalKind = "RESET"
+)
+
+// JournalEntry is an append-only record of a balance change. Its lines always
+// have equal debits and credits. A wallet has at most one opening entry, so
+// replicas backfilling at the same time can't both post it.
+type JournalEntry struct {
+	ID            uint          `json:"id" gorm:"primaryKey"`
+	WalletID      uint          `json:"wallet_id" gorm:"not null;index;uniqueIndex:idx_journal_entries_opening,where:kind = 'OPENING'"`
+	Kind          JournalKind   `json:"kind" gorm:"type:varchar(20);not null"`
+	TransactionID *uint         `json:"transaction_id,omitempty" gorm:"index"`
+	Memo          string        `json:"memo"`
+	CreatedAt     time.Time     `json:"created_at"`
+	Lines         []JournalLine `json:"lines" gorm:"foreignKey:EntryID"`
+}
+
+type JournalLine struct {
+	ID       uint            `json:"id" gorm:"primaryKey"`
+	EntryID  uint            `json:"entry_id" gorm:"not null;index"`
+	WalletID uint            `json:"wallet_id" gorm:"not null;index:idx_journal_lines_wallet_account"`
+	Account  LedgerAccount   `json:"account" gorm:"type:varchar(20);not null;index:idx_journal_lines_wallet_account"`
+	Debit    decimal.Decimal `json:"debit" gorm:"not null;default:0;type:decimal(20,8)"`
+	Credit   decimal.Decimal `json:"credit" gorm:"not null;default:0;type:decimal(20,8)"`
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type SnapshotSource string
+
+const (
+	SnapshotClose  SnapshotSource = "CLOSE"
+	SnapshotManual SnapshotSource = "MANUAL"
+)
+
+type PortfolioSnapshot struct {
+	ID            uint               `json:"id" gorm:"primaryKey"`
+	WalletID      uint               `json:"wallet_id" gorm:"not null;index:idx_portfolio_snapshots_wallet_taken"`
+	Cash          decimal.Decimal    `json:"cash" gorm:"not null;type:decimal(20,8)"`
+	HoldingsValue decimal.Decimal    `json:"holdings_value" gorm:"not null;type:decimal(20,8)"`
+	TotalValue    decimal.Decimal    `json:"total_value" gorm:"not null;type:decimal(20,8)"`
+	Source        SnapshotSource     `json:"source" gorm:"type:varchar(10);not null"`
+	TakenAt       time.Time          `json:"taken_at" gorm:"not null;index:idx_portfolio_snapshots_wallet_taken"`
+	Positions     []PositionSnapshot `json:"positions,omitempty" gorm:"foreignKey:SnapshotID;constraint:OnDelete:CASCADE"`
+}
+
+type PositionSnapshot struct {
+	ID          uint            `json:"id" gorm:"primaryKey"`
+	SnapshotID  uint            `json:"snapshot_id" gorm:"not null;index"`
+	Symbol      string          `json:"symbol" gorm:"not null"`
+	Type        HoldingType     `json:"type" gorm:"type:varchar(10);not null"`
+	Quantity    decimal.Decimal `json:"quantity" gorm:"not null;type:decimal(20,8)"`
+	AvgBuyPrice decimal.Decimal `json:"avg_buy_price" gorm:"not null;type:decimal(20,8)"`
+	Price       decimal.Decimal `json:"price" gorm:"not null;type:decimal(20,8)"`
+	Value       decimal.Decimal `json:"value" gorm:"not null;type:decimal(20,8)"`
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+type RankSnapshot struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	UserID     uint      `json:"user_id" gorm:"not null;index:idx_rank_snapshots_user_recorded"`
+	Rank       int64     `json:"rank" gorm:"not null"`
+	Score      float64   `json:"score" gorm:"not null"`
+	TotalUsers int64     `json:"total_users" gorm:"not null"`
+	RecordedAt time.Time `json:"recorded_at" gorm:"not null;index:idx_rank_snapshots_user_recorded"`
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type CostBasisMethod string
+
+const (
+	FIFO        CostBasisMethod = "FIFO"
+	LIFO        CostBasisMethod = "LIFO"
+	HIFO        CostBasisMethod = "HIFO"
+	AverageCost CostBasisMethod = "AVERAGE"
+	SpecificLot CostBasisMethod = "SPECIFIC"
+)
+
+type HoldingTerm string
+
+const (
+	ShortTerm HoldingTerm = "SHORT"
+	LongTerm  HoldingTerm = "LONG"
+	MixedTerm HoldingTerm = "MIXED"
+)
+
+// TaxLot is the quantity acquired by a single buy, consumed by later sells
+type TaxLot struct {
+	ID            uint            `json:"id" gorm:"primaryKey"`
+	WalletID      uint            `json:"wallet_id" gorm:"not null;index:idx_tax_lots_wallet_symbol"`
+	Symbol        string          `json:"symbol" gorm:"not null;index:idx_tax_lots_wallet_symbol"`
+	Type          HoldingType     `json:"type" gorm:"type:varchar(10);not null"`
+	TransactionID *uint           `json:"transaction_id"`
+	Quantity      decimal.Decimal `json:"quantity" gorm:"not null;type:decimal(20,8)"`
+	Remaining     decimal.Decimal `json:"remaining" gorm:"not null;type:decimal(20,8)"`
+	CostPerUnit   decimal.Decimal `json:"cost_per_unit" gorm:"not null;type:decimal(20,8)"`
+	AcquiredAt    time.Time       `json:"acquired_at" gorm:"not null"`
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+}
+
+// LotDisposal records how much of a lot a sell consumed
+type LotDisposal struct {
+	ID            uint            `json:"id" gorm:"primaryKey"`
+	TransactionID uint            `json:"transaction_id" gorm:"not null;index"`
+	LotID         uint            `json:"lot_id" gorm:"not null;index"`
+	Quantity      decimal.Decimal `json:"quantity" gorm:"not null;type:decimal(20,8)"`
+	CostBasis     decimal.Decimal `json:"cost_basis" gorm:"not null;type:decimal(20,8)"`
+	Proceeds      decimal.Decimal `json:"proceeds" gorm:"not null;type:decimal(20,8)"`
+	AcquiredAt    time.Time       `json:"acquired_at" gorm:"not null"`
+	Term          HoldingTerm     `json:"term" gorm:"type:varchar(10);not null"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
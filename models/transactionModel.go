@@ -11,6 +11,19 @@ type TransactionType string
 const (
 	Buy  TransactionType = "BUY"
 	Sell TransactionType = "SELL"
+	// corporate actions
+	Dividend TransactionType = "DIVIDEND"
+	Split    TransactionType = "SPLIT"
+	Drip     TransactionType = "DRIP"
+	// cash yield
+	Interest TransactionType = "INTEREST"
+	// virtual funding
+	Deposit    TransactionType = "DEPOSIT"
+	Withdrawal TransactionType = "WITHDRAWAL"
+	Reset      TransactionType = "RESET"
+	// buying or selling foreign cash with the wallet's base currency
+	FxBuy  TransactionType = "FX_BUY"
+	FxSell TransactionType = "FX_SELL"
 )
 
 type Transaction struct {
@@ -21,9 +34,16 @@ type Transaction struct {
 	Type         TransactionType `json:"type" gorm:"type:varchar(10);not null"`
 	PricePerUnit decimal.Decimal `json:"price_per_unit" gorm:"not null;type:decimal(20,8)"`
 	TotalAmount  decimal.Decimal `json:"total_amount" gorm:"not null;type:decimal(20,8)"`
-	RealizedPnL  decimal.Decimal `json:"realized_pnl" gorm:"default:0;type:decimal(20,8)"`
-	CreatedAt    time.Time       `json:"created_at" gorm:"index:idx_transactions_wallet_created"`
-	UpdatedAt    time.Time       `json:"updated_at"`
+	Currency     string          `json:"currency" gorm:"type:varchar(3);not null;default:USD"` // of PricePerUnit and TotalAmount
+	// stocks listed in another currency keep the quote they were converted from
+	ListingCurrency string           `json:"listing_currency,omitempty" gorm:"type:varchar(3)"`
+	ListingPrice    *decimal.Decimal `json:"listing_price,omitempty" gorm:"type:decimal(20,8)"`
+	RealizedPnL     decimal.Decimal  `json:"realized_pnl" gorm:"default:0;type:decimal(20,8)"`
+	Term            HoldingTerm      `json:"term,omitempty" gorm:"type:varchar(10)"`
+	// ArchivedAt is set on everything before an account reset
+	ArchivedAt *time.Time `json:"archived_at,omitempty" gorm:"index"`
+	CreatedAt  time.Time  `json:"created_at" gorm:"index:idx_transactions_wallet_created"`
+	UpdatedAt  time.Time  `json:"updated_at"`
 
 	Wallet Wallet `gorm:"foreignKey:WalletID"`
 }
@@ -3,10 +3,12 @@ package models
 import "time"
 
 type UserModel struct {
-	ID        uint      `json:"id" gorm:"primaryKey"`
-	UserName string    `json:"user_name" gorm:"text;uniqueIndex;not null"`
-	Email     string    `json:"email" gorm:"text;uniqueIndex;not null"`
-	Password  string    `json:"password"`
-	CreatedAt time.Time `json:"created_at" gorm:"autoCreateTime"`
-	UpdatedAt time.Time `json:"updated_at" gorm:"autoUpdateTime"`
-}
\ No newline at end of file
+	ID              uint            `json:"id" gorm:"primaryKey"`
+	UserName        string          `json:"user_name" gorm:"text;uniqueIndex;not null"`
+	Email           string          `json:"email" gorm:"text;uniqueIndex;not null"`
+	Password        string          `json:"password"`
+	CostBasisMethod CostBasisMethod `json:"cost_basis_method" gorm:"type:varchar(10);not null;default:FIFO"`
+	DripEnabled     bool            `json:"drip_enabled" gorm:"not null;default:false"`
+	CreatedAt       time.Time       `json:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt       time.Time       `json:"updated_at" gorm:"autoUpdateTime"`
+}
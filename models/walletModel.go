@@ -10,6 +10,8 @@ type Wallet struct {
 	ID           uint            `json:"id" gorm:"primaryKey"`
 	UserID       uint            `json:"user_id" gorm:"not null;index:idx_wallets_user"`
 	Balance      decimal.Decimal `json:"balance" gorm:"not null;default:100000;type:decimal(20,8)"`
+	Currency     string          `json:"currency" gorm:"type:varchar(3);not null;default:USD"` // base currency of Balance, trades settle in it
+	ResetAt      *time.Time      `json:"reset_at,omitempty"`
 	CreatedAt    time.Time       `json:"created_at"`
 	UpdatedAt    time.Time       `json:"updated_at"`
 	Transactions []Transaction   `gorm:"foreignKey:WalletID"`
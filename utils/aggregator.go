@@ -0,0 +1,202 @@
+package utils
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CandleIntervals are the bar widths built from live ticks, named like
+// Twelve Data's intervals
+var CandleIntervals = map[string]time.Duration{
+	"1min": time.Minute,
+	"5min": 5 * time.Minute,
+	"1h":   time.Hour,
+}
+
+// pushes of in-progress bars and closing of idle ones happen this often
+const aggregatorPeriod = time.Second
+
+// Bar is an OHLCV candle built from ticks. Start is unix milliseconds.
+type Bar struct {
+	Symbol   string  `json:"symbol"`
+	Interval string  `json:"interval"`
+	Start    int64   `json:"start"`
+	Open     float64 `json:"open"`
+	High     float64 `json:"high"`
+	Low      float64 `json:"low"`
+	Close    float64 `json:"close"`
+	Volume   float64 `json:"volume"`
+	Trades   int     `json:"trades"`
+}
+
+// End is when the bar closes, in unix milliseconds
+func (b Bar) End() int64 {
+	return b.Start + CandleIntervals[b.Interval].Milliseconds()
+}
+
+func (b *Bar) add(t Tick) {
+	if b.Trades == 0 {
+		b.Open, b.High, b.Low = t.Price, t.Price, t.Price
+	}
+	b.High = max(b.High, t.Price)
+	b.Low = min(b.Low, t.Price)
+	b.Close = t.Price
+	b.Volume += t.Volume
+	b.Trades++
+}
+
+func barKey(symbol, interval string) string {
+	return symbol + "|" + interval
+}
+
+// Aggregator builds bars from every tick the hub streams. In-progress bars
+// are pushed to the clients watching their symbol, closed bars are handed to
+// persist in batches off the tick path.
+type Aggregator struct {
+	hub     *Hub
+	persist func([]Bar)
+
+	mu sync.Mutex
+	// open bars by barKey
+	open   map[string]*Bar
+	dirty  map[string]bool
+	closed []Bar
+
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+// NewAggregator taps hub's ticks. Call Run to start pushing and persisting.
+func NewAggregator(hub *Hub, persist func([]Bar)) *Aggregator {
+	a := &Aggregator{
+		hub:     hub,
+		persist: persist,
+		open:    make(map[string]*Bar),
+		dirty:   make(map[string]bool),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	hub.tap(a.Add)
+	return a
+}
+
+// Add folds ticks into the open bars, closing a bar when a tick lands past its
+// end. Ticks older than the open bar are late and dropped.
+func (a *Aggregator) Add(ticks []Tick) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, t := range ticks {
+		if t.Price <= 0 {
+			continue
+		}
+		for interval, width := range CandleIntervals {
+			start := t.Time - t.Time%width.Milliseconds()
+			key := barKey(t.Symbol, interval)
+			bar := a.open[key]
+			if bar != nil && start < bar.Start {
+				continue
+			}
+			if bar != nil && start > bar.Start {
+				a.closed = append(a.closed, *bar)
+				bar = nil
+			}
+			if bar == nil {
+				bar = &Bar{Symbol: t.Symbol, Interval: interval, Start: start}
+				a.open[key] = bar
+			}
+			bar.add(t)
+			a.dirty[key] = true
+		}
+	}
+}
+
+// Current returns the in-progress bar for symbol
+func (a *Aggregator) Current(symbol, interval string) (Bar, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	bar, ok := a.open[barKey(symbol, interval)]
+	if !ok {
+		return Bar{}, false
+	}
+	return *bar, true
+}
+
+// flush closes bars whose interval has passed at now and returns what needs
+// pushing and persisting
+func (a *Aggregator) flush(now time.Time) (changed, closed []Bar) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for key := range a.dirty {
+		if bar, ok := a.open[key]; ok {
+			changed = append(changed, *bar)
+		}
+	}
+	a.dirty = make(map[string]bool)
+
+	// a symbol that stops trading still closes its bars
+	for key, bar := range a.open {
+		if bar.End() <= now.UnixMilli() {
+			a.closed = append(a.closed, *bar)
+			delete(a.open, key)
+		}
+	}
+	closed, a.closed = a.closed, nil
+
+	sort.Slice(closed, func(i, j int) bool { return closed[i].Start < closed[j].Start })
+	return changed, closed
+}
+
+// Run pushes in-progress bars and persists closed ones until Close,
+// persisting whatever is open on the way out so a restart loses little
+func (a *Aggregator) Run() {
+	defer close(a.stopped)
+	ticker := time.NewTicker(aggregatorPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			changed, closed := a.flush(now)
+			for _, bar := range changed {
+				a.hub.pushBar(bar)
+			}
+			if len(closed) > 0 {
+				a.persist(closed)
+			}
+		case <-a.done:
+			_, closed := a.flush(time.Now())
+			a.mu.Lock()
+			for _, bar := range a.open {
+				closed = append(closed, *bar)
+			}
+			a.mu.Unlock()
+			if len(closed) > 0 {
+				a.persist(closed)
+			}
+			return
+		}
+	}
+}
+
+// Close stops Run once the last bars are persisted
+func (a *Aggregator) Close() {
+	a.closeOnce.Do(func() {
+		close(a.done)
+	})
+	<-a.stopped
+}
+
+// marshalBar is the message clients get for an in-progress bar
+func marshalBar(bar Bar) ([]byte, error) {
+	return json.Marshal(struct {
+		Type   string `json:"type"`
+		Symbol string `json:"symbol"`
+		Bar    Bar    `json:"bar"`
+	}{"candle", bar.Symbol, bar})
+}
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAggregatorAdd(t *testing.T) {
+	fmt.Println("Starting unit tests for aggregator.go")
+	fmt.Println("Testing Aggregator Add function")
+
+	a := NewAggregator(newOfflineHub(), func([]Bar) {})
+	minute := time.Minute.Milliseconds()
+	a.Add([]Tick{
+		{Symbol: "AAPL", Price: 100, Volume: 1, Time: 10 * minute},
+		{Symbol: "AAPL", Price: 105, Volume: 2, Time: 10*minute + 1000},
+		{Symbol: "AAPL", Price: 98, Volume: 3, Time: 10*minute + 2000},
+		// late tick from the previous minute is dropped
+		{Symbol: "AAPL", Price: 500, Volume: 9, Time: 9 * minute},
+	})
+
+	bar, ok := a.Current("AAPL", "1min")
+	if !ok {
+		t.Fatalf("Expected an open 1min bar, got none")
+	}
+	if bar.Open != 100 || bar.High != 105 || bar.Low != 98 || bar.Close != 98 || bar.Volume != 6 || bar.Trades != 3 {
+		t.Fatalf("Expected OHLCV 100/105/98/98/6 over 3 trades, got %+v", bar)
+	}
+
+	// a tick in the next minute closes the 1min bar but not the 5min one
+	a.Add([]Tick{{Symbol: "AAPL", Price: 101, Volume: 1, Time: 11 * minute}})
+	_, closed := a.flush(time.UnixMilli(11*minute + 1))
+	if len(closed) != 1 || closed[0].Interval != "1min" || closed[0].Start != 10*minute {
+		t.Fatalf("Expected the 10th minute's 1min bar closed, got %+v", closed)
+	}
+	if five, _ := a.Current("AAPL", "5min"); five.Trades != 4 {
+		t.Fatalf("Expected 4 trades in the 5min bar, got %d", five.Trades)
+	}
+}
+
+func TestAggregatorFlushIdle(t *testing.T) {
+	fmt.Println("Testing Aggregator flush function")
+
+	a := NewAggregator(newOfflineHub(), func([]Bar) {})
+	a.Add([]Tick{{Symbol: "MSFT", Price: 400, Volume: 1, Time: 0}})
+
+	changed, closed := a.flush(time.UnixMilli(30 * 1000))
+	if len(changed) != 3 || len(closed) != 0 {
+		t.Fatalf("Expected 3 changed bars and none closed, got %d and %d", len(changed), len(closed))
+	}
+	// without trades bars still close once their interval passes
+	changed, closed = a.flush(time.UnixMilli(time.Hour.Milliseconds()))
+	if len(changed) != 0 || len(closed) != 3 {
+		t.Fatalf("Expected no changes and 3 closed bars, got %d and %d", len(changed), len(closed))
+	}
+}
+
+func TestHubPushBar(t *testing.T) {
+	fmt.Println("Testing Hub pushBar function")
+
+	h := newOfflineHub()
+	client := NewClient(nil)
+	h.Register(client)
+	h.Subscribe(client, []string{"AAPL"})
+
+	h.pushBar(Bar{Symbol: "AAPL", Interval: "1min", Close: 190})
+	h.pushBar(Bar{Symbol: "MSFT", Interval: "1min", Close: 400})
+	select {
+	case msg := <-client.queue:
+		if !strings.Contains(string(msg), `"type":"candle"`) || !strings.Contains(string(msg), `"close":190`) {
+			t.Fatalf("Expected an AAPL candle message, got %s", msg)
+		}
+	default:
+		t.Fatalf("Expected a queued candle, got none")
+	}
+	if len(client.queue) != 0 {
+		t.Fatalf("Expected only the watched symbol's bar, got %d more", len(client.queue))
+	}
+}
@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/adshao/go-binance/v2"
+)
+
+// BinancePrefix marks crypto symbols streamed from Binance, e.g. BINANCE:BTCUSDT
+const BinancePrefix = "BINANCE:"
+
+// BinanceFeed streams spot trades from Binance around the clock. Streams are
+// added and removed on one connection with SUBSCRIBE and UNSUBSCRIBE.
+type BinanceFeed struct {
+	upstream
+	requestID atomic.Int64
+}
+
+func NewBinanceFeed() *BinanceFeed {
+	return &BinanceFeed{upstream: upstream{
+		name: "Binance",
+		url:  "wss://stream.binance.com:9443/ws",
+	}}
+}
+
+func (f *BinanceFeed) Name() string { return "binance" }
+
+func (f *BinanceFeed) Handles(symbol string) bool {
+	return strings.HasPrefix(symbol, BinancePrefix) && len(symbol) > len(BinancePrefix)
+}
+
+// tradeStream maps BINANCE:BTCUSDT to its stream name btcusdt@trade
+func tradeStream(symbol string) string {
+	return strings.ToLower(strings.TrimPrefix(symbol, BinancePrefix)) + "@trade"
+}
+
+func (f *BinanceFeed) request(method string, symbols []string) {
+	if len(symbols) == 0 {
+		return
+	}
+	streams := make([]string, len(symbols))
+	for i, symbol := range symbols {
+		streams[i] = tradeStream(symbol)
+	}
+	// one message for all of them, binance limits incoming messages per second
+	f.sendJSON(map[string]any{"method": method, "params": streams, "id": f.requestID.Add(1)})
+}
+
+func (f *BinanceFeed) Subscribe(symbols ...string) { f.request("SUBSCRIBE", symbols) }
+
+func (f *BinanceFeed) Unsubscribe(symbols ...string) { f.request("UNSUBSCRIBE", symbols) }
+
+func (f *BinanceFeed) Run(stop <-chan struct{}, emit func([]Tick), current func() []string) {
+	f.run(stop,
+		func() { f.Subscribe(current()...) },
+		func(msg []byte) {
+			if tick, ok := parseBinanceTrade(msg); ok {
+				emit([]Tick{tick})
+			}
+		},
+	)
+}
+
+// parseBinanceTrade reads a trade event, skipping replies to subscribe requests
+func parseBinanceTrade(msg []byte) (Tick, bool) {
+	var event binance.WsTradeEvent
+	if err := json.Unmarshal(msg, &event); err != nil || event.Event != "trade" {
+		return Tick{}, false
+	}
+	price, err := strconv.ParseFloat(event.Price, 64)
+	if err != nil {
+		return Tick{}, false
+	}
+	volume, err := strconv.ParseFloat(event.Quantity, 64)
+	if err != nil {
+		return Tick{}, false
+	}
+	return Tick{
+		Symbol: BinancePrefix + event.Symbol,
+		Price:  price,
+		Volume: volume,
+		Time:   event.TradeTime,
+		Source: "binance",
+	}, true
+}
@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseBinanceTrade(t *testing.T) {
+	fmt.Println("Starting unit tests for binanceFeed.go")
+	fmt.Println("Testing parseBinanceTrade function")
+
+	tick, ok := parseBinanceTrade([]byte(`{"e":"trade","E":1700000000100,"s":"BTCUSDT","t":1,"p":"60000.50","q":"0.25","T":1700000000000,"m":true,"M":true}`))
+	if !ok {
+		t.Fatalf("Expected a trade to parse, got rejected")
+	}
+	if tick.Symbol != "BINANCE:BTCUSDT" || tick.Price != 60000.5 || tick.Volume != 0.25 || tick.Time != 1700000000000 {
+		t.Fatalf("Expected BINANCE:BTCUSDT 0.25 at 60000.5, got %+v", tick)
+	}
+
+	if _, ok := parseBinanceTrade([]byte(`{"result":null,"id":1}`)); ok {
+		t.Fatalf("Expected a subscribe reply to be ignored, got parsed")
+	}
+}
+
+func TestBinanceFeedHandles(t *testing.T) {
+	fmt.Println("Testing BinanceFeed Handles function")
+
+	f := NewBinanceFeed()
+	if !f.Handles("BINANCE:ETHBTC") || f.Handles("AAPL") || f.Handles("BINANCE:") {
+		t.Fatalf("Expected only BINANCE: symbols to be handled")
+	}
+	if got := tradeStream("BINANCE:ETHBTC"); got != "ethbtc@trade" {
+		t.Fatalf("Expected ethbtc@trade, got %s", got)
+	}
+}
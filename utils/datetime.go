@@ -28,3 +28,59 @@ func GetDateTime() (string, string) {
 
 	return dateStr, timeStr
 }
+
+// RangeStart returns the start of a chart range such as 1W or YTD ending at now.
+// ALL returns the zero time. The second return value is false for unknown ranges.
+func RangeStart(r string, now time.Time) (time.Time, bool) {
+	switch r {
+	case "1D":
+		return now.AddDate(0, 0, -1), true
+	case "1W":
+		return now.AddDate(0, 0, -7), true
+	case "1M":
+		return now.AddDate(0, -1, 0), true
+	case "3M":
+		return now.AddDate(0, -3, 0), true
+	case "6M":
+		return now.AddDate(0, -6, 0), true
+	case "YTD":
+		return time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, now.Location()), true
+	case "1Y":
+		return now.AddDate(-1, 0, 0), true
+	case "ALL":
+		return time.Time{}, true
+	}
+	return time.Time{}, false
+}
+
+// marketCloseHour/Minute is when the daily close snapshot is taken in New York,
+// a few minutes after the 16:00 bell so closing quotes have settled
+const (
+	marketCloseHour   = 16
+	marketCloseMinute = 5
+)
+
+// NewYork returns the America/New_York location, falling back to UTC
+func NewYork() *time.Location {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// NextMarketClose returns the first daily close time strictly after now
+func NextMarketClose(now time.Time) time.Time {
+	ny := now.In(NewYork())
+	next := time.Date(ny.Year(), ny.Month(), ny.Day(), marketCloseHour, marketCloseMinute, 0, 0, ny.Location())
+	if !next.After(ny) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// StartOfDay returns midnight of t's day in New York
+func StartOfDay(t time.Time) time.Time {
+	ny := t.In(NewYork())
+	return time.Date(ny.Year(), ny.Month(), ny.Day(), 0, 0, 0, 0, ny.Location())
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRangeStart(t *testing.T) {
+	fmt.Println("Starting unit tests for datetime.go")
+	fmt.Println("Testing RangeStart function")
+
+	now := time.Date(2025, time.April, 15, 14, 35, 22, 0, time.UTC)
+
+	cases := map[string]time.Time{
+		"1D":  time.Date(2025, time.April, 14, 14, 35, 22, 0, time.UTC),
+		"1W":  time.Date(2025, time.April, 8, 14, 35, 22, 0, time.UTC),
+		"1M":  time.Date(2025, time.March, 15, 14, 35, 22, 0, time.UTC),
+		"YTD": time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC),
+		"1Y":  time.Date(2024, time.April, 15, 14, 35, 22, 0, time.UTC),
+		"ALL": {},
+	}
+
+	for r, want := range cases {
+		got, ok := RangeStart(r, now)
+		if !ok {
+			t.Fatalf("Expected range %s to be valid", r)
+		}
+		if !got.Equal(want) {
+			t.Fatalf("Expected start of %s to be %v, got %v", r, want, got)
+		}
+	}
+
+	if _, ok := RangeStart("2W", now); ok {
+		t.Fatalf("Expected range 2W to be invalid")
+	}
+}
+
+func TestNextMarketClose(t *testing.T) {
+	fmt.Println("Testing NextMarketClose function")
+
+	ny := NewYork()
+
+	before := time.Date(2025, time.April, 15, 10, 0, 0, 0, ny)
+	want := time.Date(2025, time.April, 15, 16, 5, 0, 0, ny)
+	if got := NextMarketClose(before); !got.Equal(want) {
+		t.Fatalf("Expected next close to be %v, got %v", want, got)
+	}
+
+	after := time.Date(2025, time.April, 15, 16, 5, 0, 0, ny)
+	want = time.Date(2025, time.April, 16, 16, 5, 0, 0, ny)
+	if got := NextMarketClose(after); !got.Equal(want) {
+		t.Fatalf("Expected next close to be %v, got %v", want, got)
+	}
+}
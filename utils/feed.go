@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	gws "github.com/gorilla/websocket"
+)
+
+// Tick is one trade in the schema every feed is normalized to
+type Tick struct {
+	Symbol string  `json:"symbol"`
+	Price  float64 `json:"price"`
+	Volume float64 `json:"volume"`
+	// Time is unix milliseconds
+	Time   int64  `json:"time"`
+	Source string `json:"source"`
+}
+
+// Feed is an upstream source of live trades the Hub multiplexes to clients
+type Feed interface {
+	Name() string
+	// Handles reports whether symbol streams from this feed
+	Handles(symbol string) bool
+	Subscribe(symbols ...string)
+	Unsubscribe(symbols ...string)
+	// Run delivers ticks to emit until stop is closed, reconnecting as needed
+	// and resubscribing to current() after each reconnect
+	Run(stop <-chan struct{}, emit func([]Tick), current func() []string)
+}
+
+// upstream is a websocket to a feed provider that reconnects with
+// exponential backoff
+type upstream struct {
+	name string
+	url  string
+
+	// conn is nil while disconnected
+	conn *gws.Conn
+	// gorilla allows only one concurrent writer
+	writeMu sync.Mutex
+}
+
+// sendJSON writes to the provider if connected. While disconnected the
+// message is dropped, the feed resubscribes on reconnect.
+func (u *upstream) sendJSON(v any) {
+	u.writeMu.Lock()
+	defer u.writeMu.Unlock()
+
+	if u.conn == nil {
+		return
+	}
+	u.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := u.conn.WriteJSON(v); err != nil {
+		log.Printf("%s write error: %v", u.name, err)
+	}
+}
+
+// run keeps the connection up until stop is closed, calling onConnect after
+// each connect and onMessage for everything read
+func (u *upstream) run(stop <-chan struct{}, onConnect func(), onMessage func([]byte)) {
+	backoff := minBackoff
+	for {
+		conn, _, err := gws.DefaultDialer.Dial(u.url, nil)
+		if err == nil {
+			connectedAt := time.Now()
+			u.attach(conn)
+			onConnect()
+			err = u.read(conn, stop, onMessage)
+			u.detach(conn)
+			if time.Since(connectedAt) > stableConnection {
+				backoff = minBackoff
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		log.Printf("%s connection lost, reconnecting in %s: %v", u.name, backoff, err)
+
+		select {
+		case <-time.After(backoff):
+		case <-stop:
+			return
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+func (u *upstream) attach(conn *gws.Conn) {
+	u.writeMu.Lock()
+	u.conn = conn
+	u.writeMu.Unlock()
+	log.Printf("Connected to %s", u.name)
+}
+
+func (u *upstream) detach(conn *gws.Conn) {
+	u.writeMu.Lock()
+	if u.conn == conn {
+		u.conn = nil
+	}
+	u.writeMu.Unlock()
+	conn.Close()
+}
+
+// read hands messages to onMessage until the connection fails or stop closes
+func (u *upstream) read(conn *gws.Conn, stop <-chan struct{}, onMessage func([]byte)) error {
+	alive := func() { conn.SetReadDeadline(time.Now().Add(upstreamReadWait)) }
+	alive()
+	conn.SetPongHandler(func(string) error {
+		alive()
+		return nil
+	})
+	conn.SetPingHandler(func(data string) error {
+		alive()
+		return conn.WriteControl(gws.PongMessage, []byte(data), time.Now().Add(writeWait))
+	})
+
+	done := make(chan struct{})
+	defer close(done)
+	go u.keepAlive(conn, stop, done)
+
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		alive()
+		onMessage(msg)
+	}
+}
+
+// keepAlive pings the provider so a half open connection is noticed, and
+// closes the connection when stop closes to end the blocked read
+func (u *upstream) keepAlive(conn *gws.Conn, stop, done <-chan struct{}) {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// WriteControl is safe alongside other writers
+			if err := conn.WriteControl(gws.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				return
+			}
+		case <-stop:
+			conn.WriteControl(gws.CloseMessage,
+				gws.FormatCloseMessage(gws.CloseNormalClosure, ""), time.Now().Add(writeWait))
+			conn.Close()
+			return
+		case <-done:
+			return
+		}
+	}
+}
+
+// marshalTicks is the message clients get for a symbol's ticks
+func marshalTicks(symbol string, ticks []Tick) ([]byte, error) {
+	return json.Marshal(struct {
+		Type   string `json:"type"`
+		Symbol string `json:"symbol"`
+		Data   []Tick `json:"data"`
+	}{"trade", symbol, ticks})
+}
@@ -0,0 +1,268 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// one instance holding this lock owns the upstream connections
+	leaderKey = "price_hub:leader"
+	leaderTTL = 15 * time.Second
+	// the lock is renewed, or campaigned for, this often
+	leaderRenewal = 5 * time.Second
+	// the leader also reconciles on a timer to notice expired instances
+	reconcileInterval = 10 * time.Second
+)
+
+// the lock is only touched by the instance holding it
+var (
+	renewLeader = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+	releaseLeader = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+// FeedRelay runs the upstream feeds on whichever replica holds the leader
+// lock, subscribed to the union of every instance's symbols, and republishes
+// their ticks for the RedisFeed of each instance's hub. Every replica runs a
+// relay so another takes over when the leader goes away.
+type FeedRelay struct {
+	rdb      *redis.Client
+	instance string
+	feeds    []Feed
+
+	// subscribed is what the leader has asked its feeds for
+	subscribed map[string]bool
+	mu         sync.Mutex
+
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+func NewFeedRelay(rdb *redis.Client, instance string, feeds ...Feed) *FeedRelay {
+	return &FeedRelay{
+		rdb:        rdb,
+		instance:   instance,
+		feeds:      feeds,
+		subscribed: make(map[string]bool),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+}
+
+// Run campaigns for the leader lock until Close, leading while it's held
+func (r *FeedRelay) Run() {
+	defer close(r.stopped)
+
+	var leading chan struct{}
+	var wg sync.WaitGroup
+	resign := func() {
+		if leading == nil {
+			return
+		}
+		close(leading)
+		wg.Wait()
+		leading = nil
+		log.Printf("Instance %s stopped relaying price feeds", r.instance)
+	}
+
+	ticker := time.NewTicker(leaderRenewal)
+	defer ticker.Stop()
+	for {
+		held, err := holdLeaderLock(r.rdb, leaderKey, r.instance, leading != nil)
+		if err != nil {
+			log.Printf("Price hub leader election failed: %v", err)
+		}
+		switch {
+		case held && leading == nil:
+			log.Printf("Instance %s is relaying price feeds", r.instance)
+			leading = make(chan struct{})
+			r.lead(leading, &wg)
+		case !held && leading != nil:
+			// another instance may already lead, stop before doubling connections
+			resign()
+		}
+
+		select {
+		case <-ticker.C:
+		case <-r.done:
+			resign()
+			ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+			releaseLeader.Run(ctx, r.rdb, []string{leaderKey}, r.instance)
+			cancel()
+			return
+		}
+	}
+}
+
+// lead connects the feeds and keeps their subscriptions in line with what
+// the instances want until stop is closed
+func (r *FeedRelay) lead(stop chan struct{}, wg *sync.WaitGroup) {
+	r.mu.Lock()
+	r.subscribed = make(map[string]bool)
+	r.mu.Unlock()
+
+	for _, feed := range r.feeds {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			feed.Run(stop, r.publish, func() []string { return r.subscribedTo(feed) })
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		pubsub := r.rdb.Subscribe(context.Background(), controlChannel)
+		defer pubsub.Close()
+		changes := pubsub.Channel()
+
+		ticker := time.NewTicker(reconcileInterval)
+		defer ticker.Stop()
+		r.reconcile()
+		for {
+			select {
+			case <-changes:
+				r.reconcile()
+			case <-ticker.C:
+				r.reconcile()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// publish hands a feed's ticks to every instance
+func (r *FeedRelay) publish(ticks []Tick) {
+	payload, err := json.Marshal(ticks)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := r.rdb.Publish(ctx, tickChannel, payload).Err(); err != nil {
+		log.Printf("Failed to publish ticks: %v", err)
+	}
+}
+
+func (r *FeedRelay) feedFor(symbol string) Feed {
+	for _, feed := range r.feeds {
+		if feed.Handles(symbol) {
+			return feed
+		}
+	}
+	return nil
+}
+
+// subscribedTo lists the symbols feed resubscribes to after reconnecting
+func (r *FeedRelay) subscribedTo(feed Feed) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var symbols []string
+	for symbol := range r.subscribed {
+		if r.feedFor(symbol) == feed {
+			symbols = append(symbols, symbol)
+		}
+	}
+	return symbols
+}
+
+// wanted is the union of every live instance's symbols
+func (r *FeedRelay) wanted(ctx context.Context) (map[string]bool, error) {
+	var keys []string
+	iter := r.rdb.Scan(ctx, 0, instanceSymbolsPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[string]bool)
+	if len(keys) == 0 {
+		return wanted, nil
+	}
+	symbols, err := r.rdb.SUnion(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, symbol := range symbols {
+		wanted[symbol] = true
+	}
+	return wanted, nil
+}
+
+// reconcile subscribes the feeds to newly wanted symbols and releases the rest
+func (r *FeedRelay) reconcile() {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	wanted, err := r.wanted(ctx)
+	if err != nil {
+		log.Printf("Failed to read price hub subscriptions: %v", err)
+		return
+	}
+
+	r.mu.Lock()
+	add, remove := diffSubscriptions(r.subscribed, wanted)
+	subscribe, unsubscribe := upstreamChanges{}, upstreamChanges{}
+	for _, symbol := range add {
+		if feed := r.feedFor(symbol); feed != nil {
+			r.subscribed[symbol] = true
+			subscribe.add(feed, symbol)
+		}
+	}
+	for _, symbol := range remove {
+		delete(r.subscribed, symbol)
+		unsubscribe.add(r.feedFor(symbol), symbol)
+	}
+	r.mu.Unlock()
+
+	for feed, symbols := range subscribe {
+		feed.Subscribe(symbols...)
+	}
+	for feed, symbols := range unsubscribe {
+		feed.Unsubscribe(symbols...)
+	}
+}
+
+// diffSubscriptions returns the wanted symbols not yet subscribed and the
+// subscribed ones no longer wanted, both sorted
+func diffSubscriptions(subscribed, wanted map[string]bool) (add, remove []string) {
+	for symbol := range wanted {
+		if !subscribed[symbol] {
+			add = append(add, symbol)
+		}
+	}
+	for symbol := range subscribed {
+		if !wanted[symbol] {
+			remove = append(remove, symbol)
+		}
+	}
+	sort.Strings(add)
+	sort.Strings(remove)
+	return add, remove
+}
+
+// Close resigns leadership, disconnecting the feeds if this instance led
+func (r *FeedRelay) Close() {
+	r.closeOnce.Do(func() {
+		close(r.done)
+	})
+	<-r.stopped
+}
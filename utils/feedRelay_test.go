@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDiffSubscriptions(t *testing.T) {
+	fmt.Println("Starting unit tests for feedRelay.go")
+	fmt.Println("Testing diffSubscriptions function")
+
+	subscribed := map[string]bool{"AAPL": true, "MSFT": true}
+	wanted := map[string]bool{"MSFT": true, "NVDA": true, "BINANCE:BTCUSDT": true}
+
+	add, remove := diffSubscriptions(subscribed, wanted)
+	if strings.Join(add, ",") != "BINANCE:BTCUSDT,NVDA" {
+		t.Fatalf("Expected BINANCE:BTCUSDT,NVDA to add, got %v", add)
+	}
+	if strings.Join(remove, ",") != "AAPL" {
+		t.Fatalf("Expected AAPL to remove, got %v", remove)
+	}
+}
+
+func TestFeedRelaySubscribedTo(t *testing.T) {
+	fmt.Println("Testing FeedRelay subscribedTo function")
+
+	crypto := &fakeFeed{prefix: "BINANCE:", subs: make(map[string]int)}
+	stocks := &fakeFeed{prefix: "", subs: make(map[string]int)}
+	r := NewFeedRelay(nil, "test", crypto, stocks)
+	r.subscribed = map[string]bool{"AAPL": true, "BINANCE:BTCUSDT": true}
+
+	if got := r.subscribedTo(crypto); strings.Join(got, ",") != "BINANCE:BTCUSDT" {
+		t.Fatalf("Expected BINANCE:BTCUSDT for crypto, got %v", got)
+	}
+	if got := r.subscribedTo(stocks); strings.Join(got, ",") != "AAPL" {
+		t.Fatalf("Expected AAPL for stocks, got %v", got)
+	}
+}
+
+func TestRedisFeedHandles(t *testing.T) {
+	fmt.Println("Testing RedisFeed Handles function")
+
+	f := NewRedisFeed(nil, "test", &fakeFeed{prefix: "BINANCE:", subs: make(map[string]int)})
+	if !f.Handles("BINANCE:ETHUSDT") {
+		t.Fatalf("Expected BINANCE:ETHUSDT to be handled, got not handled")
+	}
+	if f.Handles("AAPL") {
+		t.Fatalf("Expected AAPL to be unhandled without a stock feed, got handled")
+	}
+	if key := instanceSymbolsKey("test"); key != "price_hub:symbols:test" {
+		t.Fatalf("Expected price_hub:symbols:test, got %s", key)
+	}
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// FinnhubFeed streams stock trades from Finnhub. It takes every symbol no
+// other feed handles.
+type FinnhubFeed struct {
+	upstream
+}
+
+func NewFinnhubFeed(token string) *FinnhubFeed {
+	return &FinnhubFeed{upstream{
+		name: "Finnhub",
+		url:  fmt.Sprintf("wss://ws.finnhub.io?token=%s", token),
+	}}
+}
+
+func (f *FinnhubFeed) Name() string { return "finnhub" }
+
+func (f *FinnhubFeed) Handles(symbol string) bool { return true }
+
+// Subscribe sends one message per symbol, finnhub has no batch form
+func (f *FinnhubFeed) Subscribe(symbols ...string) {
+	for _, symbol := range symbols {
+		f.sendJSON(map[string]string{"type": "subscribe", "symbol": symbol})
+	}
+}
+
+func (f *FinnhubFeed) Unsubscribe(symbols ...string) {
+	for _, symbol := range symbols {
+		f.sendJSON(map[string]string{"type": "unsubscribe", "symbol": symbol})
+	}
+}
+
+func (f *FinnhubFeed) Run(stop <-chan struct{}, emit func([]Tick), current func() []string) {
+	f.run(stop,
+		func() { f.Subscribe(current()...) },
+		func(msg []byte) {
+			if ticks := parseFinnhubTrades(msg); len(ticks) > 0 {
+				emit(ticks)
+			}
+		},
+	)
+}
+
+// parseFinnhubTrades reads a trade batch like
+// {"type":"trade","data":[{"s":"AAPL","p":190.1,"v":10,"t":1700000000000}]}
+// and ignores pings and everything else
+func parseFinnhubTrades(msg []byte) []Tick {
+	var payload struct {
+		Type string `json:"type"`
+		Data []struct {
+			Symbol string  `json:"s"`
+			Price  float64 `json:"p"`
+			Volume float64 `json:"v"`
+			Time   int64   `json:"t"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(msg, &payload); err != nil || payload.Type != "trade" {
+		return nil
+	}
+
+	ticks := make([]Tick, 0, len(payload.Data))
+	for _, t := range payload.Data {
+		if t.Symbol == "" {
+			continue
+		}
+		ticks = append(ticks, Tick{Symbol: t.Symbol, Price: t.Price, Volume: t.Volume, Time: t.Time, Source: "finnhub"})
+	}
+	return ticks
+}
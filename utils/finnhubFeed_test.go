@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseFinnhubTrades(t *testing.T) {
+	fmt.Println("Starting unit tests for finnhubFeed.go")
+	fmt.Println("Testing parseFinnhubTrades function")
+
+	ticks := parseFinnhubTrades([]byte(`{"type":"trade","data":[{"s":"AAPL","p":190.5,"v":10,"t":1700000000000},{"s":"MSFT","p":400,"v":1,"t":1700000000001}]}`))
+	if len(ticks) != 2 {
+		t.Fatalf("Expected 2 ticks, got %d", len(ticks))
+	}
+	if ticks[0].Symbol != "AAPL" || ticks[0].Price != 190.5 || ticks[0].Time != 1700000000000 || ticks[0].Source != "finnhub" {
+		t.Fatalf("Expected an AAPL tick at 190.5, got %+v", ticks[0])
+	}
+
+	if ticks := parseFinnhubTrades([]byte(`{"type":"ping"}`)); len(ticks) != 0 {
+		t.Fatalf("Expected pings to be ignored, got %d ticks", len(ticks))
+	}
+}
@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// holdLeaderLock takes the lock at key for instance, or renews it when
+// already leading
+func holdLeaderLock(rdb *redis.Client, key, instance string, leading bool) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	if !leading {
+		return rdb.SetNX(ctx, key, instance, leaderTTL).Result()
+	}
+	renewed, err := renewLeader.Run(ctx, rdb, []string{key}, instance, leaderTTL.Milliseconds()).Int()
+	return renewed == 1, err
+}
+
+// Leader campaigns for a redis lock so work that must happen once, like the
+// background jobs, runs on a single replica. Another takes over within
+// leaderTTL when the leader goes away.
+type Leader struct {
+	rdb      *redis.Client
+	key      string
+	instance string
+	leading  atomic.Bool
+
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+func NewLeader(rdb *redis.Client, key, instance string) *Leader {
+	return &Leader{
+		rdb:      rdb,
+		key:      key,
+		instance: instance,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// Start campaigns once, so Leading is settled when it returns, then keeps
+// campaigning in the background until Close
+func (l *Leader) Start() {
+	l.campaign()
+	go l.run()
+}
+
+// Leading reports whether this instance held the lock at the last campaign
+func (l *Leader) Leading() bool {
+	return l.leading.Load()
+}
+
+func (l *Leader) campaign() {
+	leading := l.leading.Load()
+	held, err := holdLeaderLock(l.rdb, l.key, l.instance, leading)
+	if err != nil {
+		log.Printf("Leader election for %s failed: %v", l.key, err)
+	}
+	if held != leading {
+		log.Printf("Instance %s leading %s: %v", l.instance, l.key, held)
+	}
+	l.leading.Store(held)
+}
+
+func (l *Leader) run() {
+	defer close(l.stopped)
+	ticker := time.NewTicker(leaderRenewal)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.campaign()
+		case <-l.done:
+			l.leading.Store(false)
+			ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+			releaseLeader.Run(ctx, l.rdb, []string{l.key}, l.instance)
+			cancel()
+			return
+		}
+	}
+}
+
+// Close gives up the lock so another replica can take over straight away
+func (l *Leader) Close() {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	<-l.stopped
+}
@@ -0,0 +1,205 @@
+package utils
+
+import (
+	"math"
+	"time"
+)
+
+// TradingDaysPerYear is used to annualize daily statistics
+const TradingDaysPerYear = 252
+
+// CashFlow is an external flow into (negative) or out of (positive) a portfolio,
+// signed from the investor's point of view as XIRR expects
+type CashFlow struct {
+	Time   time.Time
+	Amount float64
+}
+
+// DailyReturns returns the period returns of a value series, removing external
+// flows that landed on each day. flows may be nil.
+func DailyReturns(values, flows []float64) []float64 {
+	if len(values) < 2 {
+		return nil
+	}
+	returns := make([]float64, 0, len(values)-1)
+	for i := 1; i < len(values); i++ {
+		if values[i-1] == 0 {
+			returns = append(returns, 0)
+			continue
+		}
+		flow := 0.0
+		if flows != nil {
+			flow = flows[i]
+		}
+		returns = append(returns, (values[i]-flow)/values[i-1]-1)
+	}
+	return returns
+}
+
+// TimeWeightedReturn chains period returns into a single return
+func TimeWeightedReturn(returns []float64) float64 {
+	growth := 1.0
+	for _, r := range returns {
+		growth *= 1 + r
+	}
+	return growth - 1
+}
+
+// XIRR solves for the annualized rate that sets the net present value of the
+// flows to zero. ok is false when no rate could be found.
+func XIRR(flows []CashFlow) (float64, bool) {
+	if len(flows) < 2 {
+		return 0, false
+	}
+	start := flows[0].Time
+	npv := func(rate float64) float64 {
+		total := 0.0
+		for _, f := range flows {
+			years := f.Time.Sub(start).Hours() / 24 / 365
+			total += f.Amount / math.Pow(1+rate, years)
+		}
+		return total
+	}
+
+	// bisection is slow but can't diverge like newton on awkward flows
+	lo, hi := -0.9999, 10.0
+	fLo, fHi := npv(lo), npv(hi)
+	if math.IsNaN(fLo) || math.IsNaN(fHi) || fLo*fHi > 0 {
+		return 0, false
+	}
+	for i := 0; i < 200; i++ {
+		mid := (lo + hi) / 2
+		fMid := npv(mid)
+		if math.Abs(fMid) < 1e-9 {
+			return mid, true
+		}
+		if fLo*fMid < 0 {
+			hi = mid
+		} else {
+			lo, fLo = mid, fMid
+		}
+	}
+	return (lo + hi) / 2, true
+}
+
+func mean(xs []float64) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, x := range xs {
+		sum += x
+	}
+	return sum / float64(len(xs))
+}
+
+// StdDev returns the sample standard deviation
+func StdDev(xs []float64) float64 {
+	if len(xs) < 2 {
+		return 0
+	}
+	m := mean(xs)
+	sum := 0.0
+	for _, x := range xs {
+		sum += (x - m) * (x - m)
+	}
+	return math.Sqrt(sum / float64(len(xs)-1))
+}
+
+// AnnualizedVolatility annualizes the standard deviation of daily returns
+func AnnualizedVolatility(returns []float64) float64 {
+	return StdDev(returns) * math.Sqrt(TradingDaysPerYear)
+}
+
+// SharpeRatio returns the annualized Sharpe ratio for an annual risk free rate
+func SharpeRatio(returns []float64, riskFree float64) float64 {
+	sd := StdDev(returns)
+	if sd == 0 {
+		return 0
+	}
+	excess := mean(returns) - riskFree/TradingDaysPerYear
+	return excess / sd * math.Sqrt(TradingDaysPerYear)
+}
+
+// SortinoRatio is like SharpeRatio but only penalizes downside deviation
+func SortinoRatio(returns []float64, riskFree float64) float64 {
+	if len(returns) == 0 {
+		return 0
+	}
+	daily := riskFree / TradingDaysPerYear
+	sum := 0.0
+	for _, r := range returns {
+		if d := r - daily; d < 0 {
+			sum += d * d
+		}
+	}
+	downside := math.Sqrt(sum / float64(len(returns)))
+	if downside == 0 {
+		return 0
+	}
+	return (mean(returns) - daily) / downside * math.Sqrt(TradingDaysPerYear)
+}
+
+// MaxDrawdown returns the largest peak to trough decline of the growth of the
+// returns as a positive fraction
+func MaxDrawdown(returns []float64) float64 {
+	peak, value, worst := 1.0, 1.0, 0.0
+	for _, r := range returns {
+		value *= 1 + r
+		if value > peak {
+			peak = value
+		}
+		if dd := (peak - value) / peak; dd > worst {
+			worst = dd
+		}
+	}
+	return worst
+}
+
+// Beta returns the sensitivity of returns to benchmark returns of the same length
+func Beta(returns, benchmark []float64) float64 {
+	if len(returns) != len(benchmark) || len(returns) < 2 {
+		return 0
+	}
+	mr, mb := mean(returns), mean(benchmark)
+	cov, variance := 0.0, 0.0
+	for i := range returns {
+		cov += (returns[i] - mr) * (benchmark[i] - mb)
+		variance += (benchmark[i] - mb) * (benchmark[i] - mb)
+	}
+	if variance == 0 {
+		return 0
+	}
+	return cov / variance
+}
+
+// Streaks returns the longest winning and losing runs in a sequence of trade
+// results and the current run, positive for wins and negative for losses.
+// Break even trades end both runs.
+func Streaks(results []float64) (longestWin, longestLoss, current int) {
+	for _, r := range results {
+		switch {
+		case r > 0:
+			if current > 0 {
+				current++
+			} else {
+				current = 1
+			}
+		case r < 0:
+			if current < 0 {
+				current--
+			} else {
+				current = -1
+			}
+		default:
+			current = 0
+		}
+		if current > longestWin {
+			longestWin = current
+		}
+		if -current > longestLoss {
+			longestLoss = -current
+		}
+	}
+	return longestWin, longestLoss, current
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestDailyReturns(t *testing.T) {
+	fmt.Println("Starting unit tests for metrics.go")
+	fmt.Println("Testing DailyReturns function")
+
+	values := []float64{100, 110, 99, 200}
+	// a deposit of 100 lands on the last day
+	flows := []float64{0, 0, 0, 100}
+
+	returns := DailyReturns(values, flows)
+	want := []float64{0.1, -0.1, 100.0/99 - 1}
+	if len(returns) != len(want) {
+		t.Fatalf("Expected %d returns, got %d", len(want), len(returns))
+	}
+	for i := range want {
+		if !almostEqual(returns[i], want[i]) {
+			t.Fatalf("Expected return %d to be %v, got %v", i, want[i], returns[i])
+		}
+	}
+}
+
+func TestTimeWeightedReturn(t *testing.T) {
+	fmt.Println("Testing TimeWeightedReturn function")
+
+	got := TimeWeightedReturn([]float64{0.1, -0.1})
+	if !almostEqual(got, -0.01) {
+		t.Fatalf("Expected -0.01, got %v", got)
+	}
+}
+
+func TestXIRR(t *testing.T) {
+	fmt.Println("Testing XIRR function")
+
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	flows := []CashFlow{
+		{Time: start, Amount: -1000},
+		{Time: start.AddDate(0, 0, 365), Amount: 1100},
+	}
+
+	rate, ok := XIRR(flows)
+	if !ok {
+		t.Fatalf("Expected XIRR to converge")
+	}
+	if math.Abs(rate-0.1) > 1e-4 {
+		t.Fatalf("Expected rate of 0.1, got %v", rate)
+	}
+}
+
+func TestMaxDrawdown(t *testing.T) {
+	fmt.Println("Testing MaxDrawdown function")
+
+	// 100 -> 120 -> 60 -> 90
+	got := MaxDrawdown([]float64{0.2, -0.5, 0.5})
+	if !almostEqual(got, 0.5) {
+		t.Fatalf("Expected drawdown of 0.5, got %v", got)
+	}
+}
+
+func TestBeta(t *testing.T) {
+	fmt.Println("Testing Beta function")
+
+	benchmark := []float64{0.01, -0.02, 0.03, 0.005}
+	returns := make([]float64, len(benchmark))
+	for i, r := range benchmark {
+		returns[i] = 2 * r
+	}
+
+	if got := Beta(returns, benchmark); !almostEqual(got, 2) {
+		t.Fatalf("Expected beta of 2, got %v", got)
+	}
+}
+
+func TestStreaks(t *testing.T) {
+	fmt.Println("Testing Streaks function")
+
+	win, loss, current := Streaks([]float64{10, 5, -1, -2, -3, 4, 0, 7, 8})
+	if win != 2 || loss != 3 || current != 2 {
+		t.Fatalf("Expected streaks 2/3/2, got %d/%d/%d", win, loss, current)
+	}
+}
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Page geometry for US letter in landscape, in points
+const (
+	pdfPageWidth  = 792
+	pdfPageHeight = 612
+	pdfMargin     = 36
+	pdfFontSize   = 8
+	pdfLeading    = 10
+)
+
+// TextPDF builds a PDF of monospaced text lines, which is all tabular reports
+// need and avoids pulling in a PDF library
+type TextPDF struct {
+	pages [][]string
+}
+
+func NewTextPDF() *TextPDF {
+	return &TextPDF{}
+}
+
+// linesPerPage is how many lines fit between the top and bottom margins
+func linesPerPage() int {
+	return (pdfPageHeight - 2*pdfMargin) / pdfLeading
+}
+
+// AddLines appends lines, starting new pages as they fill up
+func (p *TextPDF) AddLines(lines ...string) {
+	for _, line := range lines {
+		if len(p.pages) == 0 || len(p.pages[len(p.pages)-1]) >= linesPerPage() {
+			p.pages = append(p.pages, nil)
+		}
+		last := len(p.pages) - 1
+		p.pages[last] = append(p.pages[last], line)
+	}
+}
+
+// escapePDFText escapes a string for use inside a PDF literal string.
+// Characters outside printable ASCII are replaced since only the standard
+// Courier encoding is available.
+func escapePDFText(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 32 || r > 126:
+			b.WriteByte('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Bytes renders the document
+func (p *TextPDF) Bytes() []byte {
+	pages := p.pages
+	if len(pages) == 0 {
+		pages = [][]string{nil}
+	}
+
+	var buf bytes.Buffer
+	offsets := []int{}
+	writeObj := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n")
+
+	// objects 1-3 are the catalog, page tree and font, then a page and its
+	// content stream for every page
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 4+2*i)
+	}
+	writeObj("<< /Type /Catalog /Pages 2 0 R >>")
+	writeObj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	writeObj("<< /Type /Font /Subtype /Type1 /BaseFont /Courier >>")
+
+	for i, lines := range pages {
+		writeObj(fmt.Sprintf(
+			"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, 5+2*i,
+		))
+
+		var content strings.Builder
+		fmt.Fprintf(&content, "BT\n/F1 %d Tf\n%d TL\n%d %d Td\n", pdfFontSize, pdfLeading, pdfMargin, pdfPageHeight-pdfMargin)
+		for _, line := range lines {
+			fmt.Fprintf(&content, "(%s) Tj T*\n", escapePDFText(line))
+		}
+		content.WriteString("ET")
+		writeObj(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return buf.Bytes()
+}
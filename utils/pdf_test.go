@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestTextPDF(t *testing.T) {
+	fmt.Println("Starting unit tests for pdf.go")
+	fmt.Println("Testing TextPDF")
+
+	doc := NewTextPDF()
+	for i := 0; i < linesPerPage()+1; i++ {
+		doc.AddLines(fmt.Sprintf("line %d (escaped)", i))
+	}
+
+	out := doc.Bytes()
+
+	if !bytes.HasPrefix(out, []byte("%PDF-1.4")) {
+		t.Fatalf("Expected output to start with the PDF header")
+	}
+	if !bytes.HasSuffix(out, []byte("%%EOF\n")) {
+		t.Fatalf("Expected output to end with the EOF marker")
+	}
+	if !bytes.Contains(out, []byte("/Count 2")) {
+		t.Fatalf("Expected lines to spill onto a second page")
+	}
+	if !bytes.Contains(out, []byte(`line 0 \(escaped\)`)) {
+		t.Fatalf("Expected parentheses to be escaped")
+	}
+}
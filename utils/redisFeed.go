@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// the leader publishes every tick batch here
+	tickChannel = "price_hub:ticks"
+	// instances announce subscription changes here so the leader reconciles straight away
+	controlChannel = "price_hub:control"
+	// each instance keeps the symbols its clients watch in a set under this prefix
+	instanceSymbolsPrefix = "price_hub:symbols:"
+	// an instance that stops refreshing its set is forgotten after this
+	instanceSymbolsTTL = time.Minute
+	redisTimeout       = 5 * time.Second
+)
+
+// InstanceID names this process among the replicas sharing redis
+func InstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano())
+}
+
+func instanceSymbolsKey(instance string) string {
+	return instanceSymbolsPrefix + instance
+}
+
+// RedisFeed is a hub's feed when replicas share one set of upstream
+// connections. It records what this instance's clients watch in redis and
+// emits the ticks the elected FeedRelay republishes.
+type RedisFeed struct {
+	rdb      *redis.Client
+	instance string
+	// feeds are the upstreams the relay runs, used to tell which symbols stream
+	feeds []Feed
+}
+
+func NewRedisFeed(rdb *redis.Client, instance string, feeds ...Feed) *RedisFeed {
+	return &RedisFeed{rdb: rdb, instance: instance, feeds: feeds}
+}
+
+func (f *RedisFeed) Name() string { return "redis" }
+
+func (f *RedisFeed) Handles(symbol string) bool {
+	for _, feed := range f.feeds {
+		if feed.Handles(symbol) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *RedisFeed) Subscribe(symbols ...string) {
+	f.change(symbols, func(ctx context.Context, pipe redis.Pipeliner, key string, members []any) {
+		pipe.SAdd(ctx, key, members...)
+		pipe.Expire(ctx, key, instanceSymbolsTTL)
+	})
+}
+
+func (f *RedisFeed) Unsubscribe(symbols ...string) {
+	f.change(symbols, func(ctx context.Context, pipe redis.Pipeliner, key string, members []any) {
+		pipe.SRem(ctx, key, members...)
+	})
+}
+
+// change updates this instance's symbol set and tells the leader
+func (f *RedisFeed) change(symbols []string, update func(context.Context, redis.Pipeliner, string, []any)) {
+	if len(symbols) == 0 {
+		return
+	}
+	members := make([]any, len(symbols))
+	for i, symbol := range symbols {
+		members[i] = symbol
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	_, err := f.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		update(ctx, pipe, instanceSymbolsKey(f.instance), members)
+		pipe.Publish(ctx, controlChannel, f.instance)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to update price hub subscriptions: %v", err)
+	}
+}
+
+// refresh adds symbols to this instance's set and renews its expiry. It never
+// removes, a Subscribe racing the snapshot of symbols would be lost, so only
+// Unsubscribe takes symbols out.
+func (f *RedisFeed) refresh(symbols []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	key := instanceSymbolsKey(f.instance)
+	_, err := f.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if len(symbols) > 0 {
+			members := make([]any, len(symbols))
+			for i, symbol := range symbols {
+				members[i] = symbol
+			}
+			pipe.SAdd(ctx, key, members...)
+		}
+		pipe.Expire(ctx, key, instanceSymbolsTTL)
+		pipe.Publish(ctx, controlChannel, f.instance)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to refresh price hub subscriptions: %v", err)
+	}
+}
+
+// Run emits republished ticks until stop is closed. The symbol set is
+// refreshed well within its expiry so a crashed instance's symbols are
+// released but a live one's never lapse.
+func (f *RedisFeed) Run(stop <-chan struct{}, emit func([]Tick), current func() []string) {
+	// go-redis resubscribes by itself after a dropped connection
+	pubsub := f.rdb.Subscribe(context.Background(), tickChannel)
+	defer pubsub.Close()
+	messages := pubsub.Channel()
+
+	f.refresh(current())
+	refresh := time.NewTicker(instanceSymbolsTTL / 3)
+	defer refresh.Stop()
+
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			var ticks []Tick
+			if err := json.Unmarshal([]byte(msg.Payload), &ticks); err != nil {
+				continue
+			}
+			emit(ticks)
+		case <-refresh.C:
+			f.refresh(current())
+		case <-stop:
+			ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+			f.rdb.Del(ctx, instanceSymbolsKey(f.instance))
+			cancel()
+			return
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"sort"
+	"sync"
+)
+
+// Watcher follows live prices inside the server instead of over a websocket.
+// It shares the hub's upstream subscriptions with websocket clients and only
+// keeps the newest tick per symbol until it's taken.
+type Watcher struct {
+	hub    *Hub
+	client *Client
+
+	mu      sync.Mutex
+	pending map[string]Tick
+	changed chan struct{}
+}
+
+// Watch registers a watcher with no symbols, false once the hub is closed
+func (h *Hub) Watch() (*Watcher, bool) {
+	w := &Watcher{
+		hub:     h,
+		pending: make(map[string]Tick),
+		changed: make(chan struct{}, 1),
+	}
+	w.client = NewClient(nil)
+	w.client.watcher = w
+	if !h.Register(w.client) {
+		return nil, false
+	}
+	return w, true
+}
+
+// Set changes the watched symbols to exactly symbols and returns the ones
+// being streamed. Symbols no feed handles or past maxSymbolsPerClient are left out.
+func (w *Watcher) Set(symbols []string) []string {
+	keep := make(map[string]bool, len(symbols))
+	for _, symbol := range symbols {
+		keep[symbol] = true
+	}
+
+	current := w.hub.Subscribe(w.client, symbols)
+	var drop []string
+	for _, symbol := range current {
+		if !keep[symbol] {
+			drop = append(drop, symbol)
+		}
+	}
+	if len(drop) > 0 {
+		current = w.hub.Unsubscribe(w.client, drop)
+	}
+	sort.Strings(current)
+	return current
+}
+
+// Changed is signalled when a watched symbol ticks
+func (w *Watcher) Changed() <-chan struct{} { return w.changed }
+
+// Done is closed when the hub shuts down
+func (w *Watcher) Done() <-chan struct{} { return w.hub.done }
+
+// Take returns the latest tick of every symbol that traded since the last call
+func (w *Watcher) Take() map[string]Tick {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ticks := w.pending
+	w.pending = make(map[string]Tick)
+	return ticks
+}
+
+// Close stops watching and releases the watcher's upstream subscriptions
+func (w *Watcher) Close() {
+	w.hub.Unregister(w.client)
+}
+
+// update is called by the hub with h.mu held, so it must not block
+func (w *Watcher) update(ticks []Tick) {
+	w.mu.Lock()
+	for _, t := range ticks {
+		if last, ok := w.pending[t.Symbol]; !ok || t.Time >= last.Time {
+			w.pending[t.Symbol] = t
+		}
+	}
+	w.mu.Unlock()
+
+	select {
+	case w.changed <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatcherSet(t *testing.T) {
+	fmt.Println("Starting unit tests for watcher.go")
+	fmt.Println("Testing Watcher Set function")
+
+	h := newOfflineHub()
+	w, ok := h.Watch()
+	if !ok {
+		t.Fatalf("Expected watcher to register, got rejected")
+	}
+
+	if got := w.Set([]string{"MSFT", "AAPL"}); strings.Join(got, ",") != "AAPL,MSFT" {
+		t.Fatalf("Expected AAPL,MSFT, got %v", got)
+	}
+	// symbols missing from the new set are dropped
+	if got := w.Set([]string{"AAPL", "NVDA"}); strings.Join(got, ",") != "AAPL,NVDA" {
+		t.Fatalf("Expected AAPL,NVDA, got %v", got)
+	}
+	if n := h.waitForWatchers("MSFT", 0); n != 0 {
+		t.Fatalf("Expected MSFT released, got %d watchers", n)
+	}
+
+	w.Close()
+	if n := h.waitForWatchers("AAPL", 0); n != 0 {
+		t.Fatalf("Expected AAPL released after close, got %d watchers", n)
+	}
+}
+
+func TestWatcherTicks(t *testing.T) {
+	fmt.Println("Testing Watcher Take function")
+
+	h := newOfflineHub()
+	w, _ := h.Watch()
+	w.Set([]string{"AAPL"})
+
+	h.broadcast([]Tick{
+		{Symbol: "AAPL", Price: 190, Time: 2},
+		{Symbol: "AAPL", Price: 189, Time: 1},
+		{Symbol: "MSFT", Price: 400, Time: 3},
+	})
+
+	select {
+	case <-w.Changed():
+	case <-time.After(time.Second):
+		t.Fatalf("Expected a change signal, got none")
+	}
+	ticks := w.Take()
+	if len(ticks) != 1 || ticks["AAPL"].Price != 190 {
+		t.Fatalf("Expected only the newest AAPL tick at 190, got %v", ticks)
+	}
+	if ticks := w.Take(); len(ticks) != 0 {
+		t.Fatalf("Expected nothing pending after take, got %v", ticks)
+	}
+
+	// closing the hub skips in-process clients that have no connection
+	h.Close()
+	select {
+	case <-w.Done():
+	default:
+		t.Fatalf("Expected watcher done after hub close, got still open")
+	}
+}
@@ -4,52 +4,277 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gofiber/websocket/v2"
-	gws "github.com/gorilla/websocket"
+)
+
+const (
+	// time allowed to write a message to a client
+	writeWait = 10 * time.Second
+	// clients must answer a ping within pongWait
+	pongWait   = 60 * time.Second
+	pingPeriod = pongWait * 9 / 10
+	// finnhub pings every few seconds, silence this long means the link is dead
+	upstreamReadWait = 90 * time.Second
+
+	// finnhub's free tier streams 50 symbols per connection
+	maxSymbolsPerClient = 50
+	maxSymbolLength     = 32
+
+	// trades waiting for a client beyond this are coalesced to the latest per symbol
+	clientQueueSize = 64
+	// a client whose queue stays full this long is disconnected
+	slowClientLimit = 30 * time.Second
+
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+	// a connection that lasted this long resets the backoff
+	stableConnection = time.Minute
 )
 
 type Client struct {
-	Conn   *websocket.Conn
-	Symbol string
+	Conn *websocket.Conn
+	// symbols the client streams, guarded by the hub's mu
+	symbols map[string]bool
+
+	// the hub queues trades here and writePump sends them, so a slow client
+	// only holds up itself
+	queue chan []byte
+	// latest has the newest trades per symbol that didn't fit in queue
+	latest    map[string][]byte
+	order     []string
+	fullSince time.Time
+	queueMu   sync.Mutex
+	wake      chan struct{}
+
+	kicked   chan struct{}
+	kickOnce sync.Once
+
+	// the pump and control replies write from different goroutines
+	writeMu sync.Mutex
+
+	// watcher is set for in-process clients, which get ticks instead of messages
+	watcher *Watcher
+}
+
+func NewClient(conn *websocket.Conn) *Client {
+	return &Client{
+		Conn:    conn,
+		symbols: make(map[string]bool),
+		queue:   make(chan []byte, clientQueueSize),
+		latest:  make(map[string][]byte),
+		wake:    make(chan struct{}, 1),
+		kicked:  make(chan struct{}),
+	}
 }
 
+// enqueue hands a trade message for symbol to the client without blocking.
+// When the queue is full only the newest message per symbol is kept, and a
+// client that stays full for slowClientLimit is disconnected.
+func (c *Client) enqueue(symbol string, msg []byte) {
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+
+	if len(c.latest) == 0 {
+		select {
+		case c.queue <- msg:
+			c.fullSince = time.Time{}
+			return
+		default:
+		}
+	}
+
+	if c.fullSince.IsZero() {
+		c.fullSince = time.Now()
+	} else if time.Since(c.fullSince) > slowClientLimit {
+		log.Printf("Disconnecting slow websocket client after %s behind", time.Since(c.fullSince).Round(time.Second))
+		c.kick()
+		return
+	}
+
+	if _, ok := c.latest[symbol]; !ok {
+		c.order = append(c.order, symbol)
+	}
+	c.latest[symbol] = msg
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// takeLatest returns the coalesced messages once the queue has drained, oldest symbol first
+func (c *Client) takeLatest() [][]byte {
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+
+	if len(c.queue) > 0 || len(c.latest) == 0 {
+		return nil
+	}
+	msgs := make([][]byte, 0, len(c.order))
+	for _, symbol := range c.order {
+		msgs = append(msgs, c.latest[symbol])
+	}
+	c.latest = make(map[string][]byte)
+	c.order = nil
+	c.fullSince = time.Time{}
+	return msgs
+}
+
+// kick disconnects the client, its read loop then unregisters it
+func (c *Client) kick() {
+	c.kickOnce.Do(func() {
+		close(c.kicked)
+		if c.Conn != nil {
+			c.Conn.Close()
+		}
+	})
+}
+
+// write sends one message, giving up after writeWait so a stuck connection
+// is noticed
+func (c *Client) write(messageType int, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.Conn.WriteMessage(messageType, data)
+}
+
+// writePump sends queued trades and pings the client until stop is closed. A
+// client that stops answering pings hits its read deadline and is dropped.
+func (c *Client) writePump(stop <-chan struct{}) {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	send := func(msg []byte) bool {
+		if err := c.write(websocket.TextMessage, msg); err != nil {
+			c.kick()
+			return false
+		}
+		return true
+	}
+
+	for {
+		select {
+		case msg := <-c.queue:
+			if !send(msg) {
+				return
+			}
+			if len(c.queue) == 0 {
+				for _, msg := range c.takeLatest() {
+					if !send(msg) {
+						return
+					}
+				}
+			}
+		case <-c.wake:
+			for _, msg := range c.takeLatest() {
+				if !send(msg) {
+					return
+				}
+			}
+		case <-ticker.C:
+			if err := c.write(websocket.PingMessage, nil); err != nil {
+				c.kick()
+				return
+			}
+		case <-c.kicked:
+			return
+		case <-stop:
+			return
+		}
+	}
+}
+
+// subscription adds or removes symbols for a client. The client's symbols
+// after the change are sent on result.
+type subscription struct {
+	client  *Client
+	symbols []string
+	add     bool
+	result  chan []string
+}
+
+// Hub fans live trades from its feeds out to websocket clients. Each symbol
+// streams from the first feed that handles it. bySymbol doubles as the
+// reference count of upstream subscriptions: a symbol is subscribed while any
+// client watches it.
 type Hub struct {
-	finnhub *gws.Conn
+	feeds []Feed
 
 	clients  map[*Client]bool
 	bySymbol map[string]map[*Client]bool
 
-	register   chan *Client
-	unregister chan *Client
-	broadcast  chan []byte
+	register      chan *Client
+	unregister    chan *Client
+	subscriptions chan subscription
+
+	// taps see every tick batch, e.g. to build candles
+	taps []func([]Tick)
 
 	mu sync.Mutex
-}
 
-func NewHub(token string) (*Hub, error) {
-	url := fmt.Sprintf("wss://ws.finnhub.io?token=%s", token)
+	done      chan struct{}
+	closeOnce sync.Once
+}
 
-	fh, _, err := gws.DefaultDialer.Dial(url, nil)
-	if err != nil {
-		return nil, err
+func NewHub(feeds ...Feed) *Hub {
+	h := newHub(feeds)
+	for _, feed := range feeds {
+		go feed.Run(h.done, h.broadcast, func() []string { return h.symbolsFor(feed) })
 	}
+	return h
+}
 
+// newHub is a hub that isn't connected to its feeds yet
+func newHub(feeds []Feed) *Hub {
 	h := &Hub{
-		finnhub:    fh,
-		clients:    make(map[*Client]bool),
-		bySymbol:   make(map[string]map[*Client]bool),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		broadcast:  make(chan []byte),
+		feeds:         feeds,
+		clients:       make(map[*Client]bool),
+		bySymbol:      make(map[string]map[*Client]bool),
+		register:      make(chan *Client),
+		unregister:    make(chan *Client),
+		subscriptions: make(chan subscription),
+		done:          make(chan struct{}),
 	}
-
 	go h.run()
-	go h.readFinnhub()
+	return h
+}
+
+// feedFor is the feed a symbol streams from, nil if none handles it
+func (h *Hub) feedFor(symbol string) Feed {
+	for _, feed := range h.feeds {
+		if feed.Handles(symbol) {
+			return feed
+		}
+	}
+	return nil
+}
 
-	return h, nil
+// symbolsFor lists the watched symbols streaming from feed
+func (h *Hub) symbolsFor(feed Feed) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var symbols []string
+	for symbol := range h.bySymbol {
+		if h.feedFor(symbol) == feed {
+			symbols = append(symbols, symbol)
+		}
+	}
+	return symbols
+}
+
+// upstreamChanges batches subscribe and unsubscribe requests per feed
+type upstreamChanges map[Feed][]string
+
+func (u upstreamChanges) add(feed Feed, symbol string) {
+	if feed != nil {
+		u[feed] = append(u[feed], symbol)
+	}
 }
 
 func (h *Hub) run() {
@@ -59,85 +284,329 @@ func (h *Hub) run() {
 		case client := <-h.register:
 			h.mu.Lock()
 			h.clients[client] = true
-
-			if h.bySymbol[client.Symbol] == nil {
-				h.bySymbol[client.Symbol] = make(map[*Client]bool)
-				h.subscribe(client.Symbol)
-			}
-			h.bySymbol[client.Symbol][client] = true
 			h.mu.Unlock()
 
 		case client := <-h.unregister:
+			released := upstreamChanges{}
 			h.mu.Lock()
 			delete(h.clients, client)
-			delete(h.bySymbol[client.Symbol], client)
+			for symbol := range client.symbols {
+				if h.unwatch(client, symbol) {
+					released.add(h.feedFor(symbol), symbol)
+				}
+			}
+			h.mu.Unlock()
+			for feed, symbols := range released {
+				feed.Unsubscribe(symbols...)
+			}
 
-			if len(h.bySymbol[client.Symbol]) == 0 {
-				h.unsubscribe(client.Symbol)
-				delete(h.bySymbol, client.Symbol)
+		case sub := <-h.subscriptions:
+			changed := upstreamChanges{}
+			h.mu.Lock()
+			for _, symbol := range sub.symbols {
+				if !sub.add {
+					if h.unwatch(sub.client, symbol) {
+						changed.add(h.feedFor(symbol), symbol)
+					}
+				} else if len(sub.client.symbols) < maxSymbolsPerClient && h.feedFor(symbol) != nil {
+					if h.watch(sub.client, symbol) {
+						changed.add(h.feedFor(symbol), symbol)
+					}
+				}
+			}
+			current := make([]string, 0, len(sub.client.symbols))
+			for symbol := range sub.client.symbols {
+				current = append(current, symbol)
 			}
 			h.mu.Unlock()
+			for feed, symbols := range changed {
+				if sub.add {
+					feed.Subscribe(symbols...)
+				} else {
+					feed.Unsubscribe(symbols...)
+				}
+			}
+			sort.Strings(current)
+			sub.result <- current
+
+		case <-h.done:
+			return
 		}
 	}
 }
 
-func (h *Hub) readFinnhub() {
-	for {
-		_, msg, err := h.finnhub.ReadMessage()
-		if err != nil {
-			log.Println("Finnhub read error:", err)
-			return
-		}
+// watch adds a client to a symbol and reports whether it's the symbol's first
+// watcher, which needs subscribing upstream. h.mu must be held.
+func (h *Hub) watch(client *Client, symbol string) bool {
+	if client.symbols[symbol] {
+		return false
+	}
+	client.symbols[symbol] = true
+	first := h.bySymbol[symbol] == nil
+	if first {
+		h.bySymbol[symbol] = make(map[*Client]bool)
+	}
+	h.bySymbol[symbol][client] = true
+	return first
+}
+
+// unwatch removes a client from a symbol and reports whether that was the
+// last watcher, which releases the upstream subscription. h.mu must be held.
+func (h *Hub) unwatch(client *Client, symbol string) bool {
+	if !client.symbols[symbol] {
+		return false
+	}
+	delete(client.symbols, symbol)
+	delete(h.bySymbol[symbol], client)
+	if len(h.bySymbol[symbol]) == 0 {
+		delete(h.bySymbol, symbol)
+		return true
+	}
+	return false
+}
+
+// Register adds a client, false once the hub is closed
+func (h *Hub) Register(client *Client) bool {
+	select {
+	case h.register <- client:
+		return true
+	case <-h.done:
+		return false
+	}
+}
 
-		var payload struct {
-			Data []struct {
-				Symbol string `json:"s"`
-			} `json:"data"`
+// Unregister removes a client, a no-op once the hub is closed
+func (h *Hub) Unregister(client *Client) {
+	select {
+	case h.unregister <- client:
+	case <-h.done:
+	}
+}
+
+// Subscribe starts streaming symbols to the client, up to maxSymbolsPerClient,
+// and returns everything it's now subscribed to. Symbols no feed handles are
+// skipped.
+func (h *Hub) Subscribe(client *Client, symbols []string) []string {
+	return h.changeSubscription(client, symbols, true)
+}
+
+// Unsubscribe stops streaming symbols to the client and returns what's left
+func (h *Hub) Unsubscribe(client *Client, symbols []string) []string {
+	return h.changeSubscription(client, symbols, false)
+}
+
+func (h *Hub) changeSubscription(client *Client, symbols []string, add bool) []string {
+	sub := subscription{client: client, symbols: symbols, add: add, result: make(chan []string, 1)}
+	select {
+	case h.subscriptions <- sub:
+		return <-sub.result
+	case <-h.done:
+		return nil
+	}
+}
+
+// NormalizeSymbol upper cases a symbol and checks it looks like a ticker,
+// e.g. AAPL, BRK.B or BINANCE:BTCUSDT
+func NormalizeSymbol(raw string) (string, bool) {
+	symbol := strings.ToUpper(strings.TrimSpace(raw))
+	if symbol == "" || len(symbol) > maxSymbolLength {
+		return symbol, false
+	}
+	for _, r := range symbol {
+		if !(r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune(".:-_/^", r)) {
+			return symbol, false
 		}
+	}
+	return symbol, true
+}
 
-		if err := json.Unmarshal(msg, &payload); err != nil || len(payload.Data) == 0 {
+// broadcast queues ticks for the clients watching each symbol. A feed's batch
+// can mix symbols, each client only gets the ones it watches.
+func (h *Hub) broadcast(ticks []Tick) {
+	bySymbol := make(map[string][]Tick)
+	for _, t := range ticks {
+		bySymbol[t.Symbol] = append(bySymbol[t.Symbol], t)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, tap := range h.taps {
+		tap(ticks)
+	}
+	for symbol, batch := range bySymbol {
+		if len(h.bySymbol[symbol]) == 0 {
 			continue
 		}
+		out, err := marshalTicks(symbol, batch)
+		for client := range h.bySymbol[symbol] {
+			if client.watcher != nil {
+				client.watcher.update(batch)
+			} else if err == nil {
+				client.enqueue(symbol, out)
+			}
+		}
+	}
+}
 
-		symbol := payload.Data[0].Symbol
+// tap calls fn with every tick batch. fn runs with h.mu held and must not block.
+func (h *Hub) tap(fn func([]Tick)) {
+	h.mu.Lock()
+	h.taps = append(h.taps, fn)
+	h.mu.Unlock()
+}
+
+// pushBar queues an in-progress bar for the clients watching its symbol. Bars
+// coalesce per interval, separately from trades.
+func (h *Hub) pushBar(bar Bar) {
+	out, err := marshalBar(bar)
+	if err != nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for client := range h.bySymbol[bar.Symbol] {
+		if client.watcher == nil {
+			client.enqueue(barKey(bar.Symbol, bar.Interval), out)
+		}
+	}
+}
+
+// Close disconnects the feeds and tells every client the server is going away
+func (h *Hub) Close() {
+	h.closeOnce.Do(func() {
+		// feeds close their connections when done closes
+		close(h.done)
 
 		h.mu.Lock()
-		for client := range h.bySymbol[symbol] {
-			client.Conn.WriteMessage(websocket.TextMessage, msg)
+		defer h.mu.Unlock()
+		for client := range h.clients {
+			if client.Conn == nil {
+				continue
+			}
+			client.writeMu.Lock()
+			client.Conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(writeWait))
+			client.writeMu.Unlock()
+			client.Conn.Close()
 		}
-		h.mu.Unlock()
+	})
+}
+
+// controlMessage is what clients send to change their subscriptions, e.g.
+// {"type":"subscribe","symbols":["AAPL","MSFT"]} or {"type":"unsubscribe","symbol":"AAPL"}
+type controlMessage struct {
+	Type    string   `json:"type"`
+	Symbol  string   `json:"symbol"`
+	Symbols []string `json:"symbols"`
+}
+
+func (c *Client) sendJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
 	}
+	return c.write(websocket.TextMessage, data)
 }
 
-func (h *Hub) subscribe(symbol string) {
-	msg := fmt.Sprintf(`{"type":"subscribe","symbol":"%s"}`, symbol)
-	h.finnhub.WriteMessage(gws.TextMessage, []byte(msg))
+func (c *Client) sendError(message string) error {
+	return c.sendJSON(map[string]any{"type": "error", "message": message})
 }
 
-func (h *Hub) unsubscribe(symbol string) {
-	msg := fmt.Sprintf(`{"type":"unsubscribe","symbol":"%s"}`, symbol)
-	h.finnhub.WriteMessage(gws.TextMessage, []byte(msg))
+// handleControl applies one subscribe or unsubscribe message and replies
+// with the client's subscriptions
+func handleControl(hub *Hub, client *Client, raw []byte) error {
+	var msg controlMessage
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return client.sendError("invalid message")
+	}
+
+	requested := msg.Symbols
+	if msg.Symbol != "" {
+		requested = append(requested, msg.Symbol)
+	}
+	symbols := make([]string, 0, len(requested))
+	for _, raw := range requested {
+		symbol, ok := NormalizeSymbol(raw)
+		if !ok {
+			return client.sendError(fmt.Sprintf("invalid symbol %q", raw))
+		}
+		symbols = append(symbols, symbol)
+	}
+	if len(symbols) == 0 {
+		return client.sendError("no symbols given")
+	}
+
+	var current []string
+	switch msg.Type {
+	case "subscribe":
+		current = hub.Subscribe(client, symbols)
+		subscribed := make(map[string]bool, len(current))
+		for _, symbol := range current {
+			subscribed[symbol] = true
+		}
+		for _, symbol := range symbols {
+			if subscribed[symbol] {
+				continue
+			}
+			if hub.feedFor(symbol) == nil {
+				client.sendError(fmt.Sprintf("%s isn't available for streaming", symbol))
+			} else {
+				client.sendError(fmt.Sprintf("subscriptions are limited to %d symbols", maxSymbolsPerClient))
+				break
+			}
+		}
+	case "unsubscribe":
+		current = hub.Unsubscribe(client, symbols)
+	default:
+		return client.sendError(fmt.Sprintf("unknown message type %q", msg.Type))
+	}
+	return client.sendJSON(map[string]any{"type": "subscriptions", "symbols": current})
 }
 
+// StockWSHandler streams trades for any number of symbols over one connection.
+// A :symbol in the path is subscribed straight away, more are added with
+// subscribe and unsubscribe messages. In-progress candles for the watched
+// symbols arrive as candle messages alongside the trades.
 func StockWSHandler(hub *Hub) func(*websocket.Conn) {
 	return func(c *websocket.Conn) {
-		symbol := c.Params("symbol")
+		client := NewClient(c)
 
-		client := &Client{
-			Conn:   c,
-			Symbol: strings.ToUpper(symbol),
+		if !hub.Register(client) {
+			c.Close()
+			return
 		}
 
-		hub.register <- client
-
 		defer func() {
-			hub.unregister <- client
+			hub.Unregister(client)
 			c.Close()
 		}()
 
-		// Keep connection alive
+		c.SetReadDeadline(time.Now().Add(pongWait))
+		c.SetPongHandler(func(string) error {
+			return c.SetReadDeadline(time.Now().Add(pongWait))
+		})
+
+		stop := make(chan struct{})
+		defer close(stop)
+		go client.writePump(stop)
+
+		if symbol := c.Params("symbol"); symbol != "" {
+			if err := handleControl(hub, client, []byte(fmt.Sprintf(`{"type":"subscribe","symbol":%q}`, symbol))); err != nil {
+				return
+			}
+		}
+
+		// Read control messages until the client goes away or stops answering pings
 		for {
-			if _, _, err := c.ReadMessage(); err != nil {
+			messageType, msg, err := c.ReadMessage()
+			if err != nil {
+				break
+			}
+			if messageType != websocket.TextMessage {
+				continue
+			}
+			if err := handleControl(hub, client, msg); err != nil {
 				break
 			}
 		}
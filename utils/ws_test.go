@@ -0,0 +1,258 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeFeed records upstream subscriptions instead of connecting anywhere
+type fakeFeed struct {
+	prefix string
+	mu     sync.Mutex
+	subs   map[string]int
+}
+
+func (f *fakeFeed) Name() string { return "fake" }
+
+func (f *fakeFeed) Handles(symbol string) bool { return strings.HasPrefix(symbol, f.prefix) }
+
+func (f *fakeFeed) Subscribe(symbols ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, s := range symbols {
+		f.subs[s]++
+	}
+}
+
+func (f *fakeFeed) Unsubscribe(symbols ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, s := range symbols {
+		f.subs[s]--
+	}
+}
+
+func (f *fakeFeed) Run(stop <-chan struct{}, emit func([]Tick), current func() []string) {}
+
+func (f *fakeFeed) subscribed(symbol string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.subs[symbol]
+}
+
+// newOfflineHub is a hub whose feeds never connect, streaming everything
+// from one fake feed
+func newOfflineHub() *Hub {
+	return newHub([]Feed{&fakeFeed{subs: make(map[string]int)}})
+}
+
+// waitForWatchers waits for run to catch up and returns the symbol's client count
+func (h *Hub) waitForWatchers(symbol string, want int) int {
+	deadline := time.Now().Add(time.Second)
+	for {
+		h.mu.Lock()
+		got := len(h.bySymbol[symbol])
+		h.mu.Unlock()
+		if got == want || time.Now().After(deadline) {
+			return got
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHubSubscribe(t *testing.T) {
+	fmt.Println("Starting unit tests for ws.go")
+	fmt.Println("Testing Hub Subscribe and Unsubscribe functions")
+
+	h := newOfflineHub()
+	a := NewClient(nil)
+	b := NewClient(nil)
+	if !h.Register(a) || !h.Register(b) {
+		t.Fatalf("Expected clients to register, got rejected")
+	}
+
+	got := h.Subscribe(a, []string{"MSFT", "AAPL"})
+	if strings.Join(got, ",") != "AAPL,MSFT" {
+		t.Fatalf("Expected AAPL,MSFT, got %v", got)
+	}
+	h.Subscribe(b, []string{"AAPL"})
+	if n := h.waitForWatchers("AAPL", 2); n != 2 {
+		t.Fatalf("Expected 2 AAPL watchers, got %d", n)
+	}
+
+	got = h.Unsubscribe(a, []string{"AAPL"})
+	if strings.Join(got, ",") != "MSFT" {
+		t.Fatalf("Expected MSFT left, got %v", got)
+	}
+	if n := h.waitForWatchers("AAPL", 1); n != 1 {
+		t.Fatalf("Expected 1 AAPL watcher, got %d", n)
+	}
+
+	// unregistering drops every symbol, the last watcher releases it upstream
+	h.Unregister(a)
+	h.Unregister(b)
+	h.waitForWatchers("AAPL", 0)
+	h.mu.Lock()
+	remaining := len(h.bySymbol)
+	h.mu.Unlock()
+	if remaining != 0 {
+		t.Fatalf("Expected no symbols subscribed, got %d", remaining)
+	}
+}
+
+func TestHubSubscribeLimit(t *testing.T) {
+	fmt.Println("Testing Hub Subscribe symbol limit")
+
+	h := newOfflineHub()
+	client := NewClient(nil)
+	h.Register(client)
+
+	symbols := make([]string, maxSymbolsPerClient+5)
+	for i := range symbols {
+		symbols[i] = fmt.Sprintf("S%d", i)
+	}
+	if got := h.Subscribe(client, symbols); len(got) != maxSymbolsPerClient {
+		t.Fatalf("Expected %d symbols, got %d", maxSymbolsPerClient, len(got))
+	}
+}
+
+func TestHubClose(t *testing.T) {
+	fmt.Println("Testing Hub Close function")
+
+	h := newOfflineHub()
+	h.Close()
+	h.Close()
+
+	if h.Register(NewClient(nil)) {
+		t.Fatalf("Expected register to fail after close, got registered")
+	}
+	// must not block
+	h.Unregister(NewClient(nil))
+	if got := h.Subscribe(NewClient(nil), []string{"AAPL"}); got != nil {
+		t.Fatalf("Expected no subscriptions after close, got %v", got)
+	}
+}
+
+func TestNormalizeSymbol(t *testing.T) {
+	fmt.Println("Testing NormalizeSymbol function")
+
+	cases := map[string]bool{
+		" aapl ":          true,
+		"BRK.B":           true,
+		"binance:btcusdt": true,
+		"":                false,
+		"AA PL":           false,
+		`A"}`:             false,
+	}
+	for raw, want := range cases {
+		if _, ok := NormalizeSymbol(raw); ok != want {
+			t.Fatalf("Expected %q valid=%v, got %v", raw, want, ok)
+		}
+	}
+}
+
+func TestHubFeeds(t *testing.T) {
+	fmt.Println("Testing Hub feed routing and broadcast functions")
+
+	crypto := &fakeFeed{prefix: "BINANCE:", subs: make(map[string]int)}
+	stocks := &fakeFeed{prefix: "", subs: make(map[string]int)}
+	h := newHub([]Feed{crypto, stocks})
+
+	a := NewClient(nil)
+	b := NewClient(nil)
+	h.Register(a)
+	h.Register(b)
+	h.Subscribe(a, []string{"BINANCE:BTCUSDT", "AAPL"})
+	h.Subscribe(b, []string{"BINANCE:BTCUSDT"})
+
+	// one upstream subscription however many clients watch
+	if got := crypto.subscribed("BINANCE:BTCUSDT"); got != 1 {
+		t.Fatalf("Expected BINANCE:BTCUSDT subscribed once on the crypto feed, got %d", got)
+	}
+	if got := stocks.subscribed("AAPL"); got != 1 {
+		t.Fatalf("Expected AAPL subscribed once on the stock feed, got %d", got)
+	}
+	if got := stocks.subscribed("BINANCE:BTCUSDT"); got != 0 {
+		t.Fatalf("Expected BINANCE:BTCUSDT not on the stock feed, got %d", got)
+	}
+
+	h.broadcast([]Tick{
+		{Symbol: "AAPL", Price: 190, Source: "fake"},
+		{Symbol: "BINANCE:BTCUSDT", Price: 60000, Source: "fake"},
+		{Symbol: "MSFT", Price: 400, Source: "fake"},
+	})
+	if len(a.queue) != 2 || len(b.queue) != 1 {
+		t.Fatalf("Expected 2 messages for a and 1 for b, got %d and %d", len(a.queue), len(b.queue))
+	}
+	var msg struct {
+		Type   string `json:"type"`
+		Symbol string `json:"symbol"`
+		Data   []Tick `json:"data"`
+	}
+	if err := json.Unmarshal(<-b.queue, &msg); err != nil || msg.Type != "trade" || msg.Symbol != "BINANCE:BTCUSDT" || msg.Data[0].Price != 60000 {
+		t.Fatalf("Expected a BINANCE:BTCUSDT trade at 60000, got %+v (%v)", msg, err)
+	}
+
+	h.Unsubscribe(a, []string{"BINANCE:BTCUSDT"})
+	if got := crypto.subscribed("BINANCE:BTCUSDT"); got != 1 {
+		t.Fatalf("Expected BINANCE:BTCUSDT kept while b watches, got %d", got)
+	}
+	h.Unsubscribe(b, []string{"BINANCE:BTCUSDT"})
+	if got := crypto.subscribed("BINANCE:BTCUSDT"); got != 0 {
+		t.Fatalf("Expected BINANCE:BTCUSDT released, got %d", got)
+	}
+}
+
+func TestClientEnqueue(t *testing.T) {
+	fmt.Println("Testing Client enqueue function")
+
+	client := NewClient(nil)
+	for i := 0; i < clientQueueSize; i++ {
+		client.enqueue("AAPL", []byte("queued"))
+	}
+	// the queue is full, these are coalesced to the latest per symbol
+	client.enqueue("AAPL", []byte("aapl 1"))
+	client.enqueue("MSFT", []byte("msft 1"))
+	client.enqueue("AAPL", []byte("aapl 2"))
+
+	if got := client.takeLatest(); got != nil {
+		t.Fatalf("Expected nothing until the queue drains, got %d messages", len(got))
+	}
+	for len(client.queue) > 0 {
+		<-client.queue
+	}
+
+	got := client.takeLatest()
+	if len(got) != 2 || string(got[0]) != "aapl 2" || string(got[1]) != "msft 1" {
+		t.Fatalf("Expected [aapl 2, msft 1], got %q", got)
+	}
+
+	// with the backlog cleared messages queue normally again
+	client.enqueue("AAPL", []byte("aapl 3"))
+	if len(client.queue) != 1 {
+		t.Fatalf("Expected 1 queued message, got %d", len(client.queue))
+	}
+}
+
+func TestClientEnqueueSlow(t *testing.T) {
+	fmt.Println("Testing Client enqueue slow client disconnect")
+
+	client := NewClient(nil)
+	for i := 0; i <= clientQueueSize; i++ {
+		client.enqueue("AAPL", []byte("queued"))
+	}
+	client.queueMu.Lock()
+	client.fullSince = time.Now().Add(-slowClientLimit - time.Second)
+	client.queueMu.Unlock()
+
+	client.enqueue("AAPL", []byte("late"))
+	select {
+	case <-client.kicked:
+	default:
+		t.Fatalf("Expected a client behind for %s to be kicked, got still connected", slowClientLimit)
+	}
+}